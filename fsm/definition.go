@@ -0,0 +1,248 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package fsm
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Definition describes an FSM in a configuration file, in YAML or JSON, for example
+//
+//	states:
+//	  - name: Deregistered
+//	    callback: deregistered
+//	  - name: Registered
+//	  - name: Idle
+//	    parent: Registered
+//	events: [Register, Deregister]
+//	transitions:
+//	  - {event: Register, from: Deregistered, to: Idle, guard: authenticated}
+//	  - {event: Deregister, from: Registered, to: Deregistered}
+//	timers:
+//	  - {state: Idle, event: Deregister, duration: 54m}
+//	initial: Deregistered
+//	strict: true
+//
+// The callbacks and guards are referred to by the names they are registered under in a
+// Registry, and every state and event must be declared.
+type Definition struct {
+	States      []StateDefinition      `yaml:"states"`
+	Events      []EventType            `yaml:"events"`
+	Transitions []TransitionDefinition `yaml:"transitions"`
+	Timers      []TimerDefinition      `yaml:"timers"`
+	// Initial and Terminal configure the Validation of the FSM
+	Initial  StateType   `yaml:"initial"`
+	Terminal []StateType `yaml:"terminal"`
+	// Strict makes NewFSM fail if the validation reports a problem
+	Strict bool `yaml:"strict"`
+}
+
+// StateDefinition declares a state with its Callback or ErrorCallback, and the composite
+// state it belongs to
+type StateDefinition struct {
+	Name          StateType `yaml:"name"`
+	Parent        StateType `yaml:"parent"`
+	Callback      string    `yaml:"callback"`
+	ErrorCallback string    `yaml:"errorCallback"`
+}
+
+// TransitionDefinition declares a Transition with its Guard
+type TransitionDefinition struct {
+	Event EventType `yaml:"event"`
+	From  StateType `yaml:"from"`
+	To    StateType `yaml:"to"`
+	Guard string    `yaml:"guard"`
+}
+
+// TimerDefinition declares a Timer, the duration is written like 6s or 1m30s
+type TimerDefinition struct {
+	State          StateType     `yaml:"state"`
+	Event          EventType     `yaml:"event"`
+	Duration       time.Duration `yaml:"duration"`
+	MaxRetries     int           `yaml:"maxRetries"`
+	ExhaustedEvent EventType     `yaml:"exhaustedEvent"`
+}
+
+// ParseDefinition parses a Definition in YAML or JSON, unknown fields are rejected
+func ParseDefinition(data []byte) (*Definition, error) {
+	definition := &Definition{}
+	if err := yaml.UnmarshalWithOptions(data, definition, yaml.DisallowUnknownField()); err != nil {
+		return nil, fmt.Errorf("invalid fsm definition: %w", err)
+	}
+	return definition, nil
+}
+
+// LoadDefinition reads the Definition in the file path
+func LoadDefinition(path string) (*Definition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDefinition(data)
+}
+
+// Validation returns the validation configured by the definition
+func (d *Definition) Validation() Validation {
+	return Validation{Initial: d.Initial, Terminal: d.Terminal, Events: d.Events}
+}
+
+// NewFSM creates the FSM of the definition with the callbacks and guards of registry.
+// The options are applied after the options derived from the definition.
+func (d *Definition) NewFSM(registry *Registry, opts ...Option) (*FSM, error) {
+	states := make(map[StateType]bool)
+	for _, s := range d.States {
+		if states[s.Name] {
+			return nil, fmt.Errorf("duplicate state: %s", s.Name)
+		}
+		states[s.Name] = true
+	}
+	events := make(map[EventType]bool)
+	for _, event := range d.Events {
+		events[event] = true
+	}
+	checkState := func(s StateType) error {
+		if !states[s] {
+			return fmt.Errorf("undeclared state: %s", s)
+		}
+		return nil
+	}
+	checkEvent := func(event EventType) error {
+		if !events[event] {
+			return fmt.Errorf("undeclared event: %s", event)
+		}
+		return nil
+	}
+
+	var definitionOpts []Option
+	callbacks := make(Callbacks)
+	errorCallbacks := make(ErrorCallbacks)
+	for _, s := range d.States {
+		if s.Parent != "" {
+			if err := checkState(s.Parent); err != nil {
+				return nil, err
+			}
+			definitionOpts = append(definitionOpts, WithSubstates(s.Parent, s.Name))
+		}
+		if s.Callback != "" {
+			callback, ok := registry.callback(s.Callback)
+			if !ok {
+				return nil, fmt.Errorf("unknown callback %s of state %s", s.Callback, s.Name)
+			}
+			callbacks[s.Name] = callback
+		}
+		if s.ErrorCallback != "" {
+			callback, ok := registry.errorCallback(s.ErrorCallback)
+			if !ok {
+				return nil, fmt.Errorf("unknown error callback %s of state %s", s.ErrorCallback, s.Name)
+			}
+			errorCallbacks[s.Name] = callback
+		}
+	}
+
+	transitions := make(Transitions, 0, len(d.Transitions))
+	for _, trans := range d.Transitions {
+		for _, err := range []error{checkEvent(trans.Event), checkState(trans.From), checkState(trans.To)} {
+			if err != nil {
+				return nil, err
+			}
+		}
+		transition := Transition{Event: trans.Event, From: trans.From, To: trans.To}
+		if trans.Guard != "" {
+			guard, ok := registry.guard(trans.Guard)
+			if !ok {
+				return nil, fmt.Errorf("unknown guard %s of transition[From: %s, Event: %s]", trans.Guard, trans.From,
+					trans.Event)
+			}
+			transition.Guard = guard
+		}
+		transitions = append(transitions, transition)
+	}
+
+	timers := make([]Timer, 0, len(d.Timers))
+	for _, timer := range d.Timers {
+		if err := checkState(timer.State); err != nil {
+			return nil, err
+		}
+		for _, event := range []EventType{timer.Event, timer.ExhaustedEvent} {
+			if event == "" {
+				continue
+			}
+			if err := checkEvent(event); err != nil {
+				return nil, err
+			}
+		}
+		timers = append(timers, Timer(timer))
+	}
+
+	definitionOpts = append(definitionOpts, WithErrorCallbacks(errorCallbacks), WithTimers(timers...))
+	if d.Strict {
+		definitionOpts = append(definitionOpts, WithStrictValidation(d.Validation()))
+	}
+	return NewFSM(transitions, callbacks, append(definitionOpts, opts...)...)
+}
+
+// Registry holds the callbacks and guards that definitions refer to by name
+type Registry struct {
+	mutex          sync.RWMutex
+	callbacks      map[string]Callback
+	errorCallbacks map[string]ErrorCallback
+	guards         map[string]Guard
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		callbacks:      make(map[string]Callback),
+		errorCallbacks: make(map[string]ErrorCallback),
+		guards:         make(map[string]Guard),
+	}
+}
+
+// RegisterCallback registers callback under name, replacing a callback registered before
+func (r *Registry) RegisterCallback(name string, callback Callback) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.callbacks[name] = callback
+}
+
+// RegisterErrorCallback registers callback under name, replacing a callback registered before
+func (r *Registry) RegisterErrorCallback(name string, callback ErrorCallback) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.errorCallbacks[name] = callback
+}
+
+// RegisterGuard registers guard under name, replacing a guard registered before
+func (r *Registry) RegisterGuard(name string, guard Guard) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.guards[name] = guard
+}
+
+func (r *Registry) callback(name string) (Callback, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	callback, ok := r.callbacks[name]
+	return callback, ok
+}
+
+func (r *Registry) errorCallback(name string) (ErrorCallback, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	callback, ok := r.errorCallbacks[name]
+	return callback, ok
+}
+
+func (r *Registry) guard(name string) (Guard, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	guard, ok := r.guards[name]
+	return guard, ok
+}
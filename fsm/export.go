@@ -0,0 +1,201 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package fsm
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// DiagramOptions configures the diagrams written by WriteDot, WriteMermaid and
+// WritePlantUML
+type DiagramOptions struct {
+	// Highlight is a state to emphasize, such as the current state of a State
+	Highlight StateType
+}
+
+// diagram holds the states and transitions of an FSM in the order they are drawn, so
+// that diagrams of the same FSM are identical
+type diagram struct {
+	// roots are the states that are not sub-states of a composite state
+	roots       []StateType
+	children    map[StateType][]StateType
+	ids         map[StateType]string
+	transitions []Transition
+	highlight   StateType
+}
+
+func newDiagram(fsm *FSM, opts DiagramOptions) *diagram {
+	d := &diagram{
+		children:  fsm.children(),
+		ids:       make(map[StateType]string),
+		highlight: opts.Highlight,
+	}
+	for i, s := range slices.Sorted(maps.Keys(fsm.states)) {
+		d.ids[s] = fmt.Sprintf("s%d", i)
+		if _, nested := fsm.parents[s]; !nested {
+			d.roots = append(d.roots, s)
+		}
+	}
+	keys := slices.SortedFunc(maps.Keys(fsm.transitions), func(a, b eventKey) int {
+		if c := strings.Compare(string(a.From), string(b.From)); c != 0 {
+			return c
+		}
+		return strings.Compare(string(a.Event), string(b.Event))
+	})
+	for _, key := range keys {
+		d.transitions = append(d.transitions, fsm.transitions[key]...)
+	}
+	return d
+}
+
+func (d *diagram) composite(s StateType) bool {
+	_, ok := d.children[s]
+	return ok
+}
+
+func transitionLabel(trans Transition) string {
+	if trans.Guard != nil {
+		return string(trans.Event) + " [guarded]"
+	}
+	return string(trans.Event)
+}
+
+// WriteDot writes fsm in DOT format to w, which can be visualized by graphviz. Composite
+// states are drawn as clusters around their sub-states.
+func WriteDot(w io.Writer, fsm *FSM, opts DiagramOptions) error {
+	d := newDiagram(fsm, opts)
+	var b strings.Builder
+	b.WriteString("digraph FSM {\n")
+	b.WriteString("\trankdir=LR\n")
+	b.WriteString("\tsize=\"100\"\n")
+	b.WriteString("\tcompound=true\n")
+	b.WriteString("\tnode [width=1 fixedsize=false shape=ellipse style=filled fillcolor=\"skyblue\"]\n")
+	for _, s := range d.roots {
+		d.writeDotState(&b, s, "\t")
+	}
+	for _, trans := range d.transitions {
+		attributes := "label=" + dotQuote(transitionLabel(trans))
+		// edges of a composite state end at the border of its cluster
+		if d.composite(trans.From) {
+			attributes += " ltail=" + dotQuote("cluster_"+string(trans.From))
+		}
+		if d.composite(trans.To) {
+			attributes += " lhead=" + dotQuote("cluster_"+string(trans.To))
+		}
+		fmt.Fprintf(&b, "\t%s -> %s [%s]\n", dotQuote(string(trans.From)), dotQuote(string(trans.To)), attributes)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeDotState writes a state, or the cluster of a composite state. The composite state
+// itself is an invisible node of its cluster, which anchors the edges of its transitions.
+func (d *diagram) writeDotState(b *strings.Builder, s StateType, indent string) {
+	name := dotQuote(string(s))
+	if !d.composite(s) {
+		if s == d.highlight {
+			fmt.Fprintf(b, "%s%s [fillcolor=\"orange\" penwidth=2]\n", indent, name)
+		} else {
+			fmt.Fprintf(b, "%s%s\n", indent, name)
+		}
+		return
+	}
+	fmt.Fprintf(b, "%ssubgraph %s {\n", indent, dotQuote("cluster_"+string(s)))
+	fmt.Fprintf(b, "%s\tlabel=%s\n", indent, name)
+	if s == d.highlight {
+		fmt.Fprintf(b, "%s\tcolor=\"orange\"\n%s\tpenwidth=2\n", indent, indent)
+	}
+	fmt.Fprintf(b, "%s\t%s [shape=point style=invis]\n", indent, name)
+	for _, child := range d.children[s] {
+		d.writeDotState(b, child, indent+"\t")
+	}
+	fmt.Fprintf(b, "%s}\n", indent)
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// WriteMermaid writes fsm as a Mermaid stateDiagram to w. States are declared with
+// generated identifiers and their names as descriptions, so names may contain spaces.
+func WriteMermaid(w io.Writer, fsm *FSM, opts DiagramOptions) error {
+	d := newDiagram(fsm, opts)
+	var b strings.Builder
+	b.WriteString("stateDiagram-v2\n")
+	for _, s := range d.roots {
+		d.writeMermaidState(&b, s, "    ")
+	}
+	for _, trans := range d.transitions {
+		fmt.Fprintf(&b, "    %s --> %s : %s\n", d.ids[trans.From], d.ids[trans.To], mermaidText(transitionLabel(trans)))
+	}
+	if id, ok := d.ids[d.highlight]; ok {
+		b.WriteString("    classDef highlight fill:orange,stroke-width:2px\n")
+		fmt.Fprintf(&b, "    class %s highlight\n", id)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (d *diagram) writeMermaidState(b *strings.Builder, s StateType, indent string) {
+	fmt.Fprintf(b, "%sstate \"%s\" as %s", indent, mermaidText(string(s)), d.ids[s])
+	if !d.composite(s) {
+		b.WriteString("\n")
+		return
+	}
+	b.WriteString(" {\n")
+	for _, child := range d.children[s] {
+		d.writeMermaidState(b, child, indent+"    ")
+	}
+	fmt.Fprintf(b, "%s}\n", indent)
+}
+
+// mermaidText replaces the characters that end a name or label with entity codes
+func mermaidText(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", ";", "#59;", "\n", " ").Replace(s)
+}
+
+// WritePlantUML writes fsm as a PlantUML state diagram to w. States are declared with
+// generated identifiers and their names as descriptions, so names may contain spaces.
+func WritePlantUML(w io.Writer, fsm *FSM, opts DiagramOptions) error {
+	d := newDiagram(fsm, opts)
+	var b strings.Builder
+	b.WriteString("@startuml\n")
+	b.WriteString("hide empty description\n")
+	for _, s := range d.roots {
+		d.writePlantUMLState(&b, s, "")
+	}
+	for _, trans := range d.transitions {
+		fmt.Fprintf(&b, "%s --> %s : %s\n", d.ids[trans.From], d.ids[trans.To], plantUMLText(transitionLabel(trans)))
+	}
+	b.WriteString("@enduml\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (d *diagram) writePlantUMLState(b *strings.Builder, s StateType, indent string) {
+	fmt.Fprintf(b, "%sstate \"%s\" as %s", indent, plantUMLText(string(s)), d.ids[s])
+	if s == d.highlight {
+		b.WriteString(" #orange")
+	}
+	if !d.composite(s) {
+		b.WriteString("\n")
+		return
+	}
+	b.WriteString(" {\n")
+	for _, child := range d.children[s] {
+		d.writePlantUMLState(b, child, indent+"  ")
+	}
+	fmt.Fprintf(b, "%s}\n", indent)
+}
+
+// plantUMLText replaces the characters that end a name or label
+func plantUMLText(s string) string {
+	return strings.NewReplacer(`"`, "'", "\n", `\n`).Replace(s)
+}
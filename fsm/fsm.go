@@ -6,6 +6,7 @@ package fsm
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -23,13 +24,63 @@ type (
 	Callbacks map[StateType]Callback
 )
 
+// ErrorCallback is a Callback that can fail. A failed event or exit callback aborts the
+// transition before the state changes, the failure of an entry callback is handled
+// according to the EntryFailurePolicy of the FSM.
+type (
+	ErrorCallback  func(context.Context, *State, EventType, ArgsType) error
+	ErrorCallbacks map[StateType]ErrorCallback
+)
+
+// EntryFailurePolicy selects what happens when the entry callback of a transition fails
+type EntryFailurePolicy int
+
+const (
+	// EntryFailureStay keeps the new state of the transition and starts its timers
+	EntryFailureStay EntryFailurePolicy = iota
+	// EntryFailureRollback restores the state the transition started from, without
+	// running callbacks, and leaves its timers running
+	EntryFailureRollback
+	// EntryFailureErrorState moves to the error state of the FSM and runs its entry callback
+	EntryFailureErrorState
+)
+
+// TransitionError reports the callback that failed during a transition
+type TransitionError struct {
+	Event EventType
+	From  StateType
+	To    StateType
+	// Callback is the event of the failed callback: the event of the transition, ExitEvent or EntryEvent
+	Callback EventType
+	Err      error
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("transition[From: %s, To: %s, Event: %s] callback [%s] failed: %v",
+		e.From, e.To, e.Event, e.Callback, e.Err)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return e.Err
+}
+
+// Guard decides whether a transition is taken, it is called with the args of the event
+// before any callback runs and must not change the state
+type Guard func(ctx context.Context, state *State, args ArgsType) bool
+
 // Transition defines a transition
 // that a Event is triggered at From state,
 // and transfer to To state after the Event
+//
+// Several transitions may share an Event and From state if they have guards, they
+// are evaluated in the order they are passed to NewFSM and the first one whose Guard
+// returns true is taken. A transition without Guard is always taken, so it may only be
+// the last of its Event and From state, as a fallback.
 type Transition struct {
 	Event EventType
 	From  StateType
 	To    StateType
+	Guard Guard
 }
 
 type Transitions []Transition
@@ -46,40 +97,100 @@ const (
 )
 
 type FSM struct {
-	// transitions stores one transition for each event
-	transitions map[eventKey]Transition
+	// transitions stores the candidate transitions of each event in order of evaluation
+	transitions map[eventKey][]Transition
 	// callbacks stores one callback function for one state
-	callbacks map[StateType]Callback
+	callbacks map[StateType]ErrorCallback
+	// errorCallbacks are the error returning callbacks passed with WithErrorCallbacks
+	errorCallbacks     ErrorCallbacks
+	entryFailurePolicy EntryFailurePolicy
+	errorState         StateType
+	// substates are the sub-states of each composite state passed with WithSubstates
+	substates map[StateType][]StateType
+	// parents stores the composite state of each sub-state
+	parents map[StateType]StateType
+	// timers stores the timers of each state
+	timers map[StateType][]Timer
+	clock  Clock
+	hooks  []Hooks
+	// historySize is the number of transitions kept in the history of each State
+	historySize int
+	// states are all states of the transitions, sub-states and callbacks
+	states map[StateType]bool
+	// validation is the strict validation passed with WithStrictValidation
+	validation *Validation
 }
 
 // NewFSM create a new FSM object then registers transitions and callbacks to it
-func NewFSM(transitions Transitions, callbacks Callbacks) (*FSM, error) {
+func NewFSM(transitions Transitions, callbacks Callbacks, opts ...Option) (*FSM, error) {
 	fsm := &FSM{
-		transitions: make(map[eventKey]Transition),
-		callbacks:   make(map[StateType]Callback),
+		transitions: make(map[eventKey][]Transition),
+		callbacks:   make(map[StateType]ErrorCallback),
+		substates:   make(map[StateType][]StateType),
+		parents:     make(map[StateType]StateType),
+		timers:      make(map[StateType][]Timer),
+		clock:       realClock{},
+	}
+	for _, opt := range opts {
+		opt(fsm)
 	}
 
 	allStates := make(map[StateType]bool)
+	if err := fsm.buildHierarchy(allStates); err != nil {
+		return nil, err
+	}
+	if fsm.entryFailurePolicy == EntryFailureErrorState {
+		if fsm.errorState == "" {
+			return nil, fmt.Errorf("entry failure policy requires an error state")
+		}
+		allStates[fsm.errorState] = true
+	}
 
 	for _, transition := range transitions {
 		key := eventKey{
 			Event: transition.Event,
 			From:  transition.From,
 		}
-		if _, ok := fsm.transitions[key]; ok {
-			return nil, fmt.Errorf("duplicate transition: %+v", transition)
-		} else {
-			fsm.transitions[key] = transition
-			allStates[transition.From] = true
-			allStates[transition.To] = true
+		candidates := fsm.transitions[key]
+		if len(candidates) > 0 && candidates[len(candidates)-1].Guard == nil {
+			// an unguarded transition is always taken, so a later candidate would be unreachable
+			if transition.Guard == nil {
+				return nil, fmt.Errorf("duplicate transition: %+v", transition)
+			}
+			return nil, fmt.Errorf("guarded transition after unguarded transition: %+v", transition)
 		}
+		fsm.transitions[key] = append(candidates, transition)
+		allStates[transition.From] = true
+		allStates[transition.To] = true
+	}
+	if err := fsm.validateTimers(allStates); err != nil {
+		return nil, err
 	}
 
 	for state, callback := range callbacks {
 		if _, ok := allStates[state]; !ok {
 			return nil, fmt.Errorf("unknown state: %+v", state)
 		} else {
-			fsm.callbacks[state] = callback
+			fsm.callbacks[state] = func(ctx context.Context, s *State, event EventType, args ArgsType) error {
+				callback(ctx, s, event, args)
+				return nil
+			}
+		}
+	}
+	for state, callback := range fsm.errorCallbacks {
+		if _, ok := allStates[state]; !ok {
+			return nil, fmt.Errorf("unknown state: %+v", state)
+		}
+		if _, ok := fsm.callbacks[state]; ok {
+			return nil, fmt.Errorf("duplicate callback: %+v", state)
+		}
+		fsm.callbacks[state] = callback
+	}
+	fsm.states = allStates
+
+	if fsm.validation != nil {
+		if report := fsm.Validate(*fsm.validation); !report.Valid() {
+			return nil, report.Err()
 		}
 	}
 	return fsm, nil
@@ -90,60 +201,181 @@ func NewFSM(transitions Transitions, callbacks Callbacks) (*FSM, error) {
 //   - on exit callback: call when fsm leave one state, with ExitEvent event
 //   - event callback: call when user trigger a user-defined event
 //   - on entry callback: call when fsm enter one state, with EntryEvent event
+//
+// The failure of a callback is returned as a *TransitionError.
+//
+// Events sent to the same State are processed one at a time, each with all of its
+// callbacks, so concurrent senders never act on a stale state. Callbacks that send
+// events to their own State must pass on the ctx they received: such events are queued
+// and processed in order after the current transition completes, and their errors are
+// returned by the SendEvent that started the transition. Sending to the same State
+// with another ctx from within a callback deadlocks.
 func (fsm *FSM) SendEvent(ctx context.Context, state *State, event EventType, args ArgsType) error {
-	key := eventKey{
-		From:  state.Current(),
-		Event: event,
+	key := dispatchKey{state: state}
+	if queue, ok := ctx.Value(key).(*eventQueue); ok && queue.push(fsm, event, args) {
+		return nil
 	}
+	return dispatch(ctx, state, func(ctx context.Context) error {
+		return fsm.handleEvent(ctx, state, event, args)
+	})
+}
 
-	if trans, ok := fsm.transitions[key]; ok {
-		logger.FsmLog.Infof("handle event[%s], transition from [%s] to [%s]", event, trans.From, trans.To)
+// dispatch runs handle with exclusive access to state, followed by the events queued
+// by its callbacks
+func dispatch(ctx context.Context, state *State, handle func(context.Context) error) error {
+	state.dispatchMutex.Lock()
+	defer state.dispatchMutex.Unlock()
+	queue := &eventQueue{}
+	ctx = context.WithValue(ctx, dispatchKey{state: state}, queue)
+	err := handle(ctx)
+	for {
+		queued, ok := queue.pop()
+		if !ok {
+			return err
+		}
+		err = errors.Join(err, queued.fsm.handleEvent(ctx, state, queued.event, queued.args))
+	}
+}
+
+// handleEvent runs the transition of event from the current state with its callbacks
+func (fsm *FSM) handleEvent(ctx context.Context, state *State, event EventType, args ArgsType) error {
+	current := state.Current()
+	trans, err := fsm.findTransition(ctx, state, current, event, args)
+	if err != nil {
+		fsm.eventRejected(ctx, state, event, args, err)
+		return err
+	}
+	logger.FsmLog.Debugf("handle event[%s], transition from [%s] to [%s]", event, trans.From, trans.To)
+
+	fsm.beforeTransition(ctx, state, trans, args)
+	start := fsm.clock.Now()
+	err = fsm.transit(ctx, state, current, trans, args)
+	fsm.afterTransition(ctx, state, TransitionRecord{
+		Event:     event,
+		From:      current,
+		To:        state.Current(),
+		Timestamp: start,
+		Duration:  fsm.clock.Now().Sub(start),
+		Err:       err,
+	})
+	return err
+}
 
-		// event callback
-		fsm.callbacks[trans.From](ctx, state, event, args)
+// transit runs the callbacks of trans taken at the current state and changes the state
+func (fsm *FSM) transit(ctx context.Context, state *State, current StateType, trans Transition, args ArgsType) error {
+	event := trans.Event
+	// event callback
+	if err := fsm.call(ctx, trans.From, state, event, args); err != nil {
+		return &TransitionError{Event: event, From: trans.From, To: trans.To, Callback: event, Err: err}
+	}
+	if trans.From == trans.To {
+		return nil
+	}
+	exits, entries := fsm.transitionPath(current, trans)
 
-		// exit callback
-		if trans.From != trans.To {
-			fsm.callbacks[trans.From](ctx, state, ExitEvent, args)
+	// exit callbacks
+	for _, exited := range exits {
+		if err := fsm.call(ctx, exited, state, ExitEvent, args); err != nil {
+			return &TransitionError{Event: event, From: trans.From, To: trans.To, Callback: ExitEvent, Err: err}
 		}
+	}
 
-		// entry callback
-		if trans.From != trans.To {
-			state.Set(trans.To)
-			fsm.callbacks[trans.To](ctx, state, EntryEvent, args)
+	// entry callbacks, the timers of the exited states keep running until they succeed so
+	// that a rollback restores them, and their expiries wait for the transition to finish
+	state.Set(trans.To)
+	for _, entered := range entries {
+		if err := fsm.call(ctx, entered, state, EntryEvent, args); err != nil {
+			return fsm.entryFailed(ctx, state, current, exits, entries, trans, args,
+				&TransitionError{Event: event, From: trans.From, To: trans.To, Callback: EntryEvent, Err: err})
 		}
-		return nil
-	} else {
-		return fmt.Errorf("unknown transition[From: %s, Event: %s]", state.Current(), event)
 	}
+	state.stopTimers(exits...)
+	fsm.startTimers(ctx, state, entries...)
+	return nil
 }
 
-// ExportDot export fsm in dot format to outfile, which can be visualized by graphviz
-func ExportDot(fsm *FSM, outfile string) error {
-	dot := `digraph FSM {
-	rankdir=LR
-	size="100"
-    node[width=1 fixedsize=false shape=ellipse style=filled fillcolor="skyblue"]
-	`
+// findTransition returns the transition of event from current, or from the closest of
+// its ancestors that handles event
+func (fsm *FSM) findTransition(ctx context.Context, state *State, current StateType, event EventType,
+	args ArgsType,
+) (Transition, error) {
+	guarded := false
+	for from, ok := current, true; ok; from, ok = fsm.parents[from] {
+		candidates, found := fsm.transitions[eventKey{Event: event, From: from}]
+		if !found {
+			continue
+		}
+		if trans, ok := selectTransition(ctx, state, candidates, args); ok {
+			return trans, nil
+		}
+		guarded = true
+	}
+	if guarded {
+		return Transition{}, fmt.Errorf("no guard passed for transition[From: %s, Event: %s]", current, event)
+	}
+	return Transition{}, fmt.Errorf("unknown transition[From: %s, Event: %s]", current, event)
+}
 
-	for _, trans := range fsm.transitions {
-		link := fmt.Sprintf("\t%s -> %s [label=\"%s\"]", trans.From, trans.To, trans.Event)
-		dot = dot + "\r\n" + link
+// call runs the callback of stateType, if it has one
+func (fsm *FSM) call(ctx context.Context, stateType StateType, state *State, event EventType, args ArgsType) error {
+	callback, ok := fsm.callbacks[stateType]
+	if !ok {
+		return nil
 	}
+	return callback(ctx, state, event, args)
+}
 
-	dot = dot + "\r\n}\n"
+// entryFailed applies the entry failure policy after an entry callback of trans, taken at
+// the state from, exiting the states exits and entering the states entries, failed with err
+func (fsm *FSM) entryFailed(ctx context.Context, state *State, from StateType, exits, entries []StateType,
+	trans Transition, args ArgsType, err error,
+) error {
+	if fsm.entryFailurePolicy != EntryFailureRollback {
+		state.stopTimers(exits...)
+	}
+	switch fsm.entryFailurePolicy {
+	case EntryFailureStay:
+		fsm.startTimers(ctx, state, entries...)
+	case EntryFailureRollback:
+		state.Set(from)
+	case EntryFailureErrorState:
+		state.Set(fsm.errorState)
+		if entryErr := fsm.call(ctx, fsm.errorState, state, EntryEvent, args); entryErr != nil {
+			return errors.Join(err, &TransitionError{
+				Event: trans.Event, From: trans.To, To: fsm.errorState, Callback: EntryEvent, Err: entryErr,
+			})
+		}
+		fsm.startTimers(ctx, state, fsm.errorState)
+	}
+	return err
+}
 
+// selectTransition returns the first candidate whose guard passes
+func selectTransition(ctx context.Context, state *State, candidates []Transition, args ArgsType) (Transition, bool) {
+	for _, candidate := range candidates {
+		if candidate.Guard == nil || candidate.Guard(ctx, state, args) {
+			return candidate, true
+		}
+	}
+	return Transition{}, false
+}
+
+// ExportDot export fsm in dot format to outfile, which can be visualized by graphviz.
+// The extension .dot is appended to outfile if it is missing, WriteDot writes the same
+// diagram to an io.Writer.
+func ExportDot(fsm *FSM, outfile string) error {
 	if !strings.HasSuffix(outfile, ".dot") {
 		outfile = fmt.Sprintf("%s.dot", outfile)
 	}
 
-	if file, err := os.Create(outfile); err != nil {
+	file, err := os.Create(outfile)
+	if err != nil {
+		return err
+	}
+	if err := WriteDot(file, fsm, DiagramOptions{}); err != nil {
+		file.Close()
 		return err
-	} else {
-		if _, err = file.WriteString(dot); err != nil {
-			return err
-		}
-		fmt.Printf("Output the FSM to \"%s\"\n", outfile)
-		return file.Close()
 	}
+	logger.FsmLog.Debugf("output the FSM to %s", outfile)
+	return file.Close()
 }
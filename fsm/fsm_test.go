@@ -6,8 +6,17 @@ package fsm
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 const (
@@ -137,3 +146,811 @@ func TestFSMInitFail(t *testing.T) {
 		t.Errorf("NewFSM() error mismatch: expected %q, got %q", expectedError, err.Error())
 	}
 }
+
+func TestGuardedTransitions(t *testing.T) {
+	const (
+		Deregistered StateType = "Deregistered"
+		Registered   StateType = "Registered"
+		Rejected     StateType = "Rejected"
+		Register     EventType = "Register"
+	)
+	accepted := func(ctx context.Context, state *State, args ArgsType) bool {
+		return args["accepted"] == true
+	}
+	var entered []StateType
+	callback := func(ctx context.Context, state *State, event EventType, args ArgsType) {
+		if event == EntryEvent {
+			entered = append(entered, state.Current())
+		}
+	}
+	f, err := NewFSM(Transitions{
+		{Event: Register, From: Deregistered, To: Registered, Guard: accepted},
+		{Event: Register, From: Deregistered, To: Rejected},
+		{Event: Register, From: Rejected, To: Registered, Guard: accepted},
+	}, Callbacks{Deregistered: callback, Registered: callback, Rejected: callback})
+	if err != nil {
+		t.Fatalf("NewFSM() failed: %v", err)
+	}
+
+	ctx := context.Background()
+	s := NewState(Deregistered)
+	if err := f.SendEvent(ctx, s, Register, ArgsType{"accepted": false}); err != nil {
+		t.Fatalf("SendEvent() failed: %v", err)
+	}
+	if !s.Is(Rejected) {
+		t.Fatalf("expected fallback to Rejected, got %s", s.Current())
+	}
+
+	expectedError := fmt.Sprintf("no guard passed for transition[From: %s, Event: %s]", Rejected, Register)
+	if err := f.SendEvent(ctx, s, Register, ArgsType{"accepted": false}); err == nil || err.Error() != expectedError {
+		t.Errorf("SendEvent() error mismatch: expected %q, got %v", expectedError, err)
+	}
+	if !s.Is(Rejected) {
+		t.Errorf("state should not change when no guard passes, got %s", s.Current())
+	}
+
+	if err := f.SendEvent(ctx, s, Register, ArgsType{"accepted": true}); err != nil {
+		t.Fatalf("SendEvent() failed: %v", err)
+	}
+	if !s.Is(Registered) || len(entered) != 2 || entered[1] != Registered {
+		t.Errorf("expected Registered to be entered, got %s with entries %v", s.Current(), entered)
+	}
+}
+
+func TestGuardedTransitionsAmbiguous(t *testing.T) {
+	guard := func(ctx context.Context, state *State, args ArgsType) bool { return true }
+	callback := func(ctx context.Context, state *State, event EventType, args ArgsType) {}
+	_, err := NewFSM(Transitions{
+		{Event: Open, From: Closed, To: Closed},
+		{Event: Open, From: Closed, To: Opened, Guard: guard},
+	}, Callbacks{Opened: callback, Closed: callback})
+	if err == nil || !strings.HasPrefix(err.Error(), "guarded transition after unguarded transition") {
+		t.Errorf("NewFSM() should reject a guarded transition after an unguarded one, got %v", err)
+	}
+}
+
+func TestErrorCallbacks(t *testing.T) {
+	const (
+		Broken StateType = "Broken"
+		Fail   EventType = "Fail"
+	)
+	errCallback := errors.New("callback failed")
+	newFSM := func(failOn EventType, failIn StateType, opts ...Option) *FSM {
+		callback := func(state StateType) ErrorCallback {
+			return func(ctx context.Context, s *State, event EventType, args ArgsType) error {
+				if event == failOn && state == failIn {
+					return errCallback
+				}
+				return nil
+			}
+		}
+		f, err := NewFSM(Transitions{
+			{Event: Open, From: Closed, To: Opened},
+			{Event: Fail, From: Opened, To: Broken},
+		}, nil, append(opts, WithErrorCallbacks(ErrorCallbacks{
+			Opened: callback(Opened), Closed: callback(Closed), Broken: callback(Broken),
+		}))...)
+		if err != nil {
+			t.Fatalf("NewFSM() failed: %v", err)
+		}
+		return f
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		failOn   EventType
+		failIn   StateType
+		opts     []Option
+		expected StateType
+	}{
+		{"event callback aborts", Open, Closed, nil, Closed},
+		{"exit callback aborts", ExitEvent, Closed, nil, Closed},
+		{"entry failure stays", EntryEvent, Opened, nil, Opened},
+		{"entry failure rolls back", EntryEvent, Opened, []Option{WithEntryFailurePolicy(EntryFailureRollback, "")}, Closed},
+		{"entry failure goes to error state", EntryEvent, Opened, []Option{WithEntryFailurePolicy(EntryFailureErrorState, Broken)}, Broken},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewState(Closed)
+			err := newFSM(tc.failOn, tc.failIn, tc.opts...).SendEvent(ctx, s, Open, nil)
+			var transitionErr *TransitionError
+			if !errors.As(err, &transitionErr) || !errors.Is(err, errCallback) {
+				t.Fatalf("expected TransitionError wrapping the callback error, got %v", err)
+			}
+			if transitionErr.From != Closed || transitionErr.To != Opened || transitionErr.Callback != tc.failOn {
+				t.Errorf("unexpected transition error %+v", transitionErr)
+			}
+			if !s.Is(tc.expected) {
+				t.Errorf("expected state %s, got %s", tc.expected, s.Current())
+			}
+		})
+	}
+
+	if _, err := NewFSM(Transitions{{Event: Open, From: Closed, To: Opened}}, nil,
+		WithEntryFailurePolicy(EntryFailureErrorState, "")); err == nil {
+		t.Error("NewFSM() should require an error state")
+	}
+	noop := func(ctx context.Context, s *State, event EventType, args ArgsType) {}
+	_, err := NewFSM(Transitions{{Event: Open, From: Closed, To: Opened}}, Callbacks{Closed: noop},
+		WithErrorCallbacks(ErrorCallbacks{Closed: func(ctx context.Context, s *State, event EventType, args ArgsType) error {
+			return nil
+		}}))
+	if err == nil || err.Error() != fmt.Sprintf("duplicate callback: %+v", Closed) {
+		t.Errorf("NewFSM() should reject two callbacks for a state, got %v", err)
+	}
+}
+
+func TestSendEventSerialized(t *testing.T) {
+	var inFlight, overlaps, transitions atomic.Int32
+	callback := func(ctx context.Context, state *State, event EventType, args ArgsType) {
+		if event != Open && event != Close {
+			return
+		}
+		if inFlight.Add(1) > 1 {
+			overlaps.Add(1)
+		}
+		time.Sleep(time.Millisecond)
+		transitions.Add(1)
+		inFlight.Add(-1)
+	}
+	f, err := NewFSM(Transitions{
+		{Event: Open, From: Closed, To: Opened},
+		{Event: Close, From: Opened, To: Closed},
+	}, Callbacks{Opened: callback, Closed: callback})
+	if err != nil {
+		t.Fatalf("NewFSM() failed: %v", err)
+	}
+
+	s := NewState(Closed)
+	var wg sync.WaitGroup
+	var rejected atomic.Int32
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			event := Open
+			if i%2 == 1 {
+				event = Close
+			}
+			if err := f.SendEvent(context.Background(), s, event, nil); err != nil {
+				rejected.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if overlaps.Load() != 0 {
+		t.Errorf("%d transitions of the same State overlapped", overlaps.Load())
+	}
+	if transitions.Load()+rejected.Load() != 20 {
+		t.Errorf("expected every event to be handled or rejected, got %d transitions and %d rejections",
+			transitions.Load(), rejected.Load())
+	}
+}
+
+func TestSendEventFromCallback(t *testing.T) {
+	var f *FSM
+	var calls []string
+	record := func(ctx context.Context, state *State, event EventType, args ArgsType) {
+		calls = append(calls, fmt.Sprintf("%s/%s", state.Current(), event))
+		if state.Is(Opened) && event == EntryEvent {
+			// queued until the transition to Opened has completed
+			if err := f.SendEvent(ctx, state, Close, nil); err != nil {
+				t.Errorf("SendEvent() from callback failed: %v", err)
+			}
+			calls = append(calls, "queued")
+		}
+	}
+	var err error
+	f, err = NewFSM(Transitions{
+		{Event: Open, From: Closed, To: Opened},
+		{Event: Close, From: Opened, To: Closed},
+	}, Callbacks{Opened: record, Closed: record})
+	if err != nil {
+		t.Fatalf("NewFSM() failed: %v", err)
+	}
+
+	s := NewState(Closed)
+	if err := f.SendEvent(context.Background(), s, Open, nil); err != nil {
+		t.Fatalf("SendEvent() failed: %v", err)
+	}
+	expected := []string{
+		"Closed/Open", "Closed/Exit event", "Opened/Entry event", "queued",
+		"Opened/Close", "Opened/Exit event", "Closed/Entry event",
+	}
+	if !slices.Equal(calls, expected) {
+		t.Errorf("callbacks %v, expected %v", calls, expected)
+	}
+	if !s.Is(Closed) {
+		t.Errorf("expected Closed, got %s", s.Current())
+	}
+}
+
+func TestHierarchicalStates(t *testing.T) {
+	const (
+		Deregistered StateType = "Deregistered"
+		Registered   StateType = "Registered"
+		Idle         StateType = "Idle"
+		Connected    StateType = "Connected"
+	)
+	const (
+		Register   EventType = "Register"
+		Connect    EventType = "Connect"
+		Release    EventType = "Release"
+		Deregister EventType = "Deregister"
+	)
+	var calls []string
+	record := func(ctx context.Context, state *State, event EventType, args ArgsType) {
+		calls = append(calls, string(event))
+	}
+	callbacks := Callbacks{}
+	for _, s := range []StateType{Deregistered, Registered, Idle, Connected} {
+		callbacks[s] = func(ctx context.Context, state *State, event EventType, args ArgsType) {
+			record(ctx, state, EventType(fmt.Sprintf("%s/%s", s, event)), args)
+		}
+	}
+	f, err := NewFSM(Transitions{
+		{Event: Register, From: Deregistered, To: Idle},
+		{Event: Connect, From: Idle, To: Connected},
+		{Event: Release, From: Connected, To: Idle},
+		{Event: Deregister, From: Registered, To: Deregistered},
+		{Event: Register, From: Registered, To: Registered},
+	}, callbacks, WithSubstates(Registered, Idle, Connected))
+	if err != nil {
+		t.Fatalf("NewFSM() failed: %v", err)
+	}
+
+	tests := []struct {
+		event    EventType
+		expected []string
+		state    StateType
+	}{
+		{Register, []string{"Deregistered/Register", "Deregistered/Exit event", "Registered/Entry event", "Idle/Entry event"}, Idle},
+		{Connect, []string{"Idle/Connect", "Idle/Exit event", "Connected/Entry event"}, Connected},
+		// handled by the parent without leaving the sub-state
+		{Register, []string{"Registered/Register"}, Connected},
+		// bubbles to the parent
+		{Deregister, []string{"Registered/Deregister", "Connected/Exit event", "Registered/Exit event", "Deregistered/Entry event"}, Deregistered},
+	}
+	s := NewState(Deregistered)
+	for _, tc := range tests {
+		calls = nil
+		if err := f.SendEvent(context.Background(), s, tc.event, nil); err != nil {
+			t.Fatalf("SendEvent(%s) failed: %v", tc.event, err)
+		}
+		if !slices.Equal(calls, tc.expected) {
+			t.Errorf("SendEvent(%s) callbacks %v, expected %v", tc.event, calls, tc.expected)
+		}
+		if !s.Is(tc.state) {
+			t.Errorf("SendEvent(%s) state %s, expected %s", tc.event, s.Current(), tc.state)
+		}
+	}
+
+	if err := f.SendEvent(context.Background(), s, Release, nil); err == nil ||
+		err.Error() != "unknown transition[From: Deregistered, Event: Release]" {
+		t.Errorf("unexpected error %v", err)
+	}
+	s.Set(Connected)
+	if !f.In(s, Registered) || !f.In(s, Connected) || f.In(s, Idle) {
+		t.Error("In() failed for sub-state Connected")
+	}
+	if parent, ok := f.Parent(Idle); !ok || parent != Registered {
+		t.Errorf("Parent(Idle) = %s, %v", parent, ok)
+	}
+
+	outfile := t.TempDir() + "/hierarchy.dot"
+	if err := ExportDot(f, outfile); err != nil {
+		t.Fatalf("ExportDot() failed: %v", err)
+	}
+	dot, err := os.ReadFile(outfile)
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+	for _, expected := range []string{
+		"subgraph \"cluster_Registered\" {",
+		"\"Registered\" -> \"Deregistered\" [label=\"Deregister\" ltail=\"cluster_Registered\"]",
+		"\t\t\"Idle\"\n",
+	} {
+		if !strings.Contains(string(dot), expected) {
+			t.Errorf("ExportDot() output misses %q:\n%s", expected, dot)
+		}
+	}
+}
+
+func TestInvalidSubstates(t *testing.T) {
+	transitions := Transitions{{Event: Open, From: Closed, To: Opened}}
+	if _, err := NewFSM(transitions, nil, WithSubstates("A", Opened), WithSubstates("B", Opened)); err == nil {
+		t.Error("expected error for a state with two parents")
+	}
+	if _, err := NewFSM(transitions, nil, WithSubstates("A", "B"), WithSubstates("B", "A")); err == nil {
+		t.Error("expected error for cyclic substates")
+	}
+}
+
+func TestTimers(t *testing.T) {
+	const (
+		Idle    StateType = "Idle"
+		Waiting StateType = "Waiting"
+		Done    StateType = "Done"
+	)
+	const (
+		Start      EventType = "Start"
+		Retransmit EventType = "Retransmit"
+		Abort      EventType = "Abort"
+		Complete   EventType = "Complete"
+	)
+	clock := NewManualClock(time.Unix(0, 0))
+	var calls []string
+	f, err := NewFSM(Transitions{
+		{Event: Start, From: Idle, To: Waiting},
+		{Event: Retransmit, From: Waiting, To: Waiting},
+		{Event: Abort, From: Waiting, To: Idle},
+		{Event: Complete, From: Waiting, To: Done},
+	}, Callbacks{
+		Waiting: func(ctx context.Context, state *State, event EventType, args ArgsType) {
+			if event == Retransmit || event == Abort {
+				calls = append(calls, fmt.Sprintf("%s %v at %s", event, args[TimerExpiries], clock.Now().Format(time.TimeOnly)))
+			}
+		},
+	}, WithClock(clock), WithTimers(Timer{
+		State: Waiting, Event: Retransmit, Duration: time.Second, MaxRetries: 2, ExhaustedEvent: Abort,
+	}))
+	if err != nil {
+		t.Fatalf("NewFSM() failed: %v", err)
+	}
+
+	s := NewState(Idle)
+	if err := f.SendEvent(context.Background(), s, Start, nil); err != nil {
+		t.Fatalf("SendEvent() failed: %v", err)
+	}
+	clock.Advance(10 * time.Second)
+	expected := []string{"Retransmit 1 at 00:00:01", "Retransmit 2 at 00:00:02", "Abort 3 at 00:00:03"}
+	if !slices.Equal(calls, expected) {
+		t.Errorf("timer events %v, expected %v", calls, expected)
+	}
+	if !s.Is(Idle) {
+		t.Errorf("expected Idle after the timer is exhausted, got %s", s.Current())
+	}
+
+	// the timer is stopped when Waiting is exited
+	calls = nil
+	if err := f.SendEvent(context.Background(), s, Start, nil); err != nil {
+		t.Fatalf("SendEvent() failed: %v", err)
+	}
+	clock.Advance(500 * time.Millisecond)
+	if err := f.SendEvent(context.Background(), s, Complete, nil); err != nil {
+		t.Fatalf("SendEvent() failed: %v", err)
+	}
+	clock.Advance(10 * time.Second)
+	if len(calls) != 0 || !s.Is(Done) {
+		t.Errorf("unexpected timer events %v in state %s", calls, s.Current())
+	}
+	if len(clock.timers) != 0 {
+		t.Errorf("%d clock timers left", len(clock.timers))
+	}
+
+	if _, err := NewFSM(Transitions{{Event: Start, From: Idle, To: Waiting}}, nil,
+		WithTimers(Timer{State: Waiting, Event: Retransmit})); err == nil {
+		t.Error("expected error for a timer without duration")
+	}
+}
+
+func TestTimersEntryFailure(t *testing.T) {
+	const (
+		Idle    StateType = "Idle"
+		Waiting StateType = "Waiting"
+		Done    StateType = "Done"
+	)
+	const (
+		Start    EventType = "Start"
+		Abort    EventType = "Abort"
+		Complete EventType = "Complete"
+		Reset    EventType = "Reset"
+	)
+	errEntry := errors.New("entry failed")
+	newFSM := func(clock Clock, policy EntryFailurePolicy) *FSM {
+		f, err := NewFSM(Transitions{
+			{Event: Start, From: Idle, To: Waiting},
+			{Event: Abort, From: Waiting, To: Idle},
+			{Event: Complete, From: Waiting, To: Done},
+			{Event: Reset, From: Done, To: Idle},
+		}, nil, WithClock(clock), WithEntryFailurePolicy(policy, ""),
+			WithTimers(Timer{State: Waiting, Event: Abort, Duration: time.Second},
+				Timer{State: Done, Event: Reset, Duration: 2 * time.Second}),
+			WithErrorCallbacks(ErrorCallbacks{Done: func(ctx context.Context, s *State, event EventType, args ArgsType) error {
+				if event == EntryEvent {
+					return errEntry
+				}
+				return nil
+			}}))
+		if err != nil {
+			t.Fatalf("NewFSM() failed: %v", err)
+		}
+		return f
+	}
+	ctx := context.Background()
+
+	// the rollback restores Waiting with its timer still running
+	clock := NewManualClock(time.Unix(0, 0))
+	f := newFSM(clock, EntryFailureRollback)
+	s := NewState(Idle)
+	if err := f.SendEvent(ctx, s, Start, nil); err != nil {
+		t.Fatalf("SendEvent() failed: %v", err)
+	}
+	clock.Advance(500 * time.Millisecond)
+	if err := f.SendEvent(ctx, s, Complete, nil); !errors.Is(err, errEntry) {
+		t.Fatalf("SendEvent() should fail with the entry error, got %v", err)
+	}
+	if !s.Is(Waiting) {
+		t.Fatalf("expected Waiting after the rollback, got %s", s.Current())
+	}
+	clock.Advance(500 * time.Millisecond)
+	if !s.Is(Idle) {
+		t.Errorf("expected the timer of Waiting to abort to Idle at 1s, got %s", s.Current())
+	}
+
+	// staying in Done stops the timer of Waiting and starts the timer of Done
+	clock = NewManualClock(time.Unix(0, 0))
+	f = newFSM(clock, EntryFailureStay)
+	s = NewState(Idle)
+	if err := f.SendEvent(ctx, s, Start, nil); err != nil {
+		t.Fatalf("SendEvent() failed: %v", err)
+	}
+	if err := f.SendEvent(ctx, s, Complete, nil); !errors.Is(err, errEntry) {
+		t.Fatalf("SendEvent() should fail with the entry error, got %v", err)
+	}
+	if !s.Is(Done) || len(clock.timers) != 1 {
+		t.Fatalf("expected Done with its timer, got %s with %d clock timers", s.Current(), len(clock.timers))
+	}
+	clock.Advance(time.Second)
+	if !s.Is(Done) {
+		t.Fatalf("expected the timer of Waiting to be stopped, got %s", s.Current())
+	}
+	clock.Advance(time.Second)
+	if !s.Is(Idle) {
+		t.Errorf("expected the timer of Done to reset to Idle at 2s, got %s", s.Current())
+	}
+}
+
+func TestHooksAndHistory(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	var trace []string
+	failing := errors.New("entry failed")
+	f, err := NewFSM(Transitions{
+		{Event: Open, From: Closed, To: Opened},
+		{Event: Close, From: Opened, To: Closed},
+	}, nil, WithClock(clock), WithHistory(2), WithErrorCallbacks(ErrorCallbacks{
+		Closed: func(ctx context.Context, state *State, event EventType, args ArgsType) error {
+			clock.Advance(time.Millisecond)
+			if event == EntryEvent && args["fail"] == true {
+				return failing
+			}
+			return nil
+		},
+	}), WithHooks(Hooks{
+		BeforeTransition: func(ctx context.Context, state *State, trans Transition, args ArgsType) {
+			trace = append(trace, fmt.Sprintf("before %s %s->%s", trans.Event, trans.From, trans.To))
+		},
+		AfterTransition: func(ctx context.Context, state *State, record TransitionRecord) {
+			trace = append(trace, fmt.Sprintf("after %s %s->%s %v", record.Event, record.From, record.To, record.Err != nil))
+		},
+		EventRejected: func(ctx context.Context, state *State, event EventType, args ArgsType, err error) {
+			trace = append(trace, fmt.Sprintf("rejected %s at %s", event, state.Current()))
+		},
+	}))
+	if err != nil {
+		t.Fatalf("NewFSM() failed: %v", err)
+	}
+
+	s := NewState(Closed)
+	ctx := context.Background()
+	if err := f.SendEvent(ctx, s, Open, nil); err != nil {
+		t.Fatalf("SendEvent() failed: %v", err)
+	}
+	if err := f.SendEvent(ctx, s, Open, nil); err == nil {
+		t.Fatal("expected Open to be rejected in Opened")
+	}
+	if err := f.SendEvent(ctx, s, Close, nil); err != nil {
+		t.Fatalf("SendEvent() failed: %v", err)
+	}
+	if err := f.SendEvent(ctx, s, Open, nil); err != nil {
+		t.Fatalf("SendEvent() failed: %v", err)
+	}
+	if err := f.SendEvent(ctx, s, Close, ArgsType{"fail": true}); !errors.Is(err, failing) {
+		t.Fatalf("expected entry failure, got %v", err)
+	}
+
+	expected := []string{
+		"before Open Closed->Opened", "after Open Closed->Opened false",
+		"rejected Open at Opened",
+		"before Close Opened->Closed", "after Close Opened->Closed false",
+		"before Open Closed->Opened", "after Open Closed->Opened false",
+		"before Close Opened->Closed", "after Close Opened->Closed true",
+	}
+	if !slices.Equal(trace, expected) {
+		t.Errorf("trace %v, expected %v", trace, expected)
+	}
+
+	// the history keeps the last two transitions
+	history := s.History()
+	if len(history) != 2 {
+		t.Fatalf("expected 2 transitions in history, got %d", len(history))
+	}
+	if history[0].Event != Open || history[0].From != Closed || history[0].To != Opened ||
+		history[0].Duration != 2*time.Millisecond || history[0].Err != nil {
+		t.Errorf("unexpected record %+v", history[0])
+	}
+	if history[1].Event != Close || !errors.Is(history[1].Err, failing) ||
+		history[1].Timestamp != time.Unix(0, int64(5*time.Millisecond)) {
+		t.Errorf("unexpected record %+v", history[1])
+	}
+	if len(NewState(Closed).History()) != 0 {
+		t.Error("expected empty history of a new State")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	const (
+		Deregistered StateType = "Deregistered"
+		Registered   StateType = "Registered"
+		Idle         StateType = "Idle"
+		Connected    StateType = "Connected"
+		Orphan       StateType = "Orphan"
+		Released     StateType = "Released"
+	)
+	noop := func(ctx context.Context, state *State, event EventType, args ArgsType) {}
+	transitions := Transitions{
+		{Event: "Register", From: Deregistered, To: Idle},
+		{Event: "Connect", From: Idle, To: Connected},
+		{Event: "Deregister", From: Registered, To: Deregistered},
+		{Event: "Release", From: Orphan, To: Released},
+		{Event: "Stay", From: Released, To: Released},
+	}
+	opts := []Option{
+		WithSubstates(Registered, Idle, Connected),
+		WithTimers(Timer{State: Idle, Event: "Expire", Duration: time.Second, ExhaustedEvent: "Register"}),
+	}
+	f, err := NewFSM(transitions, Callbacks{Deregistered: noop, Idle: noop, Connected: noop}, opts...)
+	if err != nil {
+		t.Fatalf("NewFSM() failed: %v", err)
+	}
+
+	validation := Validation{Initial: Deregistered, Events: []EventType{"Connect", "Reset"}}
+	report := f.Validate(validation)
+	expected := ValidationReport{
+		MissingCallbacks: []StateType{Orphan, Registered, Released},
+		Unreachable:      []StateType{Orphan, Released},
+		DeadEnds:         []StateType{Released},
+		UnhandledEvents:  []EventType{"Expire", "Reset"},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("Validate() = %+v, expected %+v", report, expected)
+	}
+	if err := report.Err(); err == nil || !strings.Contains(err.Error(), "unreachable states [Orphan Released]") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// strict mode fails with the problems of the report
+	if _, err := NewFSM(transitions, nil, append(opts, WithStrictValidation(validation))...); err == nil {
+		t.Error("expected strict validation to fail")
+	}
+	valid := Transitions{
+		{Event: "Register", From: Deregistered, To: Idle},
+		{Event: "Expire", From: Idle, To: Deregistered},
+		{Event: "Connect", From: Idle, To: Connected},
+		{Event: "Deregister", From: Registered, To: Deregistered},
+	}
+	callbacks := Callbacks{Deregistered: noop, Registered: noop, Idle: noop, Connected: noop}
+	if _, err := NewFSM(valid, callbacks, append(opts, WithStrictValidation(validation))...); err == nil ||
+		!strings.Contains(err.Error(), "unhandled events [Reset]") {
+		t.Errorf("unexpected error %v", err)
+	}
+	validation.Events = nil
+	if _, err := NewFSM(valid, callbacks, append(opts, WithStrictValidation(validation))...); err != nil {
+		t.Errorf("strict validation failed: %v", err)
+	}
+}
+
+func TestDiagrams(t *testing.T) {
+	f, err := NewFSM(Transitions{
+		{Event: "Register", From: "Deregistered", To: "Idle"},
+		{Event: "Service Request", From: "Idle", To: "Connected"},
+		{Event: "Deregister", From: "Registered", To: "Deregistered"},
+	}, nil, WithSubstates("Registered", "Idle", "Connected"))
+	if err != nil {
+		t.Fatalf("NewFSM() failed: %v", err)
+	}
+	opts := DiagramOptions{Highlight: "Idle"}
+
+	tests := []struct {
+		name     string
+		write    func(io.Writer, *FSM, DiagramOptions) error
+		expected string
+	}{
+		{
+			name:  "dot",
+			write: WriteDot,
+			expected: `digraph FSM {
+	rankdir=LR
+	size="100"
+	compound=true
+	node [width=1 fixedsize=false shape=ellipse style=filled fillcolor="skyblue"]
+	"Deregistered"
+	subgraph "cluster_Registered" {
+		label="Registered"
+		"Registered" [shape=point style=invis]
+		"Connected"
+		"Idle" [fillcolor="orange" penwidth=2]
+	}
+	"Deregistered" -> "Idle" [label="Register"]
+	"Idle" -> "Connected" [label="Service Request"]
+	"Registered" -> "Deregistered" [label="Deregister" ltail="cluster_Registered"]
+}
+`,
+		},
+		{
+			name:  "mermaid",
+			write: WriteMermaid,
+			expected: `stateDiagram-v2
+    state "Deregistered" as s1
+    state "Registered" as s3 {
+        state "Connected" as s0
+        state "Idle" as s2
+    }
+    s1 --> s2 : Register
+    s2 --> s0 : Service Request
+    s3 --> s1 : Deregister
+    classDef highlight fill:orange,stroke-width:2px
+    class s2 highlight
+`,
+		},
+		{
+			name:  "plantuml",
+			write: WritePlantUML,
+			expected: `@startuml
+hide empty description
+state "Deregistered" as s1
+state "Registered" as s3 {
+  state "Connected" as s0
+  state "Idle" as s2 #orange
+}
+s1 --> s2 : Register
+s2 --> s0 : Service Request
+s3 --> s1 : Deregister
+@enduml
+`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var b strings.Builder
+			if err := tc.write(&b, f, opts); err != nil {
+				t.Fatalf("write failed: %v", err)
+			}
+			if b.String() != tc.expected {
+				t.Errorf("unexpected diagram:\n%s\nexpected:\n%s", b.String(), tc.expected)
+			}
+		})
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	const definitionYAML = `
+states:
+  - name: Deregistered
+    callback: record
+  - name: Registered
+  - name: Idle
+    parent: Registered
+    callback: record
+  - name: Waiting
+    parent: Registered
+    errorCallback: check
+events: [Register, Deregister, Page, Expire]
+transitions:
+  - {event: Register, from: Deregistered, to: Idle, guard: allowed}
+  - {event: Page, from: Idle, to: Waiting}
+  - {event: Deregister, from: Registered, to: Deregistered}
+timers:
+  - {state: Waiting, event: Expire, duration: 6s, maxRetries: 1, exhaustedEvent: Deregister}
+initial: Deregistered
+`
+	var calls []string
+	registry := NewRegistry()
+	registry.RegisterCallback("record", func(ctx context.Context, state *State, event EventType, args ArgsType) {
+		calls = append(calls, fmt.Sprintf("%s/%s", state.Current(), event))
+	})
+	registry.RegisterErrorCallback("check", func(ctx context.Context, state *State, event EventType, args ArgsType) error {
+		calls = append(calls, fmt.Sprintf("%s/%s", state.Current(), event))
+		return nil
+	})
+	registry.RegisterGuard("allowed", func(ctx context.Context, state *State, args ArgsType) bool {
+		return args["allowed"] == true
+	})
+
+	definition, err := ParseDefinition([]byte(definitionYAML))
+	if err != nil {
+		t.Fatalf("ParseDefinition() failed: %v", err)
+	}
+	if definition.Timers[0].Duration != 6*time.Second {
+		t.Errorf("unexpected timer %+v", definition.Timers[0])
+	}
+	clock := NewManualClock(time.Unix(0, 0))
+	f, err := definition.NewFSM(registry, WithClock(clock))
+	if err != nil {
+		t.Fatalf("NewFSM() failed: %v", err)
+	}
+
+	ctx := context.Background()
+	s := NewState("Deregistered")
+	if err := f.SendEvent(ctx, s, "Register", nil); err == nil {
+		t.Error("expected the guard to reject Register")
+	}
+	for _, event := range []struct {
+		event EventType
+		args  ArgsType
+	}{{"Register", ArgsType{"allowed": true}}, {"Page", nil}} {
+		if err := f.SendEvent(ctx, s, event.event, event.args); err != nil {
+			t.Fatalf("SendEvent(%s) failed: %v", event.event, err)
+		}
+	}
+	clock.Advance(time.Minute)
+	expected := []string{
+		"Deregistered/Register", "Deregistered/Exit event", "Idle/Entry event",
+		"Idle/Page", "Idle/Exit event", "Waiting/Entry event",
+		"Waiting/Exit event", "Deregistered/Entry event",
+	}
+	if !slices.Equal(calls, expected) {
+		t.Errorf("callbacks %v, expected %v", calls, expected)
+	}
+	if !s.Is("Deregistered") {
+		t.Errorf("expected Deregistered, got %s", s.Current())
+	}
+
+	// the definition is also accepted in JSON, and strict mode reports the missing callback
+	// of Registered and the unhandled Expire event
+	definitionJSON := `{
+		"states": [{"name": "Deregistered"}, {"name": "Registered", "callback": "record"}],
+		"events": ["Register", "Expire"],
+		"transitions": [{"event": "Register", "from": "Deregistered", "to": "Registered"}],
+		"initial": "Deregistered",
+		"terminal": ["Registered"],
+		"strict": true
+	}`
+	definition, err = ParseDefinition([]byte(definitionJSON))
+	if err != nil {
+		t.Fatalf("ParseDefinition() failed: %v", err)
+	}
+	if _, err := definition.NewFSM(registry); err == nil ||
+		err.Error() != "invalid fsm: states without callback [Deregistered], unhandled events [Expire]" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestInvalidDefinition(t *testing.T) {
+	registry := NewRegistry()
+	tests := []struct {
+		name       string
+		definition string
+		expected   string
+	}{
+		{"unknown field", "states: [{name: A, callbak: a}]", "invalid fsm definition"},
+		{"undeclared state", "states: [{name: A}]\nevents: [E]\ntransitions: [{event: E, from: A, to: B}]", "undeclared state: B"},
+		{"undeclared event", "states: [{name: A}]\ntransitions: [{event: E, from: A, to: A}]", "undeclared event: E"},
+		{"unknown callback", "states: [{name: A, callback: a}]", "unknown callback a of state A"},
+		{"unknown guard", "states: [{name: A}]\nevents: [E]\ntransitions: [{event: E, from: A, to: A, guard: g}]", "unknown guard g"},
+		{"duplicate state", "states: [{name: A}, {name: A}]", "duplicate state: A"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			definition, err := ParseDefinition([]byte(tc.definition))
+			if err == nil {
+				_, err = definition.NewFSM(registry)
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("expected error %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package fsm
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// WithSubstates nests children in the composite state parent, for example the Idle and
+// Connected sub-states of Registered. Events that a state does not handle bubble to its
+// parent, so transitions shared by all sub-states are defined once, from the parent.
+//
+// A transition between states leaves the states from the current state up to the
+// lowest common ancestor of its From and To states, innermost first, and enters the
+// states from there down to its To state, outermost first. A transition whose From and
+// To are the same state keeps the current state without exit and entry callbacks.
+func WithSubstates(parent StateType, children ...StateType) Option {
+	return func(fsm *FSM) {
+		fsm.substates[parent] = append(fsm.substates[parent], children...)
+	}
+}
+
+// In returns true if state is target or one of its sub-states
+func (fsm *FSM) In(state *State, target StateType) bool {
+	return slices.Contains(fsm.ancestors(state.Current()), target)
+}
+
+// Parent returns the composite state that contains s
+func (fsm *FSM) Parent(s StateType) (StateType, bool) {
+	parent, ok := fsm.parents[s]
+	return parent, ok
+}
+
+// buildHierarchy derives the parent of each sub-state from the substates options and
+// adds the states to allStates
+func (fsm *FSM) buildHierarchy(allStates map[StateType]bool) error {
+	for _, parent := range slices.Sorted(maps.Keys(fsm.substates)) {
+		allStates[parent] = true
+		for _, child := range fsm.substates[parent] {
+			if previous, ok := fsm.parents[child]; ok {
+				return fmt.Errorf("duplicate parent of state %s: %s and %s", child, previous, parent)
+			}
+			fsm.parents[child] = parent
+			allStates[child] = true
+		}
+	}
+	for child := range fsm.parents {
+		seen := map[StateType]bool{child: true}
+		for s, ok := fsm.parents[child]; ok; s, ok = fsm.parents[s] {
+			if seen[s] {
+				return fmt.Errorf("cyclic substates of state %s", child)
+			}
+			seen[s] = true
+		}
+	}
+	return nil
+}
+
+// ancestors returns s followed by its ancestors, from the innermost to the outermost
+func (fsm *FSM) ancestors(s StateType) []StateType {
+	chain := []StateType{s}
+	for parent, ok := fsm.parents[s]; ok; parent, ok = fsm.parents[parent] {
+		chain = append(chain, parent)
+	}
+	return chain
+}
+
+// transitionPath returns the states exited, innermost first, and entered, outermost
+// first, when trans is taken at the current state. The states below the lowest common
+// ancestor of From and To that is not one of them are exited and entered, so a
+// transition between a composite state and one of its sub-states also leaves and
+// re-enters the composite state.
+func (fsm *FSM) transitionPath(current StateType, trans Transition) (exits, entries []StateType) {
+	toChain := fsm.ancestors(trans.To)
+	var lca StateType
+	hasLCA := false
+	for _, s := range fsm.ancestors(trans.From)[1:] {
+		if slices.Contains(toChain[1:], s) {
+			lca, hasLCA = s, true
+			break
+		}
+	}
+
+	for _, s := range fsm.ancestors(current) {
+		if hasLCA && s == lca {
+			break
+		}
+		exits = append(exits, s)
+	}
+	for _, s := range toChain {
+		if hasLCA && s == lca {
+			break
+		}
+		entries = append(entries, s)
+	}
+	slices.Reverse(entries)
+	return exits, entries
+}
+
+// children returns the sub-states of each composite state
+func (fsm *FSM) children() map[StateType][]StateType {
+	children := make(map[StateType][]StateType)
+	for child, parent := range fsm.parents {
+		children[parent] = append(children[parent], child)
+	}
+	for _, states := range children {
+		slices.Sort(states)
+	}
+	return children
+}
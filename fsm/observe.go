@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package fsm
+
+import (
+	"context"
+	"time"
+)
+
+// TransitionRecord describes a transition that has been taken
+type TransitionRecord struct {
+	Event EventType
+	// From is the state before the transition
+	From StateType
+	// To is the state after the transition, which differs from the To state of the
+	// transition if an entry callback failed
+	To        StateType
+	Timestamp time.Time
+	// Duration is the time spent in the callbacks of the transition
+	Duration time.Duration
+	// Err is the error of a failed callback
+	Err error
+}
+
+// Hooks observe the events of an FSM, for example to trace transitions or turn them into
+// metrics. Hooks run with the transitions of the State, so like callbacks they must not
+// send events to it with another ctx, and unset hooks are skipped.
+type Hooks struct {
+	// BeforeTransition is called when a transition is selected, before its callbacks
+	BeforeTransition func(ctx context.Context, state *State, trans Transition, args ArgsType)
+	// AfterTransition is called after the callbacks of a transition
+	AfterTransition func(ctx context.Context, state *State, record TransitionRecord)
+	// EventRejected is called when no transition is taken for an event
+	EventRejected func(ctx context.Context, state *State, event EventType, args ArgsType, err error)
+}
+
+// WithHooks registers observer hooks, hooks registered by several options are called in
+// the order of the options
+func WithHooks(hooks Hooks) Option {
+	return func(fsm *FSM) {
+		fsm.hooks = append(fsm.hooks, hooks)
+	}
+}
+
+// WithHistory keeps the last size transitions of each State, see State.History
+func WithHistory(size int) Option {
+	return func(fsm *FSM) {
+		fsm.historySize = size
+	}
+}
+
+func (fsm *FSM) beforeTransition(ctx context.Context, state *State, trans Transition, args ArgsType) {
+	for _, hooks := range fsm.hooks {
+		if hooks.BeforeTransition != nil {
+			hooks.BeforeTransition(ctx, state, trans, args)
+		}
+	}
+}
+
+func (fsm *FSM) afterTransition(ctx context.Context, state *State, record TransitionRecord) {
+	if fsm.historySize > 0 {
+		state.record(fsm.historySize, record)
+	}
+	for _, hooks := range fsm.hooks {
+		if hooks.AfterTransition != nil {
+			hooks.AfterTransition(ctx, state, record)
+		}
+	}
+}
+
+func (fsm *FSM) eventRejected(ctx context.Context, state *State, event EventType, args ArgsType, err error) {
+	for _, hooks := range fsm.hooks {
+		if hooks.EventRejected != nil {
+			hooks.EventRejected(ctx, state, event, args, err)
+		}
+	}
+}
+
+// History returns the last transitions of state, oldest first. It is empty unless the
+// FSM that handled the events of state was created WithHistory.
+func (state *State) History() []TransitionRecord {
+	state.historyMutex.Lock()
+	defer state.historyMutex.Unlock()
+	history := make([]TransitionRecord, 0, len(state.history))
+	history = append(history, state.history[state.historyNext:]...)
+	return append(history, state.history[:state.historyNext]...)
+}
+
+// record adds a transition to the history ring buffer of at most size transitions
+func (state *State) record(size int, record TransitionRecord) {
+	state.historyMutex.Lock()
+	defer state.historyMutex.Unlock()
+	if len(state.history) < size {
+		state.history = append(state.history, record)
+		return
+	}
+	state.history[state.historyNext] = record
+	state.historyNext = (state.historyNext + 1) % len(state.history)
+}
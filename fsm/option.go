@@ -0,0 +1,25 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package fsm
+
+// Option configures an FSM created by NewFSM
+type Option func(*FSM)
+
+// WithErrorCallbacks registers callbacks that can fail, a state has either a Callback
+// or an ErrorCallback
+func WithErrorCallbacks(callbacks ErrorCallbacks) Option {
+	return func(fsm *FSM) {
+		fsm.errorCallbacks = callbacks
+	}
+}
+
+// WithEntryFailurePolicy sets how a failed entry callback is handled, the state of the
+// transition is kept by default. The error state is only used with EntryFailureErrorState.
+func WithEntryFailurePolicy(policy EntryFailurePolicy, errorState StateType) Option {
+	return func(fsm *FSM) {
+		fsm.entryFailurePolicy = policy
+		fsm.errorState = errorState
+	}
+}
@@ -15,6 +15,61 @@ type State struct {
 	current StateType
 	// stateMutex ensures that all operations to current is thread-safe
 	stateMutex sync.RWMutex
+	// dispatchMutex serializes the transitions of SendEvent
+	dispatchMutex sync.Mutex
+	// timersMutex guards timers
+	timersMutex sync.Mutex
+	// timers are the running timers of each state
+	timers map[StateType][]*stateTimer
+	// historyMutex guards history and historyNext
+	historyMutex sync.Mutex
+	// history is a ring buffer of the last transitions, historyNext is the index of the
+	// oldest transition once it is full
+	history     []TransitionRecord
+	historyNext int
+}
+
+// dispatchKey is the context key of the eventQueue of a State during SendEvent
+type dispatchKey struct {
+	state *State
+}
+
+type queuedEvent struct {
+	fsm   *FSM
+	event EventType
+	args  ArgsType
+}
+
+// eventQueue holds the events sent from callbacks until the current transition completes
+type eventQueue struct {
+	mutex  sync.Mutex
+	events []queuedEvent
+	closed bool
+}
+
+// push queues an event, it returns false once the queue has been drained, so that the
+// event is sent normally
+func (q *eventQueue) push(fsm *FSM, event EventType, args ArgsType) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		return false
+	}
+	q.events = append(q.events, queuedEvent{fsm: fsm, event: event, args: args})
+	return true
+}
+
+// pop returns the next queued event, and closes the queue when it is empty
+func (q *eventQueue) pop() (queuedEvent, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if len(q.events) == 0 {
+		q.closed = true
+		return queuedEvent{}, false
+	}
+	event := q.events[0]
+	q.events = q.events[1:]
+	return event, true
 }
 
 // NewState create a State object with current state set to initState
@@ -0,0 +1,254 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package fsm
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/omec-project/util/logger"
+)
+
+// TimerExpiries is the argument of timer events that holds the number of expiries of
+// the timer, starting at 1
+const TimerExpiries = "timerExpiries"
+
+// Timer sends Event after Duration in State, like the T3560 retransmission timer. The
+// timer is started when a transition enters State and all its entry callbacks succeed,
+// and it is stopped when a transition exits State. A State created by NewState has no
+// running timers, even if its initial state declares some.
+//
+// After each expiry the timer is restarted as long as it has been restarted less than
+// MaxRetries times, so it expires at most MaxRetries+1 times. The last expiry sends
+// ExhaustedEvent instead of Event, if it is set.
+type Timer struct {
+	State          StateType
+	Event          EventType
+	Duration       time.Duration
+	MaxRetries     int
+	ExhaustedEvent EventType
+}
+
+// Clock schedules the timers of an FSM and times its transitions, a manual clock makes
+// timers testable
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine after d
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+// ClockTimer is a timer scheduled by a Clock
+type ClockTimer interface {
+	// Stop prevents the timer from firing, it returns false if it already fired or was stopped
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}
+
+// WithTimers declares timers of the states of the FSM
+func WithTimers(timers ...Timer) Option {
+	return func(fsm *FSM) {
+		for _, timer := range timers {
+			fsm.timers[timer.State] = append(fsm.timers[timer.State], timer)
+		}
+	}
+}
+
+// WithClock replaces the real time clock of the timers and the transition history
+func WithClock(clock Clock) Option {
+	return func(fsm *FSM) {
+		fsm.clock = clock
+	}
+}
+
+// StopTimers stops the running timers of state, for example before the object it
+// belongs to is released
+func (fsm *FSM) StopTimers(state *State) {
+	state.timersMutex.Lock()
+	defer state.timersMutex.Unlock()
+	for s, timers := range state.timers {
+		for _, timer := range timers {
+			timer.stop()
+		}
+		delete(state.timers, s)
+	}
+}
+
+func (fsm *FSM) validateTimers(allStates map[StateType]bool) error {
+	for s, timers := range fsm.timers {
+		if _, ok := allStates[s]; !ok {
+			return fmt.Errorf("unknown state: %+v", s)
+		}
+		for _, timer := range timers {
+			if timer.Event == "" || timer.Duration <= 0 || timer.MaxRetries < 0 {
+				return fmt.Errorf("invalid timer: %+v", timer)
+			}
+		}
+	}
+	return nil
+}
+
+// stateTimer is a running Timer of a State
+type stateTimer struct {
+	Timer
+	expiries   int
+	stopped    bool
+	clockTimer ClockTimer
+}
+
+// stop must be called with the timersMutex of the State held
+func (t *stateTimer) stop() {
+	t.stopped = true
+	if t.clockTimer != nil {
+		t.clockTimer.Stop()
+	}
+}
+
+// startTimers starts the timers of the entered states
+func (fsm *FSM) startTimers(ctx context.Context, state *State, entered ...StateType) {
+	// timers outlive the SendEvent that started them
+	ctx = context.WithoutCancel(ctx)
+	state.timersMutex.Lock()
+	defer state.timersMutex.Unlock()
+	for _, s := range entered {
+		for _, timer := range fsm.timers[s] {
+			running := &stateTimer{Timer: timer}
+			if state.timers == nil {
+				state.timers = make(map[StateType][]*stateTimer)
+			}
+			state.timers[s] = append(state.timers[s], running)
+			fsm.schedule(ctx, state, running)
+		}
+	}
+}
+
+// stopTimers stops the timers of the exited states
+func (state *State) stopTimers(exited ...StateType) {
+	state.timersMutex.Lock()
+	defer state.timersMutex.Unlock()
+	for _, s := range exited {
+		for _, timer := range state.timers[s] {
+			timer.stop()
+		}
+		delete(state.timers, s)
+	}
+}
+
+// schedule must be called with the timersMutex of the State held
+func (fsm *FSM) schedule(ctx context.Context, state *State, timer *stateTimer) {
+	timer.clockTimer = fsm.clock.AfterFunc(timer.Duration, func() {
+		fsm.expire(ctx, state, timer)
+	})
+}
+
+// expire sends the event of an expired timer, unless the timer was stopped while the
+// expiry waited for the transition in progress
+func (fsm *FSM) expire(ctx context.Context, state *State, timer *stateTimer) {
+	var event EventType
+	err := dispatch(ctx, state, func(ctx context.Context) error {
+		state.timersMutex.Lock()
+		if timer.stopped || !fsm.In(state, timer.State) {
+			state.timersMutex.Unlock()
+			return nil
+		}
+		timer.expiries++
+		event = timer.Event
+		if timer.expiries > timer.MaxRetries {
+			timer.stopped = true
+			if timer.ExhaustedEvent != "" {
+				event = timer.ExhaustedEvent
+			}
+		} else {
+			fsm.schedule(ctx, state, timer)
+		}
+		expiries := timer.expiries
+		state.timersMutex.Unlock()
+		return fsm.handleEvent(ctx, state, event, ArgsType{TimerExpiries: expiries})
+	})
+	if err != nil {
+		logger.FsmLog.Warnf("timer event [%s] of state [%s] failed: %v", event, timer.State, err)
+	}
+}
+
+// ManualClock is a Clock that only advances when told to, for tests of FSMs with timers
+type ManualClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+type manualTimer struct {
+	clock *ManualClock
+	when  time.Time
+	f     func()
+}
+
+// NewManualClock creates a ManualClock set to now
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Now returns the current time of the clock
+func (c *ManualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	timer := &manualTimer{clock: c, when: c.now.Add(d), f: f}
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+// Advance moves the clock forward by d and calls the functions of the timers that are
+// due, in the order of their time, in the calling goroutine. Timers scheduled by these
+// functions fire as well if they are due.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	target := c.now.Add(d)
+	c.mutex.Unlock()
+	for {
+		c.mutex.Lock()
+		slices.SortStableFunc(c.timers, func(a, b *manualTimer) int {
+			return a.when.Compare(b.when)
+		})
+		if len(c.timers) == 0 || c.timers[0].when.After(target) {
+			c.now = target
+			c.mutex.Unlock()
+			return
+		}
+		timer := c.timers[0]
+		c.timers = c.timers[1:]
+		c.now = timer.when
+		c.mutex.Unlock()
+		timer.f()
+	}
+}
+
+func (t *manualTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	for i, timer := range t.clock.timers {
+		if timer == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,548 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package typed provides a generic FSM with typed states, events and event arguments.
+// It is built on package fsm and shares its transition and callback semantics: guards
+// are evaluated in order, events sent to a State are serialized, events sent from
+// callbacks with their ctx are queued, and events bubble from sub-states to their
+// composite states.
+//
+// States and events are named after their fmt.Sprint representation in errors, logs and
+// diagrams, so distinct states or events must have distinct representations.
+package typed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/omec-project/util/fsm"
+)
+
+// Phase tells a callback why it is called
+type Phase int
+
+const (
+	// OnEvent is the phase of the event callback of the state the transition is taken at
+	OnEvent Phase = iota
+	// OnExit is the phase of the exit callbacks of the states left by the transition
+	OnExit
+	// OnEntry is the phase of the entry callbacks of the states entered by the transition
+	OnEntry
+)
+
+// Callback is called in each phase of a transition with the event and args of the transition
+type (
+	Callback[S, E comparable, A any]  func(ctx context.Context, state *State[S], phase Phase, event E, args A)
+	Callbacks[S, E comparable, A any] map[S]Callback[S, E, A]
+)
+
+// ErrorCallback is a Callback that can fail, like fsm.ErrorCallback
+type (
+	ErrorCallback[S, E comparable, A any]  func(ctx context.Context, state *State[S], phase Phase, event E, args A) error
+	ErrorCallbacks[S, E comparable, A any] map[S]ErrorCallback[S, E, A]
+)
+
+// Guard decides whether a transition is taken, like fsm.Guard
+type Guard[S comparable, A any] func(ctx context.Context, state *State[S], args A) bool
+
+// Transition defines a transition like fsm.Transition
+type Transition[S, E comparable, A any] struct {
+	Event E
+	From  S
+	To    S
+	Guard Guard[S, A]
+}
+
+// Timer sends Event after Duration in State, like fsm.Timer. The last expiry sends
+// ExhaustedEvent instead of Event, if it is set. Callbacks receive the zero args for
+// timer events.
+type Timer[S, E comparable] struct {
+	State          S
+	Event          E
+	Duration       time.Duration
+	MaxRetries     int
+	ExhaustedEvent *E
+}
+
+// Hooks observe the transitions of an FSM, like fsm.Hooks. They are not called for events
+// sent through the FSM of package fsm returned by Untyped, and get the zero args for
+// timer events.
+type Hooks[S, E comparable, A any] struct {
+	// BeforeTransition is called when a transition is selected, before its callbacks
+	BeforeTransition func(ctx context.Context, state *State[S], event E, from S, to S, args A)
+	// AfterTransition is called after the callbacks of a transition
+	AfterTransition func(ctx context.Context, state *State[S], record TransitionRecord[S, E])
+	// EventRejected is called when no transition is taken for an event
+	EventRejected func(ctx context.Context, state *State[S], event E, args A, err error)
+}
+
+// TransitionRecord describes a transition taken by an FSM, like fsm.TransitionRecord
+type TransitionRecord[S, E comparable] struct {
+	Event     E
+	From      S
+	To        S
+	Timestamp time.Time
+	Duration  time.Duration
+	Err       error
+}
+
+// Validation describes how an FSM is used, like fsm.Validation. Reachability is not
+// checked if Initial is nil.
+type Validation[S, E comparable] struct {
+	Initial  *S
+	Terminal []S
+	Events   []E
+}
+
+// Option configures an FSM created by NewFSM. The type arguments of options that cannot
+// be inferred from their arguments are given explicitly, as in WithHistory[S, E, A](8).
+type Option[S, E comparable, A any] func(*options[S, E, A])
+
+type options[S, E comparable, A any] struct {
+	fsmOptions []fsm.Option
+	// states and events are the states and events referred to by options
+	states         []S
+	events         []E
+	errorCallbacks ErrorCallbacks[S, E, A]
+	hooks          []Hooks[S, E, A]
+}
+
+// WithSubstates nests children in the composite state parent, see fsm.WithSubstates
+func WithSubstates[S, E comparable, A any](parent S, children ...S) Option[S, E, A] {
+	return func(o *options[S, E, A]) {
+		names := make([]fsm.StateType, 0, len(children))
+		for _, child := range children {
+			names = append(names, stateName(child))
+			o.states = append(o.states, child)
+		}
+		o.states = append(o.states, parent)
+		o.fsmOptions = append(o.fsmOptions, fsm.WithSubstates(stateName(parent), names...))
+	}
+}
+
+// WithEntryFailurePolicy sets how a failed entry callback is handled, see fsm.WithEntryFailurePolicy
+func WithEntryFailurePolicy[S, E comparable, A any](policy fsm.EntryFailurePolicy, errorState S) Option[S, E, A] {
+	return func(o *options[S, E, A]) {
+		o.states = append(o.states, errorState)
+		o.fsmOptions = append(o.fsmOptions, fsm.WithEntryFailurePolicy(policy, stateName(errorState)))
+	}
+}
+
+// WithErrorCallbacks registers callbacks that can fail, a state has either a Callback
+// or an ErrorCallback
+func WithErrorCallbacks[S, E comparable, A any](callbacks ErrorCallbacks[S, E, A]) Option[S, E, A] {
+	return func(o *options[S, E, A]) {
+		o.errorCallbacks = callbacks
+	}
+}
+
+// WithHistory keeps the last size transitions of each State, see FSM.History
+func WithHistory[S, E comparable, A any](size int) Option[S, E, A] {
+	return func(o *options[S, E, A]) {
+		o.fsmOptions = append(o.fsmOptions, fsm.WithHistory(size))
+	}
+}
+
+// WithTimers declares timers of the states of the FSM, see fsm.WithTimers
+func WithTimers[S, E comparable, A any](timers ...Timer[S, E]) Option[S, E, A] {
+	return func(o *options[S, E, A]) {
+		fsmTimers := make([]fsm.Timer, 0, len(timers))
+		for _, timer := range timers {
+			o.states = append(o.states, timer.State)
+			o.events = append(o.events, timer.Event)
+			fsmTimer := fsm.Timer{
+				State:      stateName(timer.State),
+				Event:      eventName(timer.Event),
+				Duration:   timer.Duration,
+				MaxRetries: timer.MaxRetries,
+			}
+			if timer.ExhaustedEvent != nil {
+				o.events = append(o.events, *timer.ExhaustedEvent)
+				fsmTimer.ExhaustedEvent = eventName(*timer.ExhaustedEvent)
+			}
+			fsmTimers = append(fsmTimers, fsmTimer)
+		}
+		o.fsmOptions = append(o.fsmOptions, fsm.WithTimers(fsmTimers...))
+	}
+}
+
+// WithClock replaces the real time clock of the timers and the transition history, see fsm.WithClock
+func WithClock[S, E comparable, A any](clock fsm.Clock) Option[S, E, A] {
+	return func(o *options[S, E, A]) {
+		o.fsmOptions = append(o.fsmOptions, fsm.WithClock(clock))
+	}
+}
+
+// WithHooks registers observer hooks, see fsm.WithHooks
+func WithHooks[S, E comparable, A any](hooks Hooks[S, E, A]) Option[S, E, A] {
+	return func(o *options[S, E, A]) {
+		o.hooks = append(o.hooks, hooks)
+	}
+}
+
+// WithStrictValidation makes NewFSM fail if the validation reports a problem, see
+// fsm.WithStrictValidation
+func WithStrictValidation[S, E comparable, A any](validation Validation[S, E]) Option[S, E, A] {
+	return func(o *options[S, E, A]) {
+		fsmValidation := fsm.Validation{}
+		if validation.Initial != nil {
+			o.states = append(o.states, *validation.Initial)
+			fsmValidation.Initial = stateName(*validation.Initial)
+		}
+		for _, s := range validation.Terminal {
+			o.states = append(o.states, s)
+			fsmValidation.Terminal = append(fsmValidation.Terminal, stateName(s))
+		}
+		for _, event := range validation.Events {
+			o.events = append(o.events, event)
+			fsmValidation.Events = append(fsmValidation.Events, eventName(event))
+		}
+		o.fsmOptions = append(o.fsmOptions, fsm.WithStrictValidation(fsmValidation))
+	}
+}
+
+// ErrUntypedEvent is returned by the callbacks of events that were not sent by
+// FSM.SendEvent, for example events sent through the FSM returned by Untyped
+var ErrUntypedEvent = errors.New("event not sent by the typed FSM")
+
+// FSM is a finite state machine with states of type S, events of type E and event
+// arguments of type A
+type FSM[S, E comparable, A any] struct {
+	fsm *fsm.FSM
+	// states and events map the names of package fsm to the typed values
+	states map[fsm.StateType]S
+	events map[fsm.EventType]E
+}
+
+// payload carries the typed event and args through package fsm
+type payload[E comparable, A any] struct {
+	event E
+	args  A
+}
+
+const payloadKey = "payload"
+
+// stateKey is the context key of the typed State that an event is sent to. Timers
+// keep the context of the transition that started them, so it is also set for
+// timer events.
+type stateKey struct{}
+
+// NewFSM creates a new FSM with transitions and callbacks
+func NewFSM[S, E comparable, A any](transitions []Transition[S, E, A], callbacks Callbacks[S, E, A],
+	opts ...Option[S, E, A],
+) (*FSM[S, E, A], error) {
+	o := &options[S, E, A]{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	f := &FSM[S, E, A]{
+		states: make(map[fsm.StateType]S),
+		events: make(map[fsm.EventType]E),
+	}
+
+	fsmTransitions := make(fsm.Transitions, 0, len(transitions))
+	for _, trans := range transitions {
+		if err := f.addEvent(trans.Event); err != nil {
+			return nil, err
+		}
+		for _, s := range []S{trans.From, trans.To} {
+			if err := f.addState(s); err != nil {
+				return nil, err
+			}
+		}
+		fsmTransitions = append(fsmTransitions, fsm.Transition{
+			Event: eventName(trans.Event),
+			From:  stateName(trans.From),
+			To:    stateName(trans.To),
+			Guard: f.guard(trans.Guard),
+		})
+	}
+	for _, s := range o.states {
+		if err := f.addState(s); err != nil {
+			return nil, err
+		}
+	}
+	for _, event := range o.events {
+		if err := f.addEvent(event); err != nil {
+			return nil, err
+		}
+	}
+
+	// all callbacks are error callbacks of package fsm, so that events without typed
+	// payload fail instead of being ignored
+	fsmCallbacks := make(fsm.ErrorCallbacks, len(callbacks)+len(o.errorCallbacks))
+	for s, callback := range callbacks {
+		if err := f.addState(s); err != nil {
+			return nil, err
+		}
+		fsmCallbacks[stateName(s)] = f.errorCallback(func(ctx context.Context, state *State[S], phase Phase, event E,
+			args A,
+		) error {
+			callback(ctx, state, phase, event, args)
+			return nil
+		})
+	}
+	for s, callback := range o.errorCallbacks {
+		if err := f.addState(s); err != nil {
+			return nil, err
+		}
+		if _, ok := fsmCallbacks[stateName(s)]; ok {
+			return nil, fmt.Errorf("duplicate callback: %+v", stateName(s))
+		}
+		fsmCallbacks[stateName(s)] = f.errorCallback(callback)
+	}
+
+	hooks := []fsm.Option{fsm.WithHooks(fsm.Hooks{BeforeTransition: f.timerPayload})}
+	for _, h := range o.hooks {
+		hooks = append(hooks, fsm.WithHooks(f.fsmHooks(h)))
+	}
+	fsmOptions := append(hooks, o.fsmOptions...)
+	fsmOptions = append(fsmOptions, fsm.WithErrorCallbacks(fsmCallbacks))
+
+	var err error
+	if f.fsm, err = fsm.NewFSM(fsmTransitions, nil, fsmOptions...); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *FSM[S, E, A]) addState(s S) error {
+	name := stateName(s)
+	if other, ok := f.states[name]; ok && other != s {
+		return fmt.Errorf("states %#v and %#v have the same name %s", other, s, name)
+	}
+	f.states[name] = s
+	return nil
+}
+
+func (f *FSM[S, E, A]) addEvent(event E) error {
+	name := eventName(event)
+	if name == fsm.EntryEvent || name == fsm.ExitEvent {
+		return fmt.Errorf("event name %s is reserved", name)
+	}
+	if other, ok := f.events[name]; ok && other != event {
+		return fmt.Errorf("events %#v and %#v have the same name %s", other, event, name)
+	}
+	f.events[name] = event
+	return nil
+}
+
+// SendEvent triggers the transition of event from the current state of state, see
+// fsm.FSM.SendEvent
+func (f *FSM[S, E, A]) SendEvent(ctx context.Context, state *State[S], event E, args A) error {
+	state.fsmStates.Store(&f.states)
+	return f.fsm.SendEvent(context.WithValue(ctx, stateKey{}, state), state.state, eventName(event), fsm.ArgsType{
+		payloadKey: payload[E, A]{event: event, args: args},
+	})
+}
+
+// In returns true if state is target or one of its sub-states
+func (f *FSM[S, E, A]) In(state *State[S], target S) bool {
+	return f.fsm.In(state.state, stateName(target))
+}
+
+// History returns the last transitions of state, see fsm.State.History
+func (f *FSM[S, E, A]) History(state *State[S]) []TransitionRecord[S, E] {
+	records := state.state.History()
+	history := make([]TransitionRecord[S, E], 0, len(records))
+	for _, record := range records {
+		if typedRecord, known := f.typedRecord(record); known {
+			history = append(history, typedRecord)
+		}
+	}
+	return history
+}
+
+// typedRecord converts a record of package fsm, it returns false if the event of the
+// record is not an event of the FSM
+func (f *FSM[S, E, A]) typedRecord(record fsm.TransitionRecord) (TransitionRecord[S, E], bool) {
+	event, known := f.events[record.Event]
+	if !known {
+		return TransitionRecord[S, E]{}, false
+	}
+	return TransitionRecord[S, E]{
+		Event:     event,
+		From:      f.states[record.From],
+		To:        f.states[record.To],
+		Timestamp: record.Timestamp,
+		Duration:  record.Duration,
+		Err:       record.Err,
+	}, true
+}
+
+// Untyped returns the underlying FSM of package fsm, for example to validate it or
+// write diagrams of it
+func (f *FSM[S, E, A]) Untyped() *fsm.FSM {
+	return f.fsm
+}
+
+// typedState returns the typed State of s that the event handled with ctx was sent to
+func typedState[S comparable](ctx context.Context, s *fsm.State) (*State[S], bool) {
+	state, ok := ctx.Value(stateKey{}).(*State[S])
+	return state, ok && state.state == s
+}
+
+// resolve returns the typed State, event and args of an event handled by package fsm
+func (f *FSM[S, E, A]) resolve(ctx context.Context, s *fsm.State, args fsm.ArgsType) (*State[S], payload[E, A], error) {
+	state, ok := typedState[S](ctx, s)
+	p, hasPayload := args[payloadKey].(payload[E, A])
+	if !ok || !hasPayload {
+		return nil, p, ErrUntypedEvent
+	}
+	return state, p, nil
+}
+
+func (f *FSM[S, E, A]) errorCallback(callback ErrorCallback[S, E, A]) fsm.ErrorCallback {
+	return func(ctx context.Context, s *fsm.State, event fsm.EventType, args fsm.ArgsType) error {
+		state, p, err := f.resolve(ctx, s, args)
+		if err != nil {
+			return err
+		}
+		return callback(ctx, state, phase(event), p.event, p.args)
+	}
+}
+
+// guard wraps g, which rejects the events without typed payload. Guards of timer events
+// are called with the zero args.
+func (f *FSM[S, E, A]) guard(g Guard[S, A]) fsm.Guard {
+	if g == nil {
+		return nil
+	}
+	return func(ctx context.Context, s *fsm.State, args fsm.ArgsType) bool {
+		state, ok := typedState[S](ctx, s)
+		if !ok {
+			return false
+		}
+		p, ok := args[payloadKey].(payload[E, A])
+		if _, timer := args[fsm.TimerExpiries]; !ok && !timer {
+			return false
+		}
+		return g(ctx, state, p.args)
+	}
+}
+
+// timerPayload adds the typed event and the zero args to the args of timer events,
+// before their callbacks are called
+func (f *FSM[S, E, A]) timerPayload(ctx context.Context, s *fsm.State, trans fsm.Transition, args fsm.ArgsType) {
+	f.addTimerPayload(trans.Event, args)
+}
+
+func (f *FSM[S, E, A]) addTimerPayload(event fsm.EventType, args fsm.ArgsType) {
+	if _, ok := args[payloadKey]; ok {
+		return
+	}
+	if _, ok := args[fsm.TimerExpiries]; !ok {
+		return
+	}
+	if typedEvent, ok := f.events[event]; ok {
+		args[payloadKey] = payload[E, A]{event: typedEvent}
+	}
+}
+
+// fsmHooks wraps hooks, which are skipped for events that were not sent by SendEvent or
+// by a timer
+func (f *FSM[S, E, A]) fsmHooks(hooks Hooks[S, E, A]) fsm.Hooks {
+	var fsmHooks fsm.Hooks
+	if hooks.BeforeTransition != nil {
+		fsmHooks.BeforeTransition = func(ctx context.Context, s *fsm.State, trans fsm.Transition, args fsm.ArgsType) {
+			if state, p, err := f.resolve(ctx, s, args); err == nil {
+				hooks.BeforeTransition(ctx, state, p.event, f.states[trans.From], f.states[trans.To], p.args)
+			}
+		}
+	}
+	if hooks.AfterTransition != nil {
+		fsmHooks.AfterTransition = func(ctx context.Context, s *fsm.State, record fsm.TransitionRecord) {
+			state, ok := typedState[S](ctx, s)
+			typedRecord, known := f.typedRecord(record)
+			if !ok || !known {
+				return
+			}
+			hooks.AfterTransition(ctx, state, typedRecord)
+		}
+	}
+	if hooks.EventRejected != nil {
+		fsmHooks.EventRejected = func(ctx context.Context, s *fsm.State, event fsm.EventType, args fsm.ArgsType, err error) {
+			f.addTimerPayload(event, args)
+			if state, p, resolveErr := f.resolve(ctx, s, args); resolveErr == nil {
+				hooks.EventRejected(ctx, state, p.event, p.args, err)
+			}
+		}
+	}
+	return fsmHooks
+}
+
+func phase(event fsm.EventType) Phase {
+	switch event {
+	case fsm.EntryEvent:
+		return OnEntry
+	case fsm.ExitEvent:
+		return OnExit
+	default:
+		return OnEvent
+	}
+}
+
+func stateName[S comparable](s S) fsm.StateType {
+	return fsm.StateType(fmt.Sprint(s))
+}
+
+func eventName[E comparable](event E) fsm.EventType {
+	return fsm.EventType(fmt.Sprint(event))
+}
+
+// State is a thread-safe structure that holds a typed state for an FSM
+type State[S comparable] struct {
+	state *fsm.State
+	// mutex guards values
+	mutex sync.RWMutex
+	// values maps the names of the states passed to NewState and Set to the typed values
+	values map[fsm.StateType]S
+	// fsmStates maps the names of the states of the last FSM that handled an event
+	fsmStates atomic.Pointer[map[fsm.StateType]S]
+}
+
+// NewState creates a State with current state set to initState
+func NewState[S comparable](initState S) *State[S] {
+	name := stateName(initState)
+	return &State[S]{
+		state:  fsm.NewState(name),
+		values: map[fsm.StateType]S{name: initState},
+	}
+}
+
+// Current returns the current state
+func (s *State[S]) Current() S {
+	name := s.state.Current()
+	if states := s.fsmStates.Load(); states != nil {
+		if value, ok := (*states)[name]; ok {
+			return value
+		}
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.values[name]
+}
+
+// Is returns true if the current state is equal to target
+func (s *State[S]) Is(target S) bool {
+	return s.state.Is(stateName(target))
+}
+
+// Set sets the current state to next
+func (s *State[S]) Set(next S) {
+	name := stateName(next)
+	s.mutex.Lock()
+	s.values[name] = next
+	s.mutex.Unlock()
+	s.state.Set(name)
+}
+
+// Untyped returns the underlying State of package fsm
+func (s *State[S]) Untyped() *fsm.State {
+	return s.state
+}
@@ -0,0 +1,258 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package typed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/omec-project/util/fsm"
+)
+
+type ueState int
+
+const (
+	deregistered ueState = iota
+	registered
+	idle
+	connected
+)
+
+func (s ueState) String() string {
+	return [...]string{"Deregistered", "Registered", "Idle", "Connected"}[s]
+}
+
+type ueEvent string
+
+const (
+	register   ueEvent = "Register"
+	connect    ueEvent = "Connect"
+	deregister ueEvent = "Deregister"
+)
+
+type ueArgs struct {
+	supi    string
+	allowed bool
+}
+
+func TestTypedFSM(t *testing.T) {
+	var calls []string
+	record := func(ctx context.Context, state *State[ueState], phase Phase, event ueEvent, args ueArgs) {
+		calls = append(calls, fmt.Sprintf("%s %d %s %s", state.Current(), phase, event, args.supi))
+	}
+	f, err := NewFSM([]Transition[ueState, ueEvent, ueArgs]{
+		{Event: register, From: deregistered, To: idle, Guard: func(ctx context.Context, state *State[ueState], args ueArgs) bool {
+			return args.allowed
+		}},
+		{Event: connect, From: idle, To: connected},
+		{Event: deregister, From: registered, To: deregistered},
+	}, Callbacks[ueState, ueEvent, ueArgs]{
+		deregistered: record,
+		idle:         record,
+		connected:    record,
+	}, WithSubstates[ueState, ueEvent, ueArgs](registered, idle, connected), WithHistory[ueState, ueEvent, ueArgs](4))
+	if err != nil {
+		t.Fatalf("NewFSM() failed: %v", err)
+	}
+
+	ctx := context.Background()
+	s := NewState(deregistered)
+	if err := f.SendEvent(ctx, s, register, ueArgs{supi: "imsi-1"}); err == nil {
+		t.Error("expected the guard to reject Register")
+	}
+	if err := f.SendEvent(ctx, s, register, ueArgs{supi: "imsi-1", allowed: true}); err != nil {
+		t.Fatalf("SendEvent() failed: %v", err)
+	}
+	if err := f.SendEvent(ctx, s, connect, ueArgs{supi: "imsi-1"}); err != nil {
+		t.Fatalf("SendEvent() failed: %v", err)
+	}
+	if s.Current() != connected || !f.In(s, registered) {
+		t.Errorf("unexpected state %s", s.Current())
+	}
+	// bubbles to the composite state Registered
+	if err := f.SendEvent(ctx, s, deregister, ueArgs{supi: "imsi-1"}); err != nil {
+		t.Fatalf("SendEvent() failed: %v", err)
+	}
+	expected := []string{
+		"Deregistered 0 Register imsi-1", "Deregistered 1 Register imsi-1", "Idle 2 Register imsi-1",
+		"Idle 0 Connect imsi-1", "Idle 1 Connect imsi-1", "Connected 2 Connect imsi-1",
+		"Connected 1 Deregister imsi-1", "Deregistered 2 Deregister imsi-1",
+	}
+	if !slices.Equal(calls, expected) {
+		t.Errorf("callbacks %v, expected %v", calls, expected)
+	}
+	if !s.Is(deregistered) {
+		t.Errorf("unexpected state %s", s.Current())
+	}
+	type step struct {
+		event    ueEvent
+		from, to ueState
+	}
+	var history []step
+	for _, record := range f.History(s) {
+		history = append(history, step{record.Event, record.From, record.To})
+	}
+	expectedHistory := []step{{register, deregistered, idle}, {connect, idle, connected}, {deregister, connected, deregistered}}
+	if !slices.Equal(history, expectedHistory) {
+		t.Errorf("History() = %v, expected %v", history, expectedHistory)
+	}
+
+	s.Set(connected)
+	if s.Current() != connected {
+		t.Errorf("Set() failed: got %s", s.Current())
+	}
+}
+
+func TestTypedErrorCallbacks(t *testing.T) {
+	failing := errors.New("entry failed")
+	f, err := NewFSM([]Transition[ueState, ueEvent, ueArgs]{
+		{Event: register, From: deregistered, To: idle},
+	}, nil, WithErrorCallbacks(ErrorCallbacks[ueState, ueEvent, ueArgs]{
+		idle: func(ctx context.Context, state *State[ueState], phase Phase, event ueEvent, args ueArgs) error {
+			if phase == OnEntry {
+				return failing
+			}
+			return nil
+		},
+	}), WithEntryFailurePolicy[ueState, ueEvent, ueArgs](fsm.EntryFailureRollback, deregistered))
+	if err != nil {
+		t.Fatalf("NewFSM() failed: %v", err)
+	}
+	s := NewState(deregistered)
+	err = f.SendEvent(context.Background(), s, register, ueArgs{})
+	var transitionErr *fsm.TransitionError
+	if !errors.As(err, &transitionErr) || !errors.Is(err, failing) || transitionErr.Callback != fsm.EntryEvent {
+		t.Errorf("unexpected error %v", err)
+	}
+	if s.Current() != deregistered {
+		t.Errorf("expected rollback to Deregistered, got %s", s.Current())
+	}
+
+	// a state has either a Callback or an ErrorCallback
+	noop := func(ctx context.Context, state *State[ueState], phase Phase, event ueEvent, args ueArgs) {}
+	if _, err := NewFSM([]Transition[ueState, ueEvent, ueArgs]{{Event: register, From: deregistered, To: idle}},
+		Callbacks[ueState, ueEvent, ueArgs]{idle: noop}, WithErrorCallbacks(ErrorCallbacks[ueState, ueEvent, ueArgs]{
+			idle: func(ctx context.Context, state *State[ueState], phase Phase, event ueEvent, args ueArgs) error {
+				return nil
+			},
+		})); err == nil {
+		t.Error("expected error for a state with two callbacks")
+	}
+}
+
+func TestTypedTimersAndHooks(t *testing.T) {
+	const timeout ueEvent = "Timeout"
+	clock := fsm.NewManualClock(time.Unix(0, 0))
+	var calls, hooks []string
+	f, err := NewFSM([]Transition[ueState, ueEvent, ueArgs]{
+		{Event: register, From: deregistered, To: idle},
+		{Event: timeout, From: idle, To: deregistered, Guard: func(ctx context.Context, state *State[ueState], args ueArgs) bool {
+			return args == ueArgs{}
+		}},
+	}, Callbacks[ueState, ueEvent, ueArgs]{
+		deregistered: func(ctx context.Context, state *State[ueState], phase Phase, event ueEvent, args ueArgs) {
+			calls = append(calls, fmt.Sprintf("%s %d %s %q", state.Current(), phase, event, args.supi))
+		},
+		idle: func(ctx context.Context, state *State[ueState], phase Phase, event ueEvent, args ueArgs) {},
+	}, WithClock[ueState, ueEvent, ueArgs](clock),
+		WithTimers[ueState, ueEvent, ueArgs](Timer[ueState, ueEvent]{State: idle, Event: timeout, Duration: time.Second}),
+		WithHooks(Hooks[ueState, ueEvent, ueArgs]{
+			BeforeTransition: func(ctx context.Context, state *State[ueState], event ueEvent, from, to ueState, args ueArgs) {
+				hooks = append(hooks, fmt.Sprintf("before %s %s-%s %q", event, from, to, args.supi))
+			},
+			AfterTransition: func(ctx context.Context, state *State[ueState], record TransitionRecord[ueState, ueEvent]) {
+				hooks = append(hooks, fmt.Sprintf("after %s %s-%s", record.Event, record.From, record.To))
+			},
+			EventRejected: func(ctx context.Context, state *State[ueState], event ueEvent, args ueArgs, err error) {
+				hooks = append(hooks, fmt.Sprintf("rejected %s %q", event, args.supi))
+			},
+		}),
+		WithStrictValidation[ueState, ueEvent, ueArgs](Validation[ueState, ueEvent]{Events: []ueEvent{register}}))
+	if err != nil {
+		t.Fatalf("NewFSM() failed: %v", err)
+	}
+
+	ctx := context.Background()
+	s := NewState(deregistered)
+	if err := f.SendEvent(ctx, s, register, ueArgs{supi: "imsi-1"}); err != nil {
+		t.Fatalf("SendEvent() failed: %v", err)
+	}
+	if err := f.SendEvent(ctx, s, connect, ueArgs{supi: "imsi-1"}); err == nil {
+		t.Error("expected Connect to be rejected")
+	}
+	clock.Advance(time.Second)
+	if !s.Is(deregistered) {
+		t.Errorf("expected the timer to move to Deregistered, got %s", s.Current())
+	}
+	expectedCalls := []string{
+		`Deregistered 0 Register "imsi-1"`, `Deregistered 1 Register "imsi-1"`, `Deregistered 2 Timeout ""`,
+	}
+	if !slices.Equal(calls, expectedCalls) {
+		t.Errorf("callbacks %v, expected %v", calls, expectedCalls)
+	}
+	expectedHooks := []string{
+		`before Register Deregistered-Idle "imsi-1"`, "after Register Deregistered-Idle",
+		`rejected Connect "imsi-1"`,
+		`before Timeout Idle-Deregistered ""`, "after Timeout Idle-Deregistered",
+	}
+	if !slices.Equal(hooks, expectedHooks) {
+		t.Errorf("hooks %v, expected %v", hooks, expectedHooks)
+	}
+
+	// strict validation reports the unreachable Connected
+	initial := deregistered
+	if _, err := NewFSM([]Transition[ueState, ueEvent, ueArgs]{
+		{Event: register, From: deregistered, To: idle},
+		{Event: connect, From: connected, To: idle},
+	}, nil, WithStrictValidation[ueState, ueEvent, ueArgs](Validation[ueState, ueEvent]{
+		Initial: &initial, Terminal: []ueState{idle},
+	})); err == nil {
+		t.Error("expected strict validation to fail")
+	}
+}
+
+func TestTypedUntypedEvent(t *testing.T) {
+	f, err := NewFSM([]Transition[ueState, ueEvent, ueArgs]{
+		{Event: register, From: deregistered, To: idle},
+		{Event: connect, From: idle, To: connected, Guard: func(ctx context.Context, state *State[ueState], args ueArgs) bool {
+			return true
+		}},
+	}, Callbacks[ueState, ueEvent, ueArgs]{
+		deregistered: func(ctx context.Context, state *State[ueState], phase Phase, event ueEvent, args ueArgs) {},
+	})
+	if err != nil {
+		t.Fatalf("NewFSM() failed: %v", err)
+	}
+	ctx := context.Background()
+	s := NewState(deregistered)
+	if err := f.Untyped().SendEvent(ctx, s.Untyped(), fsm.EventType(register), nil); !errors.Is(err, ErrUntypedEvent) {
+		t.Errorf("expected ErrUntypedEvent for an untyped event, got %v", err)
+	}
+	if !s.Is(deregistered) {
+		t.Errorf("expected Deregistered after the failed event callback, got %s", s.Current())
+	}
+	s.Set(idle)
+	if err := f.Untyped().SendEvent(ctx, s.Untyped(), fsm.EventType(connect), nil); err == nil || !s.Is(idle) {
+		t.Errorf("expected the guard to reject an untyped event, got %v in %s", err, s.Current())
+	}
+}
+
+func TestTypedNames(t *testing.T) {
+	type state struct{ name string }
+	if _, err := NewFSM([]Transition[any, string, struct{}]{
+		{Event: "Go", From: 1, To: "1"},
+	}, nil); err == nil {
+		t.Error("expected error for states with the same name")
+	}
+	if _, err := NewFSM([]Transition[state, string, struct{}]{
+		{Event: string(fsm.EntryEvent), From: state{"a"}, To: state{"b"}},
+	}, nil); err == nil {
+		t.Error("expected error for a reserved event name")
+	}
+}
@@ -0,0 +1,161 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package fsm
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Validation configures the checks of FSM.Validate
+type Validation struct {
+	// Initial is the state the States of the FSM are created in, states that no sequence
+	// of transitions leads to from Initial are unreachable. Reachability is not checked if
+	// Initial is empty.
+	Initial StateType
+	// Terminal states are not reported as dead ends
+	Terminal []StateType
+	// Events are the events sent to the FSM, in addition to the events of its timers
+	Events []EventType
+}
+
+// ValidationReport lists the problems found by FSM.Validate, each sorted by name
+type ValidationReport struct {
+	// MissingCallbacks are the states without Callback or ErrorCallback
+	MissingCallbacks []StateType
+	// Unreachable are the states that cannot be reached from the initial state
+	Unreachable []StateType
+	// DeadEnds are the non-terminal states without a transition to another state, of
+	// their own or of a composite state they belong to
+	DeadEnds []StateType
+	// UnhandledEvents are the events without a transition from any state
+	UnhandledEvents []EventType
+}
+
+// WithStrictValidation makes NewFSM fail if Validate reports a problem
+func WithStrictValidation(validation Validation) Option {
+	return func(fsm *FSM) {
+		fsm.validation = &validation
+	}
+}
+
+// Validate checks the transition graph of the FSM
+func (fsm *FSM) Validate(validation Validation) ValidationReport {
+	var report ValidationReport
+	terminal := make(map[StateType]bool)
+	for _, s := range validation.Terminal {
+		terminal[s] = true
+	}
+	targets := make(map[StateType]bool)
+	leaving := make(map[StateType]bool)
+	handled := make(map[EventType]bool)
+	for key, candidates := range fsm.transitions {
+		handled[key.Event] = true
+		for _, trans := range candidates {
+			targets[trans.To] = true
+			if trans.From != trans.To {
+				leaving[trans.From] = true
+			}
+		}
+	}
+	children := fsm.children()
+
+	for _, s := range slices.Sorted(maps.Keys(fsm.states)) {
+		if _, ok := fsm.callbacks[s]; !ok {
+			report.MissingCallbacks = append(report.MissingCallbacks, s)
+		}
+		// a composite state that is never the target of a transition only groups its sub-states
+		_, composite := children[s]
+		if terminal[s] || (composite && !targets[s] && s != validation.Initial) {
+			continue
+		}
+		if !slices.ContainsFunc(fsm.ancestors(s), func(a StateType) bool { return leaving[a] }) {
+			report.DeadEnds = append(report.DeadEnds, s)
+		}
+	}
+
+	if validation.Initial != "" {
+		reachable := fsm.reachable(validation.Initial)
+		for _, s := range slices.Sorted(maps.Keys(fsm.states)) {
+			if !reachable[s] {
+				report.Unreachable = append(report.Unreachable, s)
+			}
+		}
+	}
+
+	events := slices.Clone(validation.Events)
+	for _, timers := range fsm.timers {
+		for _, timer := range timers {
+			events = append(events, timer.Event)
+			if timer.ExhaustedEvent != "" {
+				events = append(events, timer.ExhaustedEvent)
+			}
+		}
+	}
+	slices.Sort(events)
+	for _, event := range slices.Compact(events) {
+		if !handled[event] {
+			report.UnhandledEvents = append(report.UnhandledEvents, event)
+		}
+	}
+	return report
+}
+
+// reachable returns the states that can be reached from initial. The composite states of
+// a reachable state are reachable too, and their transitions can be taken from it.
+func (fsm *FSM) reachable(initial StateType) map[StateType]bool {
+	outgoing := make(map[StateType][]StateType)
+	for key, candidates := range fsm.transitions {
+		for _, trans := range candidates {
+			outgoing[key.From] = append(outgoing[key.From], trans.To)
+		}
+	}
+	reachable := make(map[StateType]bool)
+	pending := []StateType{initial}
+	if fsm.entryFailurePolicy == EntryFailureErrorState {
+		pending = append(pending, fsm.errorState)
+	}
+	for len(pending) > 0 {
+		s := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, active := range fsm.ancestors(s) {
+			if reachable[active] {
+				continue
+			}
+			reachable[active] = true
+			pending = append(pending, outgoing[active]...)
+		}
+	}
+	return reachable
+}
+
+// Valid returns true if no problem was found
+func (r ValidationReport) Valid() bool {
+	return len(r.MissingCallbacks) == 0 && len(r.Unreachable) == 0 && len(r.DeadEnds) == 0 &&
+		len(r.UnhandledEvents) == 0
+}
+
+// Err returns an error describing the problems, or nil if there are none
+func (r ValidationReport) Err() error {
+	if r.Valid() {
+		return nil
+	}
+	var problems []string
+	if len(r.MissingCallbacks) > 0 {
+		problems = append(problems, fmt.Sprintf("states without callback %v", r.MissingCallbacks))
+	}
+	if len(r.Unreachable) > 0 {
+		problems = append(problems, fmt.Sprintf("unreachable states %v", r.Unreachable))
+	}
+	if len(r.DeadEnds) > 0 {
+		problems = append(problems, fmt.Sprintf("dead-end states %v", r.DeadEnds))
+	}
+	if len(r.UnhandledEvents) > 0 {
+		problems = append(problems, fmt.Sprintf("unhandled events %v", r.UnhandledEvents))
+	}
+	return fmt.Errorf("invalid fsm: %s", strings.Join(problems, ", "))
+}
@@ -0,0 +1,355 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/omec-project/util/logger"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const defaultHistoryCollection = "auditHistory"
+
+// AuditOperation is the kind of change recorded in the history
+type AuditOperation string
+
+const (
+	AuditPut    AuditOperation = "put"
+	AuditPatch  AuditOperation = "patch"
+	AuditDelete AuditOperation = "delete"
+)
+
+// AuditConfig configures the collections whose changes are recorded in the history.
+// The history is written after the change, so a change is not recorded if the process
+// stops in between, and concurrent changes of a document may be recorded as one.
+type AuditConfig struct {
+	// Collections maps the audited collections to the fields that identify their
+	// documents in the history, such as ueId. Documents are identified by _id if no
+	// fields are given.
+	Collections map[string][]string
+	// HistoryCollection stores the AuditRecords, "auditHistory" by default. Queries of
+	// GetHistory are served best by an index on collection and the key fields.
+	HistoryCollection string
+}
+
+// AuditRecord is a change of a document recorded in the history
+type AuditRecord struct {
+	Collection string         `bson:"collection"`
+	DocumentID any            `bson:"documentId"`
+	Key        map[string]any `bson:"key"`
+	Operation  AuditOperation `bson:"operation"`
+	Actor      string         `bson:"actor"`
+	Timestamp  time.Time      `bson:"timestamp"`
+	// Patch is the RFC 6902 JSON Patch from the document before the change to the
+	// document after the change, where a missing document is an empty object
+	Patch string `bson:"patch"`
+}
+
+type actorKey struct{}
+
+// WithActor returns a context that attributes the changes made with it to actor
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func actorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// SetAudit enables recording the changes of the configured collections, nil disables it
+func (c *MongoClient) SetAudit(audit *AuditConfig) {
+	c.audit = audit
+}
+
+// GetHistory returns the changes of the documents of collName identified by key, which
+// holds values of the key fields configured for collName, or the _id. The changes are
+// ordered by time unless opts specify another sort order, and opts.PageToken continues
+// with the next page. The returned token is empty after the last page.
+func (c *MongoClient) GetHistory(ctx context.Context, collName string, key bson.M, opts *QueryOptions) (records []AuditRecord, token string, err error) {
+	defer c.observe("GetHistory", collName, time.Now(), &records, &err)
+	audit := c.audit
+	if audit == nil {
+		return nil, "", nil
+	}
+	query := QueryOptions{}
+	if opts != nil {
+		query = *opts
+	}
+	if len(query.Sort) == 0 {
+		query.Sort = []SortField{{Field: "timestamp"}}
+	}
+	filter := bson.M{"collection": collName}
+	for field, value := range key {
+		filter["key."+field] = value
+	}
+	page, err := c.FindPage(ctx, audit.historyCollection(), filter, &query)
+	if err != nil {
+		return nil, "", fmt.Errorf("GetHistory err: %w", err)
+	}
+	records = make([]AuditRecord, 0, len(page.Documents))
+	for _, doc := range page.Documents {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, "", fmt.Errorf("GetHistory err: %w", err)
+		}
+		var record AuditRecord
+		if err := bson.Unmarshal(raw, &record); err != nil {
+			return nil, "", fmt.Errorf("GetHistory err: %w", err)
+		}
+		records = append(records, record)
+	}
+	return records, page.NextPageToken, nil
+}
+
+func (a *AuditConfig) historyCollection() string {
+	if a.HistoryCollection != "" {
+		return a.HistoryCollection
+	}
+	return defaultHistoryCollection
+}
+
+// auditChange captures the documents affected by a write before the write, so that
+// the change can be recorded after it
+type auditChange struct {
+	client     *MongoClient
+	collName   string
+	keyFields  []string
+	collection *mongo.Collection
+	filter     bson.M
+	operation  AuditOperation
+	many       bool
+	before     []map[string]any
+}
+
+// beginAudit reads the documents matching filter that are about to be changed by a
+// write of a single document, or of all matching documents if many is set. It returns
+// nil if collName is not audited.
+func (c *MongoClient) beginAudit(ctx context.Context, collName string, filter bson.M, operation AuditOperation,
+	many bool,
+) (*auditChange, error) {
+	change := c.newAuditChange(collName, filter, operation, many)
+	if change == nil {
+		return nil, nil
+	}
+	before, err := change.find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("audit err: %w", classifyError(err))
+	}
+	change.before = before
+	return change, nil
+}
+
+// auditInserted records the insertion of the documents of collName with the given ids
+func (c *MongoClient) auditInserted(ctx context.Context, collName string, ids ...any) {
+	c.newAuditChange(collName, bson.M{"_id": bson.M{"$in": ids}}, AuditPut, true).commit(ctx)
+}
+
+func (c *MongoClient) newAuditChange(collName string, filter bson.M, operation AuditOperation, many bool) *auditChange {
+	audit := c.audit
+	if audit == nil {
+		return nil
+	}
+	keyFields, audited := audit.Collections[collName]
+	if !audited {
+		return nil
+	}
+	return &auditChange{
+		client:     c,
+		collName:   collName,
+		keyFields:  keyFields,
+		collection: c.Client.Database(c.dbName).Collection(collName),
+		filter:     filter,
+		operation:  operation,
+		many:       many,
+	}
+}
+
+// commit records the change made by the write in the history. The write has already
+// been applied, so failures are logged rather than returned.
+func (a *auditChange) commit(ctx context.Context) {
+	if a == nil {
+		return
+	}
+	if err := a.record(ctx); err != nil {
+		logger.MongoapiLog.Errorf("failed to record %s of %s in history: %v", a.operation, a.collName, err)
+	}
+}
+
+func (a *auditChange) record(ctx context.Context) error {
+	var after []map[string]any
+	if a.operation != AuditDelete {
+		// documents are found again by _id, since the write may change the filtered fields,
+		// and by filter, to find documents inserted by the write
+		ids := make(bson.A, 0, len(a.before))
+		for _, doc := range a.before {
+			ids = append(ids, doc["_id"])
+		}
+		filter := bson.M{"$or": bson.A{a.filter, bson.M{"_id": bson.M{"$in": ids}}}}
+		if !a.many && len(ids) > 0 {
+			filter = bson.M{"_id": ids[0]}
+		}
+		var err error
+		if after, err = a.find(ctx, filter); err != nil {
+			return err
+		}
+	}
+
+	changes := make(map[string][2]map[string]any)
+	var order []string
+	for i, docs := range [][]map[string]any{a.before, after} {
+		for _, doc := range docs {
+			id := fmt.Sprint(doc["_id"])
+			pair, seen := changes[id]
+			if !seen {
+				order = append(order, id)
+			}
+			pair[i] = doc
+			changes[id] = pair
+		}
+	}
+
+	now := time.Now()
+	actor := actorFromContext(ctx)
+	var records []any
+	for _, id := range order {
+		pair := changes[id]
+		patch, err := auditPatch(pair[0], pair[1])
+		if err != nil {
+			return err
+		}
+		if patch == "[]" {
+			continue
+		}
+		current := pair[1]
+		if current == nil {
+			current = pair[0]
+		}
+		records = append(records, AuditRecord{
+			Collection: a.collName,
+			DocumentID: current["_id"],
+			Key:        auditKey(current, a.keyFields),
+			Operation:  a.operation,
+			Actor:      actor,
+			Timestamp:  now,
+			Patch:      patch,
+		})
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	history := a.client.Client.Database(a.client.dbName).Collection(a.client.audit.historyCollection())
+	_, err := history.InsertMany(ctx, records)
+	return err
+}
+
+func (a *auditChange) find(ctx context.Context, filter bson.M) ([]map[string]any, error) {
+	if !a.many {
+		doc, err := findOneAndDecodeWithContext(ctx, a.collection, filter)
+		if err != nil || doc == nil {
+			return nil, err
+		}
+		return []map[string]any{doc}, nil
+	}
+	cursor, err := a.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var docs []map[string]any
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// auditKey returns the key fields of doc, or its _id if there are no key fields
+func auditKey(doc map[string]any, keyFields []string) map[string]any {
+	if len(keyFields) == 0 {
+		return map[string]any{"_id": doc["_id"]}
+	}
+	key := make(map[string]any, len(keyFields))
+	for _, field := range keyFields {
+		if value, found := lookupPath(doc, field); found {
+			// nested like in the document, so that GetHistory can filter on the same path
+			_ = setPath(key, field, value)
+		}
+	}
+	return key
+}
+
+// auditPatch returns the JSON Patch from before to after, ignoring the internal _id and
+// version fields. The patch is derived from the merge patch created by jsonpatch, whose
+// members are translated into add, remove and replace operations.
+func auditPatch(before, after map[string]any) (string, error) {
+	beforeJSON, err := auditJSON(before)
+	if err != nil {
+		return "", err
+	}
+	afterJSON, err := auditJSON(after)
+	if err != nil {
+		return "", err
+	}
+	mergePatch, err := jsonpatch.CreateMergePatch(beforeJSON, afterJSON)
+	if err != nil {
+		return "", err
+	}
+	var patch, beforeDoc, afterDoc map[string]any
+	for _, decode := range []struct {
+		data []byte
+		into *map[string]any
+	}{{mergePatch, &patch}, {beforeJSON, &beforeDoc}, {afterJSON, &afterDoc}} {
+		if err := json.Unmarshal(decode.data, decode.into); err != nil {
+			return "", err
+		}
+	}
+	operations := []map[string]any{}
+	appendPatchOperations(&operations, "", patch, beforeDoc, afterDoc)
+	result, err := json.Marshal(operations)
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
+
+func auditJSON(doc map[string]any) ([]byte, error) {
+	content := make(map[string]any, len(doc))
+	maps.Copy(content, doc)
+	delete(content, "_id")
+	delete(content, VersionField)
+	return json.Marshal(content)
+}
+
+// appendPatchOperations translates the members of a merge patch between before and
+// after into JSON Patch operations. A null member removes the field unless after holds
+// an explicit null, and nested patches of objects are translated recursively.
+func appendPatchOperations(operations *[]map[string]any, prefix string, patch, before, after map[string]any) {
+	for _, key := range slices.Sorted(maps.Keys(patch)) {
+		path := prefix + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+		oldValue, existed := before[key]
+		newValue, exists := after[key]
+		nested, isPatch := patch[key].(map[string]any)
+		oldDoc, oldIsDoc := oldValue.(map[string]any)
+		newDoc, newIsDoc := newValue.(map[string]any)
+		switch {
+		case !exists:
+			*operations = append(*operations, map[string]any{"op": "remove", "path": path})
+		case !existed:
+			*operations = append(*operations, map[string]any{"op": "add", "path": path, "value": newValue})
+		case isPatch && oldIsDoc && newIsDoc:
+			appendPatchOperations(operations, path, nested, oldDoc, newDoc)
+		default:
+			*operations = append(*operations, map[string]any{"op": "replace", "path": path, "value": newValue})
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+func TestAuditPatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   map[string]any
+		after    map[string]any
+		expected string
+	}{
+		{
+			name:     "insert",
+			after:    map[string]any{"_id": "1", "ueId": "imsi-1", "sst": 1},
+			expected: `[{"op":"add","path":"/sst","value":1},{"op":"add","path":"/ueId","value":"imsi-1"}]`,
+		},
+		{
+			name:     "delete",
+			before:   map[string]any{"_id": "1", VersionField: 3, "ueId": "imsi-1"},
+			expected: `[{"op":"remove","path":"/ueId"}]`,
+		},
+		{
+			name: "nested change",
+			before: map[string]any{"_id": "1", VersionField: 1, "slice": map[string]any{
+				"sst": 1, "sd": "010203", "dnn": []any{"internet"},
+			}},
+			after: map[string]any{"_id": "1", VersionField: 2, "slice": map[string]any{
+				"sst": 2, "dnn": []any{"internet", "ims"}, "qos/5qi": 9,
+			}},
+			expected: `[{"op":"replace","path":"/slice/dnn","value":["internet","ims"]},` +
+				`{"op":"add","path":"/slice/qos~15qi","value":9},` +
+				`{"op":"remove","path":"/slice/sd"},` +
+				`{"op":"replace","path":"/slice/sst","value":2}]`,
+		},
+		{
+			name:     "explicit null",
+			before:   map[string]any{"policy": "gold", "limit": 5},
+			after:    map[string]any{"policy": nil, "limit": 5},
+			expected: `[{"op":"replace","path":"/policy","value":null}]`,
+		},
+		{
+			name:     "object replaced by scalar",
+			before:   map[string]any{"policy": map[string]any{"name": "gold"}},
+			after:    map[string]any{"policy": "silver"},
+			expected: `[{"op":"replace","path":"/policy","value":"silver"}]`,
+		},
+		{
+			name:     "unchanged apart from version",
+			before:   map[string]any{"_id": "1", VersionField: 1, "ueId": "imsi-1"},
+			after:    map[string]any{"_id": "1", VersionField: 2, "ueId": "imsi-1"},
+			expected: `[]`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			patch, err := auditPatch(tc.before, tc.after)
+			if err != nil {
+				t.Fatalf("auditPatch() failed: %v", err)
+			}
+			if patch != tc.expected {
+				t.Errorf("auditPatch() = %s, expected %s", patch, tc.expected)
+			}
+
+			// applying the patch to the document before must yield the document after
+			beforeJSON, _ := auditJSON(tc.before)
+			afterJSON, _ := auditJSON(tc.after)
+			decoded, err := jsonpatch.DecodePatch([]byte(patch))
+			if err != nil {
+				t.Fatalf("DecodePatch() failed: %v", err)
+			}
+			applied, err := decoded.Apply(beforeJSON)
+			if err != nil {
+				t.Fatalf("Apply() failed: %v", err)
+			}
+			if !jsonpatch.Equal(applied, afterJSON) {
+				t.Errorf("patched document %s, expected %s", applied, afterJSON)
+			}
+		})
+	}
+}
+
+func TestAuditKey(t *testing.T) {
+	doc := map[string]any{"_id": "abc", "ueId": "imsi-1", "plmn": map[string]any{"mcc": "001"}}
+	if key := auditKey(doc, nil); !reflect.DeepEqual(key, map[string]any{"_id": "abc"}) {
+		t.Errorf("unexpected key %v", key)
+	}
+	key := auditKey(doc, []string{"ueId", "plmn.mcc", "missing"})
+	if !reflect.DeepEqual(key, map[string]any{"ueId": "imsi-1", "plmn": map[string]any{"mcc": "001"}}) {
+		t.Errorf("unexpected key %v", key)
+	}
+}
+
+func TestAuditActor(t *testing.T) {
+	if actor := actorFromContext(context.Background()); actor != "" {
+		t.Errorf("unexpected actor %q", actor)
+	}
+	ctx := WithActor(context.Background(), "webui:admin")
+	if actor := actorFromContext(ctx); actor != "webui:admin" {
+		t.Errorf("unexpected actor %q", actor)
+	}
+}
+
+func TestAuditDisabled(t *testing.T) {
+	c := &MongoClient{}
+	if change := c.newAuditChange("subscribers", nil, AuditPut, false); change != nil {
+		t.Error("changes should not be audited without configuration")
+	}
+	c.SetAudit(&AuditConfig{Collections: map[string][]string{"policies": nil}})
+	if change := c.newAuditChange("subscribers", nil, AuditPut, false); change != nil {
+		t.Error("changes of collections that are not configured should not be audited")
+	}
+	// commit of an unaudited change is a no-op
+	var change *auditChange
+	change.commit(context.Background())
+}
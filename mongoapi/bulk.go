@@ -0,0 +1,425 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type BulkOperationType int
+
+const (
+	// BulkUpsert sets the fields of Data on the document matching Filter, inserting
+	// a document built from Filter and Data if there is none
+	BulkUpsert BulkOperationType = iota
+	// BulkDelete deletes the first document matching Filter
+	BulkDelete
+	// BulkMergePatch applies Data as an RFC 7386 merge patch to the document matching
+	// Filter: null values remove fields and nested objects are merged member-wise
+	BulkMergePatch
+	// BulkInsert inserts Data, Filter is ignored
+	BulkInsert
+	// BulkUpdate sets the fields of Data on the document matching Filter, if there is one
+	BulkUpdate
+)
+
+func (t BulkOperationType) String() string {
+	switch t {
+	case BulkUpsert:
+		return "upsert"
+	case BulkDelete:
+		return "delete"
+	case BulkMergePatch:
+		return "merge patch"
+	case BulkInsert:
+		return "insert"
+	case BulkUpdate:
+		return "update"
+	default:
+		return fmt.Sprintf("BulkOperationType(%d)", int(t))
+	}
+}
+
+// BulkOperation is a single write of a bulk write
+type BulkOperation struct {
+	Type   BulkOperationType
+	Filter bson.M
+	Data   map[string]any
+}
+
+// BulkItemResult reports the outcome of the operation at Index of a bulk write
+type BulkItemResult struct {
+	Index int
+	// Upserted is true if the operation inserted a new document
+	Upserted bool
+	// Err is nil if the operation succeeded, and ErrBulkNotExecuted if it was skipped
+	// because an earlier operation of an ordered bulk write failed
+	Err error
+}
+
+// BulkWriteResult reports the outcome of a bulk write, with one item per operation
+type BulkWriteResult struct {
+	InsertedCount int64
+	MatchedCount  int64
+	ModifiedCount int64
+	UpsertedCount int64
+	DeletedCount  int64
+	Items         []BulkItemResult
+}
+
+// Failed returns the results of the operations that did not succeed
+func (r *BulkWriteResult) Failed() []BulkItemResult {
+	var failed []BulkItemResult
+	for _, item := range r.Items {
+		if item.Err != nil {
+			failed = append(failed, item)
+		}
+	}
+	return failed
+}
+
+// ErrBulkNotExecuted is reported for the operations of an ordered bulk write that
+// follow a failed operation
+var ErrBulkNotExecuted = errors.New("operation not executed because an earlier operation failed")
+
+// RestfulAPIBulkWrite executes operations on collName in a single BulkWrite. In ordered
+// mode the operations are applied in sequence and the first failure stops the bulk
+// write, in unordered mode the server may apply them in any order and continues after
+// failures. The result is returned even if operations failed, in which case the error
+// summarizes the failures and the per-operation errors are available in the result.
+func (c *MongoClient) RestfulAPIBulkWrite(ctx context.Context, collName string, operations []BulkOperation,
+	ordered bool,
+) (result *BulkWriteResult, err error) {
+	defer c.observe("RestfulAPIBulkWrite", collName, time.Now(), &result, &err)
+	collection := c.Client.Database(c.dbName).Collection(collName)
+
+	result = newBulkWriteResult(len(operations))
+	if len(operations) == 0 {
+		return result, nil
+	}
+
+	fields := c.fieldCipher(collName)
+	models := make([]mongo.WriteModel, 0, len(operations))
+	changes := make([]*auditChange, len(operations))
+	// insertedIDs holds the _id of the documents of the inserts, which are audited by _id
+	insertedIDs := make([]any, len(operations))
+	for i, operation := range operations {
+		if operation.Type != BulkDelete {
+			if operation.Data, err = fields.encrypt(operation.Data, ""); err != nil {
+				return result, fmt.Errorf("RestfulAPIBulkWrite operation %d err: %w", i, err)
+			}
+		}
+		if operation.Type == BulkInsert {
+			operation.Data = withVersion(operation.Data)
+			if _, ok := operation.Data["_id"]; !ok {
+				operation.Data["_id"] = bson.NewObjectID()
+			}
+			insertedIDs[i] = operation.Data["_id"]
+		} else {
+			filter := operation.Filter
+			if filter == nil {
+				filter = bson.M{}
+			}
+			if changes[i], err = c.beginAudit(ctx, collName, filter, bulkAuditOperation(operation.Type), false); err != nil {
+				return result, fmt.Errorf("RestfulAPIBulkWrite operation %d %w", i, err)
+			}
+		}
+		model, err := bulkWriteModel(operation)
+		if err != nil {
+			return result, fmt.Errorf("RestfulAPIBulkWrite operation %d err: %w", i, err)
+		}
+		models = append(models, model)
+	}
+
+	bulkResult, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(ordered))
+	if bulkResult != nil {
+		result.InsertedCount = bulkResult.InsertedCount
+		result.MatchedCount = bulkResult.MatchedCount
+		result.ModifiedCount = bulkResult.ModifiedCount
+		result.UpsertedCount = bulkResult.UpsertedCount
+		result.DeletedCount = bulkResult.DeletedCount
+		for index := range bulkResult.UpsertedIDs {
+			result.Items[index].Upserted = true
+		}
+	}
+	if err != nil {
+		var bulkErr mongo.BulkWriteException
+		if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+			return result, fmt.Errorf("RestfulAPIBulkWrite BulkWrite err: %w", classifyError(err))
+		}
+		firstFailed := len(operations)
+		for _, writeErr := range bulkErr.WriteErrors {
+			result.Items[writeErr.Index].Err = mongo.WriteException{
+				WriteErrors: []mongo.WriteError{writeErr.WriteError},
+				Labels:      bulkErr.Labels,
+			}
+			firstFailed = min(firstFailed, writeErr.Index)
+		}
+		if ordered {
+			for i := firstFailed + 1; i < len(operations); i++ {
+				result.Items[i].Err = ErrBulkNotExecuted
+			}
+		}
+	}
+	for i, change := range changes {
+		if result.Items[i].Err != nil {
+			continue
+		}
+		if insertedIDs[i] != nil {
+			c.auditInserted(ctx, collName, insertedIDs[i])
+		} else {
+			change.commit(ctx)
+		}
+	}
+	return result, result.err("RestfulAPIBulkWrite")
+}
+
+func bulkAuditOperation(operationType BulkOperationType) AuditOperation {
+	switch operationType {
+	case BulkDelete:
+		return AuditDelete
+	case BulkMergePatch:
+		return AuditPatch
+	default:
+		return AuditPut
+	}
+}
+
+// RestfulAPIPutMany updates the document matching filterArray[i] with putDataArray[i],
+// or inserts putDataArray[i] if there is no such document, stopping at the first failure.
+// The existing documents are looked up with a single query and the writes are sent in
+// a single ordered bulk write, the filters may only use the operators of MemoryDB.
+func (c *MongoClient) RestfulAPIPutMany(collName string, filterArray []bson.M, putDataArray []map[string]any) (err error) {
+	defer c.observe("RestfulAPIPutMany", collName, time.Now(), nil, &err)
+	if len(filterArray) < len(putDataArray) {
+		return fmt.Errorf("RestfulAPIPutMany err: %d filters for %d documents", len(filterArray), len(putDataArray))
+	}
+	if len(putDataArray) == 0 {
+		return nil
+	}
+	filters := make(bson.A, 0, len(putDataArray))
+	for _, filter := range filterArray[:len(putDataArray)] {
+		filters = append(filters, filter)
+	}
+	collection := c.Client.Database(c.dbName).Collection(collName)
+	cursor, err := collection.Find(context.TODO(), bson.M{"$or": filters})
+	if err != nil {
+		return fmt.Errorf("RestfulAPIPutMany Find err: %w", classifyError(err))
+	}
+	defer cursor.Close(context.TODO())
+	var existing []map[string]any
+	for cursor.Next(context.TODO()) {
+		doc, err := decodeDocument(cursor.Current)
+		if err != nil {
+			return fmt.Errorf("RestfulAPIPutMany Find err: %w", err)
+		}
+		existing = append(existing, doc)
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("RestfulAPIPutMany Find err: %w", classifyError(err))
+	}
+
+	operations, err := putManyOperations(filterArray, putDataArray, existing)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIPutMany err: %w", err)
+	}
+	if _, err := c.RestfulAPIBulkWrite(context.TODO(), collName, operations, true); err != nil {
+		return fmt.Errorf("RestfulAPIPutMany err: %w", err)
+	}
+	return nil
+}
+
+// putManyOperations returns the writes of RestfulAPIPutMany given the documents matching
+// any of its filters. putDataArray[i] updates the document matching filterArray[i] as
+// left by the earlier writes, and is inserted without the filter fields if there is none.
+func putManyOperations(filterArray []bson.M, putDataArray []map[string]any, existing []map[string]any,
+) ([]BulkOperation, error) {
+	docs := existing
+	operations := make([]BulkOperation, 0, len(putDataArray))
+	for i, putData := range putDataArray {
+		filter, err := normalizeDocument(filterArray[i])
+		if err != nil {
+			return nil, err
+		}
+		data, err := normalizeDocument(putData)
+		if err != nil {
+			return nil, err
+		}
+		var matched map[string]any
+		for _, doc := range docs {
+			if ok, err := matchDocument(doc, filter); err != nil {
+				return nil, err
+			} else if ok {
+				matched = doc
+				break
+			}
+		}
+		if matched == nil {
+			operations = append(operations, BulkOperation{Type: BulkInsert, Data: putData})
+			docs = append(docs, data)
+			continue
+		}
+		operations = append(operations, BulkOperation{Type: BulkUpdate, Filter: filterArray[i], Data: putData})
+		for key, value := range data {
+			if err := setPath(matched, key, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return operations, nil
+}
+
+func newBulkWriteResult(count int) *BulkWriteResult {
+	result := &BulkWriteResult{Items: make([]BulkItemResult, count)}
+	for i := range result.Items {
+		result.Items[i].Index = i
+	}
+	return result
+}
+
+// err summarizes the failed operations, wrapping the first error that caused a failure
+func (r *BulkWriteResult) err(name string) error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	for _, item := range failed {
+		if !errors.Is(item.Err, ErrBulkNotExecuted) {
+			return fmt.Errorf("%s: %d of %d operations failed, operation %d err: %w",
+				name, len(failed), len(r.Items), item.Index, item.Err)
+		}
+	}
+	return fmt.Errorf("%s: %d of %d operations failed: %w", name, len(failed), len(r.Items), ErrBulkNotExecuted)
+}
+
+func bulkWriteModel(operation BulkOperation) (mongo.WriteModel, error) {
+	filter := operation.Filter
+	if filter == nil {
+		filter = bson.M{}
+	}
+	switch operation.Type {
+	case BulkUpsert:
+		update := bson.M{"$set": operation.Data, "$inc": bson.M{VersionField: 1}}
+		return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true), nil
+	case BulkDelete:
+		return mongo.NewDeleteOneModel().SetFilter(filter), nil
+	case BulkMergePatch:
+		update, err := mergePatchUpdate(operation.Data)
+		if err != nil {
+			return nil, err
+		}
+		return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update), nil
+	case BulkInsert:
+		return mongo.NewInsertOneModel().SetDocument(operation.Data), nil
+	case BulkUpdate:
+		return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(setVersioned(operation.Data)), nil
+	default:
+		return nil, fmt.Errorf("unknown bulk operation type: %v", operation.Type)
+	}
+}
+
+// mergePatchUpdate translates a merge patch into an update pipeline. Nested objects are
+// merged member-wise into existing objects, and replace values that are not objects,
+// as RFC 7386 requires. Members set to null are removed by a final $unset stage.
+func mergePatchUpdate(patch map[string]any) (mongo.Pipeline, error) {
+	set, err := mergePatchMembers("", patch)
+	if err != nil {
+		return nil, err
+	}
+	set[VersionField] = bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$" + VersionField, 0}}, 1}}
+	pipeline := mongo.Pipeline{{{Key: "$set", Value: set}}}
+	var unset []string
+	mergePatchRemovals("", patch, &unset)
+	if len(unset) > 0 {
+		slices.Sort(unset)
+		pipeline = append(pipeline, bson.D{{Key: "$unset", Value: unset}})
+	}
+	return pipeline, nil
+}
+
+// mergePatchMembers returns the expressions of the members of the object at path that
+// patch sets, path is empty for the document itself
+func mergePatchMembers(path string, patch map[string]any) (bson.M, error) {
+	members := bson.M{}
+	for key, value := range patch {
+		if key == "" || strings.Contains(key, ".") || strings.HasPrefix(key, "$") {
+			return nil, fmt.Errorf("merge patch member %q cannot be used as a field name", key)
+		}
+		memberPath := key
+		if path != "" {
+			memberPath = path + "." + key
+		}
+		if value == nil {
+			continue
+		}
+		object, ok := mergePatchObject(value)
+		if !ok {
+			members[key] = bson.M{"$literal": value}
+			continue
+		}
+		nested, err := mergePatchMembers(memberPath, object)
+		if err != nil {
+			return nil, err
+		}
+		ref := "$" + memberPath
+		members[key] = bson.M{"$cond": bson.A{
+			bson.M{"$eq": bson.A{bson.M{"$type": ref}, "object"}},
+			bson.M{"$mergeObjects": bson.A{ref, nested}},
+			bson.M{"$literal": applyMergePatch(nil, object)},
+		}}
+	}
+	return members, nil
+}
+
+// mergePatchRemovals appends the paths of the members of patch set to null to unset.
+// Removing a member of a value that is replaced by the patch has no effect.
+func mergePatchRemovals(prefix string, patch map[string]any, unset *[]string) {
+	for key, value := range patch {
+		if value == nil {
+			*unset = append(*unset, prefix+key)
+		} else if object, ok := mergePatchObject(value); ok {
+			mergePatchRemovals(prefix+key+".", object, unset)
+		}
+	}
+}
+
+// applyMergePatch returns the result of applying patch to target as RFC 7386 defines it
+func applyMergePatch(target any, patch map[string]any) map[string]any {
+	current, _ := mergePatchObject(target)
+	result := make(map[string]any, len(current)+len(patch))
+	for key, value := range current {
+		result[key] = value
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(result, key)
+		} else if object, ok := mergePatchObject(value); ok {
+			result[key] = applyMergePatch(result[key], object)
+		} else {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+func mergePatchObject(value any) (map[string]any, bool) {
+	switch v := value.(type) {
+	case map[string]any:
+		return v, true
+	case bson.M:
+		return v, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,238 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func TestMergePatchUpdate(t *testing.T) {
+	update, err := mergePatchUpdate(map[string]any{
+		"ambr":  map[string]any{"uplink": "2 Gbps", "downlink": nil},
+		"stale": nil,
+		"dnn":   "internet",
+	})
+	if err != nil {
+		t.Fatalf("mergePatchUpdate() failed: %v", err)
+	}
+	expected := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"ambr": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{bson.M{"$type": "$ambr"}, "object"}},
+				bson.M{"$mergeObjects": bson.A{"$ambr", bson.M{"uplink": bson.M{"$literal": "2 Gbps"}}}},
+				bson.M{"$literal": map[string]any{"uplink": "2 Gbps"}},
+			}},
+			"dnn":        bson.M{"$literal": "internet"},
+			VersionField: bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$" + VersionField, 0}}, 1}},
+		}}},
+		{{Key: "$unset", Value: []string{"ambr.downlink", "stale"}}},
+	}
+	if !reflect.DeepEqual(update, expected) {
+		t.Errorf("mergePatchUpdate() = %v, expected %v", update, expected)
+	}
+
+	if _, err := mergePatchUpdate(map[string]any{"a.b": 1}); err == nil {
+		t.Error("mergePatchUpdate() should reject member names containing a dot")
+	}
+	if _, err := mergePatchUpdate(map[string]any{"a": map[string]any{"$b": nil}}); err == nil {
+		t.Error("mergePatchUpdate() should reject nested member names starting with $")
+	}
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	testCases := []struct {
+		name     string
+		target   map[string]any
+		patch    map[string]any
+		expected map[string]any
+	}{
+		{
+			name:     "empty object creates the member",
+			target:   map[string]any{"x": 1},
+			patch:    map[string]any{"a": map[string]any{}},
+			expected: map[string]any{"x": 1, "a": map[string]any{}},
+		},
+		{
+			name:     "empty object keeps an object member",
+			target:   map[string]any{"a": map[string]any{"b": 1}},
+			patch:    map[string]any{"a": map[string]any{}},
+			expected: map[string]any{"a": map[string]any{"b": 1}},
+		},
+		{
+			name:     "object replaces a scalar member",
+			target:   map[string]any{"a": "scalar"},
+			patch:    map[string]any{"a": map[string]any{"b": 1, "c": nil}},
+			expected: map[string]any{"a": map[string]any{"b": 1}},
+		},
+		{
+			name:     "null removes a member",
+			target:   map[string]any{"a": map[string]any{"b": 1, "c": 2}},
+			patch:    map[string]any{"a": map[string]any{"c": nil}},
+			expected: map[string]any{"a": map[string]any{"b": 1}},
+		},
+	}
+	for _, tc := range testCases {
+		if result := applyMergePatch(tc.target, tc.patch); !reflect.DeepEqual(result, tc.expected) {
+			t.Errorf("%s: applyMergePatch() = %v, expected %v", tc.name, result, tc.expected)
+		}
+	}
+}
+
+func TestMemoryDBBulkMergePatchReplacesNonObjects(t *testing.T) {
+	db := NewMemoryDB()
+	if _, err := db.RestfulAPIPutOne("subs", bson.M{"ueId": "imsi-1"}, map[string]any{"ambr": "1 Gbps"}); err != nil {
+		t.Fatalf("RestfulAPIPutOne() failed: %v", err)
+	}
+	_, err := db.RestfulAPIBulkWrite(context.Background(), "subs", []BulkOperation{
+		{Type: BulkMergePatch, Filter: bson.M{"ueId": "imsi-1"}, Data: map[string]any{
+			"ambr":  map[string]any{"uplink": "2 Gbps"},
+			"slice": map[string]any{},
+		}},
+	}, true)
+	if err != nil {
+		t.Fatalf("RestfulAPIBulkWrite() failed: %v", err)
+	}
+	doc, _ := db.RestfulAPIGetOne("subs", bson.M{"ueId": "imsi-1"})
+	ambr, _ := doc["ambr"].(map[string]any)
+	slice, ok := doc["slice"].(map[string]any)
+	if ambr["uplink"] != "2 Gbps" || !ok || len(slice) != 0 {
+		t.Errorf("merge patch did not replace the scalar and create the empty object: %v", doc)
+	}
+}
+
+func TestMemoryDBBulkWrite(t *testing.T) {
+	db := NewMemoryDB()
+	if _, err := db.CreateIndex("subs", "msisdn"); err != nil {
+		t.Fatalf("CreateIndex() failed: %v", err)
+	}
+	if _, err := db.RestfulAPIPutOne("subs", bson.M{"ueId": "imsi-1"}, map[string]any{"msisdn": "1", "ambr": bson.M{"uplink": "1 Gbps"}}); err != nil {
+		t.Fatalf("RestfulAPIPutOne() failed: %v", err)
+	}
+
+	operations := []BulkOperation{
+		{Type: BulkUpsert, Filter: bson.M{"ueId": "imsi-2"}, Data: map[string]any{"msisdn": "2"}},
+		{Type: BulkUpsert, Filter: bson.M{"ueId": "imsi-3"}, Data: map[string]any{"msisdn": "1"}},
+		{Type: BulkMergePatch, Filter: bson.M{"ueId": "imsi-1"}, Data: map[string]any{"ambr": map[string]any{"downlink": "2 Gbps"}}},
+	}
+
+	result, err := db.RestfulAPIBulkWrite(context.Background(), "subs", operations, true)
+	if err == nil {
+		t.Fatal("ordered RestfulAPIBulkWrite() should fail on the duplicate msisdn")
+	}
+	if result.UpsertedCount != 1 || !result.Items[0].Upserted || result.Items[0].Err != nil {
+		t.Errorf("unexpected result for the first operation: %+v", result)
+	}
+	if !mongo.IsDuplicateKeyError(result.Items[1].Err) {
+		t.Errorf("expected duplicate key error for the second operation, got %v", result.Items[1].Err)
+	}
+	if !errors.Is(result.Items[2].Err, ErrBulkNotExecuted) {
+		t.Errorf("expected the third operation not to be executed, got %v", result.Items[2].Err)
+	}
+
+	result, err = db.RestfulAPIBulkWrite(context.Background(), "subs", operations, false)
+	if err == nil {
+		t.Fatal("unordered RestfulAPIBulkWrite() should report the duplicate msisdn")
+	}
+	if failed := result.Failed(); len(failed) != 1 || failed[0].Index != 1 {
+		t.Errorf("unordered RestfulAPIBulkWrite() should only fail the second operation: %+v", failed)
+	}
+	doc, _ := db.RestfulAPIGetOne("subs", bson.M{"ueId": "imsi-1"})
+	ambr, _ := doc["ambr"].(map[string]any)
+	if ambr["uplink"] != "1 Gbps" || ambr["downlink"] != "2 Gbps" {
+		t.Errorf("merge patch was not applied member-wise: %v", doc)
+	}
+
+	result, err = db.RestfulAPIBulkWrite(context.Background(), "subs", []BulkOperation{
+		{Type: BulkDelete, Filter: bson.M{"ueId": "imsi-1"}},
+		{Type: BulkDelete, Filter: bson.M{"ueId": "imsi-2"}},
+	}, true)
+	if err != nil || result.DeletedCount != 2 {
+		t.Errorf("RestfulAPIBulkWrite() delete = %+v, %v", result, err)
+	}
+}
+
+func TestMemoryDBPutMany(t *testing.T) {
+	db := NewMemoryDB()
+	filters := []bson.M{{"ueId": "imsi-1"}, {"ueId": "imsi-2"}}
+	data := []map[string]any{{"ueId": "imsi-1", "n": 1}, {"ueId": "imsi-2", "n": 2}}
+	if err := db.RestfulAPIPutMany("subs", filters, data); err != nil {
+		t.Fatalf("RestfulAPIPutMany() failed: %v", err)
+	}
+	data[1]["n"] = 3
+	if err := db.RestfulAPIPutMany("subs", filters, data); err != nil {
+		t.Fatalf("RestfulAPIPutMany() failed: %v", err)
+	}
+	results, _ := db.RestfulAPIGetMany("subs", bson.M{})
+	if len(results) != 2 {
+		t.Fatalf("expected 2 documents, got %d", len(results))
+	}
+	if doc, _ := db.RestfulAPIGetOne("subs", bson.M{"ueId": "imsi-2"}); doc["n"] != int32(3) {
+		t.Errorf("RestfulAPIPutMany() did not update the existing document: %v", doc)
+	}
+	if err := db.RestfulAPIPutMany("subs", []bson.M{{"ueId": "imsi-3"}}, []map[string]any{{"n": 4}}); err != nil {
+		t.Fatalf("RestfulAPIPutMany() failed: %v", err)
+	}
+	if doc, _ := db.RestfulAPIGetOne("subs", bson.M{"n": 4}); doc == nil || doc["ueId"] != nil {
+		t.Errorf("RestfulAPIPutMany() should insert the document without the filter fields: %v", doc)
+	}
+	if err := db.RestfulAPIPutMany("subs", filters[:1], data); err == nil {
+		t.Error("RestfulAPIPutMany() should fail when filters are missing")
+	}
+}
+
+func TestMemoryDBPutManyBulkWrite(t *testing.T) {
+	db := NewMemoryDB()
+	if _, err := db.CreateIndex("subs", "msisdn"); err != nil {
+		t.Fatalf("CreateIndex() failed: %v", err)
+	}
+	if _, err := db.RestfulAPIPost("subs", bson.M{"ueId": "imsi-1"}, map[string]any{"ueId": "imsi-1", "msisdn": "1"}); err != nil {
+		t.Fatalf("RestfulAPIPost() failed: %v", err)
+	}
+	filters := []bson.M{{"ueId": "imsi-1"}, {"ueId": "imsi-2"}, {"ueId": "imsi-3"}}
+	data := []map[string]any{
+		{"ueId": "imsi-1", "n": 1},
+		{"ueId": "imsi-2", "msisdn": "1"},
+		{"ueId": "imsi-3", "msisdn": "3"},
+	}
+	err := db.RestfulAPIPutMany("subs", filters, data)
+	if !mongo.IsDuplicateKeyError(err) || !strings.Contains(err.Error(), "RestfulAPIBulkWrite: 2 of 3 operations failed") {
+		t.Fatalf("RestfulAPIPutMany() should fail as an ordered bulk write, got %v", err)
+	}
+	if doc, _ := db.RestfulAPIGetOne("subs", bson.M{"ueId": "imsi-1"}); doc["n"] != int32(1) {
+		t.Errorf("RestfulAPIPutMany() should apply the writes before the failure: %v", doc)
+	}
+	if doc, _ := db.RestfulAPIGetOne("subs", bson.M{"ueId": "imsi-3"}); doc != nil {
+		t.Errorf("RestfulAPIPutMany() should not apply the writes after the failure: %v", doc)
+	}
+}
+
+func TestPutManyOperations(t *testing.T) {
+	existing := []map[string]any{{"_id": 1, "ueId": "imsi-1"}}
+	filters := []bson.M{{"ueId": "imsi-1"}, {"ueId": "imsi-2"}, {"ueId": "imsi-2"}, {"ueId": "imsi-3"}}
+	data := []map[string]any{{"n": 1}, {"ueId": "imsi-2"}, {"n": 2}, {"n": 3}}
+	operations, err := putManyOperations(filters, data, existing)
+	if err != nil {
+		t.Fatalf("putManyOperations() failed: %v", err)
+	}
+	want := []BulkOperationType{BulkUpdate, BulkInsert, BulkUpdate, BulkInsert}
+	if len(operations) != len(want) {
+		t.Fatalf("putManyOperations() = %v, want types %v", operations, want)
+	}
+	for i, operation := range operations {
+		if operation.Type != want[i] {
+			t.Errorf("operation %d is a %v, want %v", i, operation.Type, want[i])
+		}
+		if operation.Type == BulkInsert && operation.Filter != nil {
+			t.Errorf("operation %d should insert the document without the filter: %+v", i, operation)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/omec-project/util/logger"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// chunkPoolCollection stores the configuration of the chunk pools
+const chunkPoolCollection = "chunkPools"
+
+// ErrChunkNotOwned is returned when renewing or releasing a chunk that is not held by the owner
+var ErrChunkNotOwned = errors.New("chunk not owned")
+
+type chunkPoolConfig struct {
+	Min       int32 `bson:"min"`
+	Max       int32 `bson:"max"`
+	Retries   int32 `bson:"retries"`
+	ChunkSize int32 `bson:"chunkSize"`
+}
+
+// chunkPoolStore persists the configuration and the leases of chunk pools
+type chunkPoolStore interface {
+	// initChunkPool stores the configuration of the pool and prepares the storage of its leases
+	initChunkPool(ctx context.Context, name string, cfg chunkPoolConfig) error
+	loadChunkPoolConfig(ctx context.Context, name string) (chunkPoolConfig, bool, error)
+	// insertChunkLease stores lease if its chunk has no lease, otherwise it returns the
+	// stored lease. It reports whether lease was stored.
+	insertChunkLease(ctx context.Context, pool string, lease ChunkLease) (ChunkLease, bool, error)
+	// replaceChunkLease replaces existing by lease if existing is still stored unchanged,
+	// and reports whether it was replaced
+	replaceChunkLease(ctx context.Context, pool string, existing ChunkLease, lease ChunkLease) (bool, error)
+	// loadActiveChunks returns the chunks whose lease has not expired at now
+	loadActiveChunks(ctx context.Context, pool string, now time.Time) ([]int32, error)
+	// renewChunkLease sets the expiry of the lease of chunk held by owner, a zero expireAt
+	// makes it permanent. It reports whether owner holds the chunk.
+	renewChunkLease(ctx context.Context, pool string, chunk int32, owner string, expireAt time.Time) (bool, error)
+	// deleteChunkLease deletes the lease of chunk held by owner and reports whether there was one
+	deleteChunkLease(ctx context.Context, pool string, chunk int32, owner string) (bool, error)
+	loadExpiredChunkLeases(ctx context.Context, pool string, now time.Time) ([]ChunkLease, error)
+	deleteExpiredChunkLeases(ctx context.Context, pool string, now time.Time) (int64, error)
+}
+
+// ChunkLease is a chunk of a chunk pool assigned to an owner. A chunk with a zero
+// ExpireAt is held until it is released.
+type ChunkLease struct {
+	Chunk    int32     `bson:"_id"`
+	Lower    int32     `bson:"lower"`
+	Upper    int32     `bson:"upper"`
+	Owner    string    `bson:"owner"`
+	LeasedAt time.Time `bson:"leasedAt"`
+	ExpireAt time.Time `bson:"expireAt,omitempty"`
+}
+
+/*
+Initialize pool of ids with maximum and minimum values and chunk size and amount of retries to get a chunk.
+The chunks are stored in the collection poolName, whose TTL index removes the chunks with expired leases.
+*/
+func (c *MongoClient) InitializeChunkPool(poolName string, minimum int32, maximum int32, retries int32, chunkSize int32) {
+	if err := c.InitializeChunkPoolWithError(poolName, minimum, maximum, retries, chunkSize); err != nil {
+		logger.MongoapiLog.Errorln(err)
+	}
+}
+
+// InitializeChunkPoolWithError is InitializeChunkPool returning the cause of a failure
+func (c *MongoClient) InitializeChunkPoolWithError(poolName string, minimum int32, maximum int32, retries int32, chunkSize int32) (err error) {
+	defer c.observe("InitializeChunkPool", poolName, time.Now(), nil, &err)
+	return initializeChunkPool(context.TODO(), c, poolName, chunkPoolConfig{
+		Min: minimum, Max: maximum, Retries: retries, ChunkSize: chunkSize,
+	})
+}
+
+func initializeChunkPool(ctx context.Context, store chunkPoolStore, poolName string, cfg chunkPoolConfig) error {
+	if cfg.ChunkSize <= 0 || cfg.Max-cfg.Min < cfg.ChunkSize {
+		return fmt.Errorf("InitializeChunkPool %s: invalid chunk size %d for range [%d, %d)", poolName, cfg.ChunkSize,
+			cfg.Min, cfg.Max)
+	}
+	if err := store.initChunkPool(ctx, poolName, cfg); err != nil {
+		return fmt.Errorf("InitializeChunkPool %s err: %w", poolName, classifyError(err))
+	}
+	return nil
+}
+
+/*
+Get a chunk for owner, which identifies the calling instance and must be unique among all
+instances sharing the pool. With a positive leaseTime the chunk has to be renewed with
+RenewChunkLease before the lease expires, otherwise it is reclaimed and may be assigned
+to another owner. Random chunks are tried first, and once the retries are used up the
+free chunks are looked up, so ErrPoolExhausted is only returned if every chunk is assigned.
+*/
+func (c *MongoClient) GetChunkFromPool(poolName string, owner string, leaseTime time.Duration) (int32, int32, int32, error) {
+	var err error
+	defer c.observe("GetChunkFromPool", poolName, time.Now(), nil, &err)
+	lease, err := getChunk(context.TODO(), c, poolName, owner, leaseTime, time.Now())
+	if err != nil {
+		return -1, -1, -1, err
+	}
+	return lease.Chunk, lease.Lower, lease.Upper, nil
+}
+
+func getChunk(ctx context.Context, store chunkPoolStore, poolName string, owner string, leaseTime time.Duration,
+	now time.Time,
+) (ChunkLease, error) {
+	pool, found, err := store.loadChunkPoolConfig(ctx, poolName)
+	if err != nil {
+		return ChunkLease{}, fmt.Errorf("GetChunkFromPool err: %w", classifyError(err))
+	}
+	if !found {
+		return ChunkLease{}, errors.New("this pool has not been initialized yet. Initialize by calling InitializeChunkPool")
+	}
+
+	totalChunks := (pool.Max - pool.Min) / pool.ChunkSize
+	for range pool.Retries {
+		chunk := rand.Int31n(totalChunks)
+		lease, assigned, err := assignChunk(ctx, store, poolName, pool, chunk, owner, leaseTime, now)
+		if err != nil {
+			return ChunkLease{}, fmt.Errorf("GetChunkFromPool err: %w", classifyError(err))
+		}
+		if assigned {
+			return lease, nil
+		}
+	}
+
+	for {
+		chunk, err := findFreeChunk(ctx, store, poolName, totalChunks, now)
+		if err != nil {
+			return ChunkLease{}, fmt.Errorf("GetChunkFromPool %s: %w", poolName, classifyError(err))
+		}
+		lease, assigned, err := assignChunk(ctx, store, poolName, pool, chunk, owner, leaseTime, now)
+		if err != nil {
+			return ChunkLease{}, fmt.Errorf("GetChunkFromPool err: %w", classifyError(err))
+		}
+		if assigned {
+			return lease, nil
+		}
+	}
+}
+
+// assignChunk assigns chunk to owner if it is free or its lease has expired
+func assignChunk(ctx context.Context, store chunkPoolStore, poolName string, pool chunkPoolConfig, chunk int32,
+	owner string, leaseTime time.Duration, now time.Time,
+) (ChunkLease, bool, error) {
+	lower := pool.Min + (chunk * pool.ChunkSize)
+	lease := ChunkLease{Chunk: chunk, Lower: lower, Upper: lower + pool.ChunkSize, Owner: owner, LeasedAt: now}
+	if leaseTime > 0 {
+		lease.ExpireAt = now.Add(leaseTime)
+	}
+
+	existing, inserted, err := store.insertChunkLease(ctx, poolName, lease)
+	if err != nil || inserted {
+		return lease, inserted, err
+	}
+	if existing.ExpireAt.IsZero() || existing.ExpireAt.After(now) {
+		return lease, false, nil
+	}
+
+	// the lease expired but the TTL monitor has not removed the chunk yet, take it over
+	// unless another instance renewed or took it over in the meantime
+	replaced, err := store.replaceChunkLease(ctx, poolName, existing, lease)
+	if err != nil || !replaced {
+		return lease, false, err
+	}
+	logger.MongoapiLog.Infof("chunk %d of %s taken over from %s after its lease expired", chunk, poolName, existing.Owner)
+	return lease, true, nil
+}
+
+// findFreeChunk returns a chunk that is not assigned or whose lease has expired, starting
+// the search at a random chunk and wrapping around, or ErrPoolExhausted
+func findFreeChunk(ctx context.Context, store chunkPoolStore, poolName string, totalChunks int32,
+	now time.Time,
+) (int32, error) {
+	active, err := store.loadActiveChunks(ctx, poolName, now)
+	if err != nil {
+		return -1, err
+	}
+	assigned := make(map[int32]bool, len(active))
+	for _, chunk := range active {
+		assigned[chunk] = true
+	}
+	start := rand.Int31n(totalChunks)
+	for i := range totalChunks {
+		if chunk := (start + i) % totalChunks; !assigned[chunk] {
+			return chunk, nil
+		}
+	}
+	return -1, ErrPoolExhausted
+}
+
+/*
+Extend the lease of chunk held by owner to leaseTime from now. It returns ErrChunkNotOwned
+if the chunk was released, reclaimed or taken over by another owner, in which case the
+owner must stop using the chunk.
+*/
+func (c *MongoClient) RenewChunkLease(poolName string, id int32, owner string, leaseTime time.Duration) (err error) {
+	defer c.observe("RenewChunkLease", poolName, time.Now(), nil, &err)
+	return renewChunk(context.TODO(), c, poolName, id, owner, leaseTime, time.Now())
+}
+
+func renewChunk(ctx context.Context, store chunkPoolStore, poolName string, id int32, owner string,
+	leaseTime time.Duration, now time.Time,
+) error {
+	var expireAt time.Time
+	if leaseTime > 0 {
+		expireAt = now.Add(leaseTime)
+	}
+	owned, err := store.renewChunkLease(ctx, poolName, id, owner, expireAt)
+	if err != nil {
+		return fmt.Errorf("RenewChunkLease err: %w", classifyError(err))
+	}
+	if !owned {
+		return fmt.Errorf("RenewChunkLease chunk %d of %s: %w", id, poolName, ErrChunkNotOwned)
+	}
+	return nil
+}
+
+/* Release the provided chunk to the provided pool, if it is held by owner. */
+func (c *MongoClient) ReleaseChunkToPool(poolName string, id int32, owner string) {
+	if err := c.ReleaseChunkToPoolWithError(poolName, id, owner); err != nil {
+		logger.MongoapiLog.Errorln(err)
+	}
+}
+
+// ReleaseChunkToPoolWithError is ReleaseChunkToPool returning the cause of a failure,
+// ErrChunkNotOwned if the chunk is not held by owner
+func (c *MongoClient) ReleaseChunkToPoolWithError(poolName string, id int32, owner string) (err error) {
+	defer c.observe("ReleaseChunkToPool", poolName, time.Now(), nil, &err)
+	return releaseChunk(context.TODO(), c, poolName, id, owner)
+}
+
+func releaseChunk(ctx context.Context, store chunkPoolStore, poolName string, id int32, owner string) error {
+	deleted, err := store.deleteChunkLease(ctx, poolName, id, owner)
+	if err != nil {
+		return fmt.Errorf("ReleaseChunkToPool %s chunk %d err: %w", poolName, id, classifyError(err))
+	}
+	if !deleted {
+		return fmt.Errorf("ReleaseChunkToPool %s chunk %d: %w", poolName, id, ErrChunkNotOwned)
+	}
+	return nil
+}
+
+/*
+List the chunks of the pool whose lease has expired. The TTL index removes them within
+about a minute of their expiry, until then they can be taken over by GetChunkFromPool.
+*/
+func (c *MongoClient) ListExpiredChunks(poolName string) (leases []ChunkLease, err error) {
+	defer c.observe("ListExpiredChunks", poolName, time.Now(), &leases, &err)
+	return listExpiredChunks(context.TODO(), c, poolName, time.Now())
+}
+
+func listExpiredChunks(ctx context.Context, store chunkPoolStore, poolName string, now time.Time) ([]ChunkLease, error) {
+	leases, err := store.loadExpiredChunkLeases(ctx, poolName, now)
+	if err != nil {
+		return nil, fmt.Errorf("ListExpiredChunks err: %w", classifyError(err))
+	}
+	return leases, nil
+}
+
+/* Release the chunks of the pool whose lease has expired and return how many were released. */
+func (c *MongoClient) ReclaimExpiredChunks(poolName string) (released int64, err error) {
+	defer c.observe("ReclaimExpiredChunks", poolName, time.Now(), &released, &err)
+	return reclaimExpiredChunks(context.TODO(), c, poolName, time.Now())
+}
+
+func reclaimExpiredChunks(ctx context.Context, store chunkPoolStore, poolName string, now time.Time) (int64, error) {
+	released, err := store.deleteExpiredChunkLeases(ctx, poolName, now)
+	if err != nil {
+		return 0, fmt.Errorf("ReclaimExpiredChunks err: %w", classifyError(err))
+	}
+	return released, nil
+}
+
+func (c *MongoClient) initChunkPool(ctx context.Context, name string, cfg chunkPoolConfig) error {
+	collection := c.Client.Database(c.dbName).Collection(chunkPoolCollection)
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": name}, bson.M{"$set": cfg}, options.UpdateOne().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expireAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	if _, err := c.Client.Database(c.dbName).Collection(name).Indexes().CreateOne(ctx, index); err != nil {
+		return fmt.Errorf("TTL index err: %w", err)
+	}
+	return nil
+}
+
+func (c *MongoClient) loadChunkPoolConfig(ctx context.Context, name string) (chunkPoolConfig, bool, error) {
+	var cfg chunkPoolConfig
+	err := c.Client.Database(c.dbName).Collection(chunkPoolCollection).FindOne(ctx, bson.M{"_id": name}).Decode(&cfg)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return cfg, false, nil
+	}
+	return cfg, err == nil, err
+}
+
+func (c *MongoClient) insertChunkLease(ctx context.Context, pool string, lease ChunkLease) (ChunkLease, bool, error) {
+	var existing ChunkLease
+	err := c.Client.Database(c.dbName).Collection(pool).FindOneAndUpdate(ctx, bson.M{"_id": lease.Chunk},
+		bson.M{"$setOnInsert": lease}, options.FindOneAndUpdate().SetUpsert(true)).Decode(&existing)
+	// no document before the upsert means that the chunk was free
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return lease, true, nil
+	}
+	return existing, false, err
+}
+
+func (c *MongoClient) replaceChunkLease(ctx context.Context, pool string, existing ChunkLease, lease ChunkLease) (bool, error) {
+	filter := bson.M{"_id": existing.Chunk, "owner": existing.Owner, "expireAt": existing.ExpireAt}
+	result, err := c.Client.Database(c.dbName).Collection(pool).ReplaceOne(ctx, filter, lease)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+func (c *MongoClient) loadActiveChunks(ctx context.Context, pool string, now time.Time) ([]int32, error) {
+	// chunks with an expired lease are not returned, so they count as free
+	filter := bson.M{"$or": bson.A{
+		bson.M{"expireAt": bson.M{"$exists": false}},
+		bson.M{"expireAt": bson.M{"$gte": now}},
+	}}
+	cursor, err := c.Client.Database(c.dbName).Collection(pool).Find(ctx, filter,
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var docs []struct {
+		ID int32 `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	chunks := make([]int32, 0, len(docs))
+	for _, doc := range docs {
+		chunks = append(chunks, doc.ID)
+	}
+	return chunks, nil
+}
+
+func (c *MongoClient) renewChunkLease(ctx context.Context, pool string, chunk int32, owner string, expireAt time.Time) (bool, error) {
+	update := bson.M{"$set": bson.M{"expireAt": expireAt}}
+	if expireAt.IsZero() {
+		update = bson.M{"$unset": bson.M{"expireAt": ""}}
+	}
+	result, err := c.Client.Database(c.dbName).Collection(pool).UpdateOne(ctx, bson.M{"_id": chunk, "owner": owner}, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func (c *MongoClient) deleteChunkLease(ctx context.Context, pool string, chunk int32, owner string) (bool, error) {
+	result, err := c.Client.Database(c.dbName).Collection(pool).DeleteOne(ctx, bson.M{"_id": chunk, "owner": owner})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
+
+func (c *MongoClient) loadExpiredChunkLeases(ctx context.Context, pool string, now time.Time) ([]ChunkLease, error) {
+	cursor, err := c.Client.Database(c.dbName).Collection(pool).Find(ctx, bson.M{"expireAt": bson.M{"$lt": now}})
+	if err != nil {
+		return nil, err
+	}
+	var leases []ChunkLease
+	if err := cursor.All(ctx, &leases); err != nil {
+		return nil, err
+	}
+	return leases, nil
+}
+
+func (c *MongoClient) deleteExpiredChunkLeases(ctx context.Context, pool string, now time.Time) (int64, error) {
+	result, err := c.Client.Database(c.dbName).Collection(pool).DeleteMany(ctx, bson.M{"expireAt": bson.M{"$lt": now}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestChunkLeaseEncoding(t *testing.T) {
+	// the TTL index ignores documents without expireAt, so chunks without a lease time never expire
+	raw, err := bson.Marshal(ChunkLease{Chunk: 3, Lower: 300, Upper: 400, Owner: "smf-0", LeasedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("bson.Marshal() failed: %v", err)
+	}
+	if _, err := bson.Raw(raw).LookupErr("expireAt"); err == nil {
+		t.Error("a chunk without a lease time should not have an expireAt field")
+	}
+
+	expireAt := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+	raw, err = bson.Marshal(ChunkLease{Chunk: 3, Owner: "smf-0", ExpireAt: expireAt})
+	if err != nil {
+		t.Fatalf("bson.Marshal() failed: %v", err)
+	}
+	lease := ChunkLease{}
+	if err := bson.Unmarshal(raw, &lease); err != nil {
+		t.Fatalf("bson.Unmarshal() failed: %v", err)
+	}
+	if !lease.ExpireAt.Equal(expireAt) || lease.Chunk != 3 || lease.Owner != "smf-0" {
+		t.Errorf("decoded lease %+v does not match the encoded one", lease)
+	}
+}
+
+func newTestChunkPool(t *testing.T, db *MemoryDB, chunks int32) {
+	t.Helper()
+	cfg := chunkPoolConfig{Min: 100, Max: 100 + chunks*10, Retries: 2, ChunkSize: 10}
+	if err := initializeChunkPool(context.Background(), db, "chunks", cfg); err != nil {
+		t.Fatalf("initializeChunkPool() failed: %v", err)
+	}
+}
+
+func TestGetChunkUninitializedPool(t *testing.T) {
+	_, err := getChunk(context.Background(), NewMemoryDB(), "chunks", "smf-0", time.Minute, time.Now())
+	if err == nil {
+		t.Error("getChunk() of an uninitialized pool should fail")
+	}
+}
+
+func TestChunkPoolExhaustion(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryDB()
+	newTestChunkPool(t, db, 3)
+	now := time.Now()
+
+	leases := map[int32]ChunkLease{}
+	for i := range 3 {
+		lease, err := getChunk(ctx, db, "chunks", fmt.Sprintf("smf-%d", i), time.Minute, now)
+		if err != nil {
+			t.Fatalf("getChunk() %d failed: %v", i, err)
+		}
+		if _, ok := leases[lease.Chunk]; ok {
+			t.Fatalf("chunk %d assigned twice", lease.Chunk)
+		}
+		leases[lease.Chunk] = lease
+		if lease.Lower != 100+lease.Chunk*10 || lease.Upper != lease.Lower+10 {
+			t.Errorf("chunk %d has range [%d, %d)", lease.Chunk, lease.Lower, lease.Upper)
+		}
+	}
+	if _, err := getChunk(ctx, db, "chunks", "smf-3", time.Minute, now); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("getChunk() of an exhausted pool returned %v, want ErrPoolExhausted", err)
+	}
+
+	if err := releaseChunk(ctx, db, "chunks", 1, "smf-unknown"); !errors.Is(err, ErrChunkNotOwned) {
+		t.Errorf("releaseChunk() by a non-owner returned %v, want ErrChunkNotOwned", err)
+	}
+	if err := releaseChunk(ctx, db, "chunks", 1, leases[1].Owner); err != nil {
+		t.Fatalf("releaseChunk() by the owner failed: %v", err)
+	}
+	lease, err := getChunk(ctx, db, "chunks", "smf-3", time.Minute, now)
+	if err != nil || lease.Chunk != 1 {
+		t.Errorf("getChunk() after releasing chunk 1 = %+v, %v", lease, err)
+	}
+}
+
+func TestChunkLeaseTakeover(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryDB()
+	newTestChunkPool(t, db, 1)
+	now := time.Now()
+
+	lease, err := getChunk(ctx, db, "chunks", "smf-0", time.Minute, now)
+	if err != nil {
+		t.Fatalf("getChunk() failed: %v", err)
+	}
+	if _, err := getChunk(ctx, db, "chunks", "smf-1", time.Minute, now.Add(30*time.Second)); !errors.Is(err, ErrPoolExhausted) {
+		t.Fatalf("getChunk() of a leased chunk returned %v, want ErrPoolExhausted", err)
+	}
+	if err := renewChunk(ctx, db, "chunks", lease.Chunk, "smf-1", time.Minute, now); !errors.Is(err, ErrChunkNotOwned) {
+		t.Errorf("renewChunk() by a non-owner returned %v, want ErrChunkNotOwned", err)
+	}
+	// the renewal keeps the chunk past the first expiry
+	if err := renewChunk(ctx, db, "chunks", lease.Chunk, "smf-0", time.Minute, now.Add(30*time.Second)); err != nil {
+		t.Fatalf("renewChunk() failed: %v", err)
+	}
+	if _, err := getChunk(ctx, db, "chunks", "smf-1", time.Minute, now.Add(80*time.Second)); !errors.Is(err, ErrPoolExhausted) {
+		t.Fatalf("getChunk() of a renewed chunk returned %v, want ErrPoolExhausted", err)
+	}
+
+	expired, err := listExpiredChunks(ctx, db, "chunks", now.Add(2*time.Minute))
+	if err != nil || len(expired) != 1 || expired[0].Owner != "smf-0" {
+		t.Fatalf("listExpiredChunks() = %v, %v, want the lease of smf-0", expired, err)
+	}
+	takeover, err := getChunk(ctx, db, "chunks", "smf-1", time.Minute, now.Add(2*time.Minute))
+	if err != nil {
+		t.Fatalf("getChunk() of an expired chunk failed: %v", err)
+	}
+	if takeover.Chunk != lease.Chunk || takeover.Owner != "smf-1" {
+		t.Errorf("getChunk() returned %+v, want chunk %d taken over by smf-1", takeover, lease.Chunk)
+	}
+	if err := renewChunk(ctx, db, "chunks", lease.Chunk, "smf-0", time.Minute, now.Add(2*time.Minute)); !errors.Is(err, ErrChunkNotOwned) {
+		t.Errorf("renewChunk() by the previous owner returned %v, want ErrChunkNotOwned", err)
+	}
+	if err := releaseChunk(ctx, db, "chunks", lease.Chunk, "smf-0"); !errors.Is(err, ErrChunkNotOwned) {
+		t.Errorf("releaseChunk() by the previous owner returned %v, want ErrChunkNotOwned", err)
+	}
+}
+
+func TestReclaimExpiredChunks(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryDB()
+	newTestChunkPool(t, db, 2)
+	now := time.Now()
+
+	if _, err := getChunk(ctx, db, "chunks", "smf-0", time.Minute, now); err != nil {
+		t.Fatalf("getChunk() failed: %v", err)
+	}
+	// a chunk without a lease time never expires
+	if _, err := getChunk(ctx, db, "chunks", "smf-1", 0, now); err != nil {
+		t.Fatalf("getChunk() failed: %v", err)
+	}
+	released, err := reclaimExpiredChunks(ctx, db, "chunks", now.Add(time.Hour))
+	if err != nil || released != 1 {
+		t.Fatalf("reclaimExpiredChunks() = %d, %v, want 1", released, err)
+	}
+	lease, err := getChunk(ctx, db, "chunks", "smf-2", time.Minute, now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("getChunk() after reclaiming failed: %v", err)
+	}
+	if _, err := getChunk(ctx, db, "chunks", "smf-3", time.Minute, now.Add(time.Hour)); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("getChunk() returned %v, want ErrPoolExhausted while smf-1 and %s hold the chunks", err, lease.Owner)
+	}
+}
@@ -0,0 +1,292 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/omec-project/util/logger"
+	"go.mongodb.org/mongo-driver/v2/event"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+	"go.mongodb.org/mongo-driver/v2/mongo/writeconcern"
+)
+
+// RetryPolicy controls how often a ConnectionManager tries to connect. The delay
+// between attempts starts at InitialBackoff and is multiplied by Multiplier after
+// every failed attempt, up to MaxBackoff. Zero values select the defaults.
+type RetryPolicy struct {
+	// InitialBackoff is the delay after the first failed attempt, 2 seconds by default
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts, 30 seconds by default
+	MaxBackoff time.Duration
+	// Multiplier is the growth factor of the delay, 2 by default
+	Multiplier float64
+	// MaxWait is how long to keep trying before giving up, 180 seconds by default.
+	// A negative value retries until the context passed to Connect is done.
+	MaxWait time.Duration
+}
+
+const (
+	defaultInitialBackoff = 2 * time.Second
+	defaultMaxBackoff     = 30 * time.Second
+	defaultMultiplier     = 2
+	defaultMaxWait        = 180 * time.Second
+	defaultPingTimeout    = 2 * time.Second
+)
+
+// backoff returns the delay after the given number of failed attempts
+func (p RetryPolicy) backoff(failures int) time.Duration {
+	delay := p.InitialBackoff
+	if delay <= 0 {
+		delay = defaultInitialBackoff
+	}
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = defaultMultiplier
+	}
+	for i := 1; i < failures && delay < maxBackoff; i++ {
+		delay = time.Duration(float64(delay) * multiplier)
+	}
+	return min(delay, maxBackoff)
+}
+
+// ConnectionOptions configures the connection of a ConnectionManager. Settings left
+// at their zero value fall back to the values in URL or to the driver defaults.
+type ConnectionOptions struct {
+	URL    string
+	DBName string
+	Retry  RetryPolicy
+	// PingTimeout bounds the ping that verifies each connection attempt, 2 seconds by default
+	PingTimeout    time.Duration
+	TLSConfig      *tls.Config
+	Credential     *options.Credential
+	MaxPoolSize    uint64
+	MinPoolSize    uint64
+	ReadPreference *readpref.ReadPref
+	WriteConcern   *writeconcern.WriteConcern
+	// Instrumentation observes the operations of the client, see MongoClient.AddInstrumentation
+	Instrumentation Instrumentation
+	// MonitorCommands also reports every command sent by the driver to Instrumentation,
+	// see NewCommandMonitor
+	MonitorCommands bool
+}
+
+// HealthState is the health of the deployment as tracked by a ConnectionManager
+type HealthState int32
+
+const (
+	// HealthConnecting means the first connection has not been established yet
+	HealthConnecting HealthState = iota
+	// HealthUp means a server accepting writes is reachable
+	HealthUp
+	// HealthDegraded means only servers accepting reads, such as secondaries, are reachable
+	HealthDegraded
+	// HealthDown means no server is reachable
+	HealthDown
+)
+
+func (h HealthState) String() string {
+	switch h {
+	case HealthConnecting:
+		return "connecting"
+	case HealthUp:
+		return "up"
+	case HealthDegraded:
+		return "degraded"
+	case HealthDown:
+		return "down"
+	default:
+		return fmt.Sprintf("HealthState(%d)", int32(h))
+	}
+}
+
+// ConnectionManager establishes a MongoClient with retries and tracks the health of
+// the deployment afterwards through the heartbeats of the driver. The driver
+// reconnects by itself, so the health state recovers once a server is reachable again.
+type ConnectionManager struct {
+	opts   ConnectionOptions
+	client atomic.Pointer[MongoClient]
+	health atomic.Int32
+	// attempt identifies the connection attempt whose events update the health state
+	attempt   atomic.Int64
+	ready     chan struct{}
+	readyOnce sync.Once
+	// connectMutex serializes Connect, so that concurrent calls share one client
+	connectMutex sync.Mutex
+}
+
+// NewConnectionManager creates a ConnectionManager, call Connect to establish the connection
+func NewConnectionManager(opts ConnectionOptions) *ConnectionManager {
+	return &ConnectionManager{opts: opts, ready: make(chan struct{})}
+}
+
+// Connect connects to the deployment, retrying according to the RetryPolicy until it
+// succeeds, ctx is done or MaxWait has elapsed. It returns the connected client, or
+// an error describing the last failed attempt. Concurrent calls wait for the running
+// one and return its client.
+func (m *ConnectionManager) Connect(ctx context.Context) (*MongoClient, error) {
+	m.connectMutex.Lock()
+	defer m.connectMutex.Unlock()
+	if client := m.client.Load(); client != nil {
+		return client, nil
+	}
+	maxWait := m.opts.Retry.MaxWait
+	if maxWait == 0 {
+		maxWait = defaultMaxWait
+	}
+	if maxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, maxWait)
+		defer cancel()
+	}
+
+	for failures := 1; ; failures++ {
+		attempt := m.attempt.Add(1)
+		client, err := newMongoClient(m.clientOptions(attempt), m.opts.URL, m.opts.DBName, m.pingTimeout())
+		if err == nil {
+			if m.opts.Instrumentation != nil {
+				client.AddInstrumentation(m.opts.Instrumentation)
+			}
+			m.client.Store(client)
+			m.health.Store(int32(HealthUp))
+			m.readyOnce.Do(func() { close(m.ready) })
+			logger.MongoapiLog.Infof("connected to mongodb database %s", m.opts.DBName)
+			return client, nil
+		}
+
+		delay := m.opts.Retry.backoff(failures)
+		logger.MongoapiLog.Warnf("mongodb connection attempt %d failed, retrying in %v: %v", failures, delay, err)
+		select {
+		case <-ctx.Done():
+			m.health.Store(int32(HealthDown))
+			logger.MongoapiLog.Errorf("giving up connecting to mongodb: %v", err)
+			return nil, fmt.Errorf("mongodb not reachable: %w", err)
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Client returns the connected client, or nil before Connect succeeded
+func (m *ConnectionManager) Client() *MongoClient {
+	return m.client.Load()
+}
+
+// Ready returns a channel that is closed once Connect succeeded
+func (m *ConnectionManager) Ready() <-chan struct{} {
+	return m.ready
+}
+
+// Health returns the current health state of the connection
+func (m *ConnectionManager) Health() HealthState {
+	return HealthState(m.health.Load())
+}
+
+// ReadinessHandler returns an HTTP handler for readiness probes that responds with
+// 200 while the health state is HealthUp and with 503 otherwise
+func (m *ConnectionManager) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		health := m.Health()
+		if health != HealthUp {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_, _ = fmt.Fprintf(w, "mongodb %s\n", health)
+	})
+}
+
+// Close disconnects the client
+func (m *ConnectionManager) Close(ctx context.Context) error {
+	client := m.client.Swap(nil)
+	m.attempt.Add(1)
+	m.health.Store(int32(HealthDown))
+	if client == nil {
+		return nil
+	}
+	return client.Client.Disconnect(ctx)
+}
+
+func (m *ConnectionManager) pingTimeout() time.Duration {
+	if m.opts.PingTimeout > 0 {
+		return m.opts.PingTimeout
+	}
+	return defaultPingTimeout
+}
+
+func (m *ConnectionManager) clientOptions(attempt int64) *options.ClientOptions {
+	opts := options.Client().
+		ApplyURI(m.opts.URL).
+		SetBSONOptions(&options.BSONOptions{
+			DefaultDocumentMap: true,
+		}).
+		SetServerMonitor(m.serverMonitor(attempt))
+	if m.opts.TLSConfig != nil {
+		opts.SetTLSConfig(m.opts.TLSConfig)
+	}
+	if m.opts.Credential != nil {
+		opts.SetAuth(*m.opts.Credential)
+	}
+	if m.opts.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(m.opts.MaxPoolSize)
+	}
+	if m.opts.MinPoolSize > 0 {
+		opts.SetMinPoolSize(m.opts.MinPoolSize)
+	}
+	if m.opts.ReadPreference != nil {
+		opts.SetReadPreference(m.opts.ReadPreference)
+	}
+	if m.opts.WriteConcern != nil {
+		opts.SetWriteConcern(m.opts.WriteConcern)
+	}
+	if m.opts.Instrumentation != nil && m.opts.MonitorCommands {
+		opts.SetMonitor(NewCommandMonitor(m.opts.Instrumentation))
+	}
+	return opts
+}
+
+// serverMonitor updates the health state from the topology changes that the driver
+// derives from server heartbeats. Events of clients from earlier attempts are ignored.
+func (m *ConnectionManager) serverMonitor(attempt int64) *event.ServerMonitor {
+	return &event.ServerMonitor{
+		TopologyDescriptionChanged: func(e *event.TopologyDescriptionChangedEvent) {
+			if m.attempt.Load() != attempt || m.client.Load() == nil {
+				return
+			}
+			health := healthFromTopology(e.NewDescription)
+			if previous := HealthState(m.health.Swap(int32(health))); previous != health {
+				logger.MongoapiLog.Infof("mongodb health changed from %s to %s", previous, health)
+			}
+		},
+		ServerHeartbeatFailed: func(e *event.ServerHeartbeatFailedEvent) {
+			if m.attempt.Load() != attempt || m.client.Load() == nil {
+				return
+			}
+			logger.MongoapiLog.Warnf("mongodb heartbeat to %s failed: %v", e.ConnectionID, e.Failure)
+		},
+	}
+}
+
+// healthFromTopology derives the health state from the kinds of the known servers
+func healthFromTopology(topology event.TopologyDescription) HealthState {
+	health := HealthDown
+	for _, server := range topology.Servers {
+		switch server.Kind {
+		case "Standalone", "RSPrimary", "Mongos", "LoadBalancer":
+			return HealthUp
+		case "RSSecondary":
+			health = HealthDegraded
+		}
+	}
+	return health
+}
@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/event"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Multiplier: 2}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, delay := range expected {
+		if backoff := policy.backoff(i + 1); backoff != delay {
+			t.Errorf("backoff(%d) = %v, expected %v", i+1, backoff, delay)
+		}
+	}
+
+	if backoff := (RetryPolicy{}).backoff(1); backoff != defaultInitialBackoff {
+		t.Errorf("default backoff(1) = %v, expected %v", backoff, defaultInitialBackoff)
+	}
+}
+
+func TestHealthFromTopology(t *testing.T) {
+	testCases := []struct {
+		kinds    []string
+		expected HealthState
+	}{
+		{nil, HealthDown},
+		{[]string{"Unknown"}, HealthDown},
+		{[]string{"Standalone"}, HealthUp},
+		{[]string{"RSSecondary", "Unknown"}, HealthDegraded},
+		{[]string{"RSSecondary", "RSPrimary"}, HealthUp},
+	}
+	for _, tc := range testCases {
+		var topology event.TopologyDescription
+		for _, kind := range tc.kinds {
+			topology.Servers = append(topology.Servers, event.ServerDescription{Kind: kind})
+		}
+		if health := healthFromTopology(topology); health != tc.expected {
+			t.Errorf("healthFromTopology(%v) = %s, expected %s", tc.kinds, health, tc.expected)
+		}
+	}
+}
+
+func TestConnectionManagerUnreachable(t *testing.T) {
+	manager := NewConnectionManager(ConnectionOptions{
+		URL:         "mongodb://127.0.0.1:1/?connectTimeoutMS=100&serverSelectionTimeoutMS=100",
+		DBName:      "test",
+		PingTimeout: 100 * time.Millisecond,
+		Retry:       RetryPolicy{InitialBackoff: 50 * time.Millisecond, MaxWait: 300 * time.Millisecond},
+	})
+	if health := manager.Health(); health != HealthConnecting {
+		t.Errorf("Health() before Connect() = %s, expected %s", health, HealthConnecting)
+	}
+
+	client, err := manager.Connect(context.Background())
+	if err == nil || client != nil {
+		t.Fatalf("Connect() = %v, %v, expected an error", client, err)
+	}
+	if health := manager.Health(); health != HealthDown {
+		t.Errorf("Health() after failed Connect() = %s, expected %s", health, HealthDown)
+	}
+	select {
+	case <-manager.Ready():
+		t.Error("Ready() should not be closed after failed Connect()")
+	default:
+	}
+
+	recorder := httptest.NewRecorder()
+	manager.ReadinessHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("ReadinessHandler() status = %d, expected %d", recorder.Code, http.StatusServiceUnavailable)
+	}
+}
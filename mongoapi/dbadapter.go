@@ -6,7 +6,7 @@ package mongoapi
 
 import (
 	"context"
-	"time"
+	"fmt"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
@@ -37,34 +37,72 @@ type DBInterface interface {
 	SupportsTransactions() (bool, error)
 }
 
+// ErrorReporter is implemented by the clients with variants of DBInterface methods
+// that return the cause of a failure instead of a bool or a sentinel value. Like the
+// other optional interfaces below, it is kept apart from DBInterface so that existing
+// implementations of DBInterface remain valid.
+type ErrorReporter interface {
+	RestfulAPIPutOneTimeoutWithError(collName string, filter bson.M, putData map[string]any, timeout int32, timeField string) error
+	GetUniqueIdentityWithError(idName string) (int32, error)
+}
+
+// BulkWriter is implemented by the clients that execute bulk writes
+type BulkWriter interface {
+	RestfulAPIBulkWrite(ctx context.Context, collName string, operations []BulkOperation, ordered bool) (*BulkWriteResult, error)
+}
+
+// Finder is implemented by the clients that query documents with sorting, projection
+// and pagination
+type Finder interface {
+	Find(ctx context.Context, collName string, filter bson.M, opts *QueryOptions) (*DocumentIterator, error)
+	FindPage(ctx context.Context, collName string, filter bson.M, opts *QueryOptions) (*Page, error)
+	FindEach(ctx context.Context, collName string, filter bson.M, opts *QueryOptions, fn func(doc map[string]any) error) (string, error)
+}
+
+// Transactor is implemented by the clients that run functions in transactions
+type Transactor interface {
+	WithTransaction(ctx context.Context, fn func(txCtx context.Context) error) error
+}
+
+// IDPoolProvider is implemented by the clients that store ID pools
+type IDPoolProvider interface {
+	NewIDPool(ctx context.Context, name string, minimum, maximum int64) (*IDPool, error)
+	OpenIDPool(ctx context.Context, name string) (*IDPool, error)
+}
+
+var (
+	_ ErrorReporter  = (*MongoClient)(nil)
+	_ ErrorReporter  = (*MemoryDB)(nil)
+	_ BulkWriter     = (*MongoClient)(nil)
+	_ BulkWriter     = (*MemoryDB)(nil)
+	_ Transactor     = (*MongoClient)(nil)
+	_ Transactor     = (*MemoryDB)(nil)
+	_ IDPoolProvider = (*MongoClient)(nil)
+	_ IDPoolProvider = (*MemoryDB)(nil)
+	_ Finder         = (*MongoClient)(nil)
+	_ Finder         = (*MemoryDB)(nil)
+)
+
 var CommonDBClient DBInterface
 
-// Set CommonDBClient
-func setCommonDBClient(url string, dbname string) error {
-	mClient, errConnect := NewMongoClient(url, dbname)
-	if mClient != nil && mClient.Client != nil {
-		CommonDBClient = mClient
-		CommonDBClient.(*MongoClient).Client.Database(dbname)
-	}
-	return errConnect
+// ConnectMongo connects CommonDBClient to the database dbname, retrying for up to
+// 180 seconds. It returns an error, and leaves CommonDBClient unchanged, if MongoDB
+// could not be reached.
+func ConnectMongo(url string, dbname string) error {
+	_, err := ConnectCommonDBClient(context.Background(), ConnectionOptions{URL: url, DBName: dbname})
+	return err
 }
 
-func ConnectMongo(url string, dbname string) {
-	// Connect to MongoDB
-	ticker := time.NewTicker(2 * time.Second)
-	defer func() { ticker.Stop() }()
-	timer := time.After(180 * time.Second)
-ConnectMongo:
-	for {
-		commonDbErr := setCommonDBClient(url, dbname)
-		if commonDbErr == nil {
-			break ConnectMongo
-		}
-		select {
-		case <-ticker.C:
-			continue
-		case <-timer:
-			return
-		}
+// ConnectCommonDBClient connects CommonDBClient with a ConnectionManager configured by
+// opts, and registers it in Clients as DefaultClientName. The returned manager reports
+// the health of the connection, for example to Kubernetes probes.
+func ConnectCommonDBClient(ctx context.Context, opts ConnectionOptions) (*ConnectionManager, error) {
+	manager := NewConnectionManager(opts)
+	client, err := manager.Connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ConnectMongo err: %w", err)
 	}
+	CommonDBClient = client
+	Clients.Register(DefaultClientName, client)
+	return manager, nil
 }
@@ -0,0 +1,420 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	// encryptedSubtype is the user defined binary subtype of encrypted field values
+	encryptedSubtype byte = 0x80
+	// encryptedFormat is the version of the layout of encrypted field values:
+	// format, key ID length, key ID, nonce and the sealed BSON encoded value
+	encryptedFormat byte = 1
+)
+
+var (
+	// ErrUnknownKey is returned when a value was encrypted with a key the provider does not know
+	ErrUnknownKey = errors.New("unknown encryption key")
+	// ErrDecryption is returned when an encrypted value is malformed or fails authentication
+	ErrDecryption = errors.New("decryption failed")
+)
+
+// KeyProvider supplies the AES keys of field encryption. Keys are 16, 24 or 32 bytes
+// long and identified by an ID that is stored with every encrypted value, so keys can
+// be rotated by making a new key current while keeping the old keys available.
+type KeyProvider interface {
+	// CurrentKey returns the ID and the key used to encrypt new values
+	CurrentKey() (string, []byte, error)
+	// Key returns the key with the given ID, or ErrUnknownKey
+	Key(id string) ([]byte, error)
+}
+
+// LocalKeyProvider is a KeyProvider holding its keys in memory
+type LocalKeyProvider struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewLocalKeyProvider creates a LocalKeyProvider with keys by ID that encrypts with
+// the key current
+func NewLocalKeyProvider(current string, keys map[string][]byte) (*LocalKeyProvider, error) {
+	for id, key := range keys {
+		if id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("invalid encryption key ID %q", id)
+		}
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, fmt.Errorf("encryption key %s err: %w", id, err)
+		}
+	}
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("current encryption key %s err: %w", current, ErrUnknownKey)
+	}
+	return &LocalKeyProvider{current: current, keys: keys}, nil
+}
+
+// NewEnvKeyProvider creates a LocalKeyProvider from environment variables. Every
+// variable <prefix>KEY_<ID> holds a base64 encoded key, and <prefix>CURRENT_KEY holds
+// the ID of the current key.
+func NewEnvKeyProvider(prefix string) (*LocalKeyProvider, error) {
+	keys := make(map[string][]byte)
+	for _, variable := range os.Environ() {
+		name, value, _ := strings.Cut(variable, "=")
+		id, ok := strings.CutPrefix(name, prefix+"KEY_")
+		if !ok {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("NewEnvKeyProvider %s err: %w", name, err)
+		}
+		keys[id] = key
+	}
+	return NewLocalKeyProvider(os.Getenv(prefix+"CURRENT_KEY"), keys)
+}
+
+// NewFileKeyProvider creates a LocalKeyProvider from a JSON file of the form
+// {"current": "<ID>", "keys": {"<ID>": "<base64 encoded key>"}}
+func NewFileKeyProvider(path string) (*LocalKeyProvider, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("NewFileKeyProvider err: %w", err)
+	}
+	var file struct {
+		Current string            `json:"current"`
+		Keys    map[string][]byte `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("NewFileKeyProvider %s err: %w", path, err)
+	}
+	return NewLocalKeyProvider(file.Current, file.Keys)
+}
+
+func (p *LocalKeyProvider) CurrentKey() (string, []byte, error) {
+	return p.current, p.keys[p.current], nil
+}
+
+func (p *LocalKeyProvider) Key(id string) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("key %s: %w", id, ErrUnknownKey)
+	}
+	return key, nil
+}
+
+// FieldEncryption configures the fields that are encrypted at rest. Values of the
+// configured fields are encrypted with AES-GCM when documents are written and
+// decrypted when they are read, so they cannot be used in filters or indexes.
+type FieldEncryption struct {
+	Provider KeyProvider
+	// Fields maps collection names to the dotted paths of their encrypted fields,
+	// paths do not descend into arrays
+	Fields map[string][]string
+}
+
+// SetFieldEncryption enables field encryption, nil disables it. Values that were
+// encrypted remain encrypted in the database until they are written again.
+func (c *MongoClient) SetFieldEncryption(encryption *FieldEncryption) {
+	c.fieldEncryption = encryption
+}
+
+// ReencryptFields encrypts the encrypted fields of all documents of collName with the
+// current key, after the current key of the provider was rotated. Documents written
+// concurrently are skipped, as they are encrypted with the current key anyway. It
+// returns the number of updated documents.
+func (c *MongoClient) ReencryptFields(ctx context.Context, collName string) (updated int64, err error) {
+	defer c.observe("ReencryptFields", collName, time.Now(), &updated, &err)
+	fields := c.fieldCipher(collName)
+	if fields == nil {
+		return 0, nil
+	}
+	currentID, _, err := fields.provider.CurrentKey()
+	if err != nil {
+		return 0, fmt.Errorf("ReencryptFields err: %w", err)
+	}
+	collection := c.Client.Database(c.dbName).Collection(collName)
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return 0, fmt.Errorf("ReencryptFields err: %w", classifyError(err))
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc map[string]any
+		if err := cursor.Decode(&doc); err != nil {
+			return updated, fmt.Errorf("ReencryptFields err: %w", classifyError(err))
+		}
+		// the stored ciphertexts are part of the filter, so concurrent writes are not overwritten
+		filter := bson.M{"_id": doc["_id"]}
+		set := bson.M{}
+		for _, path := range fields.paths {
+			value, found := lookupPath(doc, path)
+			if !found {
+				continue
+			}
+			keyID, encrypted := encryptedKeyID(value)
+			if !encrypted || keyID == currentID {
+				continue
+			}
+			plain, err := fields.decryptValue(path, value)
+			if err != nil {
+				return updated, fmt.Errorf("ReencryptFields %v err: %w", doc["_id"], err)
+			}
+			if set[path], err = fields.encryptValue(path, plain); err != nil {
+				return updated, fmt.Errorf("ReencryptFields %v err: %w", doc["_id"], err)
+			}
+			filter[path] = value
+		}
+		if len(set) == 0 {
+			continue
+		}
+		result, err := collection.UpdateOne(ctx, filter, setVersioned(set))
+		if err != nil {
+			return updated, fmt.Errorf("ReencryptFields err: %w", classifyError(err))
+		}
+		updated += result.ModifiedCount
+	}
+	if err := cursor.Err(); err != nil {
+		return updated, fmt.Errorf("ReencryptFields err: %w", classifyError(err))
+	}
+	return updated, nil
+}
+
+// fieldCipher encrypts and decrypts the configured fields of the documents of a collection
+type fieldCipher struct {
+	provider   KeyProvider
+	collection string
+	paths      []string
+}
+
+// fieldCipher returns the cipher of collName, or nil if none of its fields are encrypted
+func (c *MongoClient) fieldCipher(collName string) *fieldCipher {
+	encryption := c.fieldEncryption
+	if encryption == nil || len(encryption.Fields[collName]) == 0 {
+		return nil
+	}
+	return &fieldCipher{provider: encryption.Provider, collection: collName, paths: encryption.Fields[collName]}
+}
+
+// encrypt returns doc with the configured fields encrypted, doc itself is not modified.
+// The document is the value of the field prefix if prefix is not empty.
+func (f *fieldCipher) encrypt(doc map[string]any, prefix string) (map[string]any, error) {
+	if f == nil {
+		return doc, nil
+	}
+	return f.transform(doc, prefix, f.encryptValue)
+}
+
+// encryptAny is encrypt for data of any type, which must be a document if there are
+// fields to encrypt, as the encrypted fields of other types cannot be located
+func (f *fieldCipher) encryptAny(data any) (any, error) {
+	if f == nil {
+		return data, nil
+	}
+	switch doc := data.(type) {
+	case map[string]any:
+		return f.encrypt(doc, "")
+	case bson.M:
+		return f.encrypt(doc, "")
+	default:
+		return nil, fmt.Errorf("%T cannot be stored in %s, which has encrypted fields", data, f.collection)
+	}
+}
+
+// decrypt returns doc with the configured fields decrypted, doc itself is not modified
+func (f *fieldCipher) decrypt(doc map[string]any) (map[string]any, error) {
+	if f == nil || doc == nil {
+		return doc, nil
+	}
+	return f.transform(doc, "", f.decryptValue)
+}
+
+// decryptEvent decrypts the full document and the updated fields of a change event.
+// Updated fields are keyed by dotted paths, which may be encrypted fields themselves or
+// documents containing encrypted fields.
+func (f *fieldCipher) decryptEvent(event *ChangeEvent) error {
+	if f == nil {
+		return nil
+	}
+	var err error
+	if event.FullDocument, err = f.decrypt(event.FullDocument); err != nil {
+		return err
+	}
+	for path, value := range event.UpdatedFields {
+		if doc, ok := value.(map[string]any); ok {
+			if event.UpdatedFields[path], err = f.transform(doc, path, f.decryptValue); err != nil {
+				return err
+			}
+			continue
+		}
+		if slices.Contains(f.paths, path) {
+			if event.UpdatedFields[path], err = f.decryptValue(path, value); err != nil {
+				return fmt.Errorf("field %s err: %w", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (f *fieldCipher) transform(doc map[string]any, prefix string, fn func(path string, value any) (any, error)) (map[string]any, error) {
+	for _, path := range f.paths {
+		relative := path
+		if prefix != "" {
+			var ok bool
+			if relative, ok = strings.CutPrefix(path, prefix+"."); !ok {
+				continue
+			}
+		}
+		transformed, err := transformPath(doc, strings.Split(relative, "."), func(value any) (any, error) {
+			return fn(path, value)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("field %s err: %w", path, err)
+		}
+		doc = transformed
+	}
+	return doc, nil
+}
+
+// transformPath returns a copy of doc in which the value at the path parts is replaced
+// by the result of fn. Only the documents along the path are copied, and doc is
+// returned unchanged if the path does not exist.
+func transformPath(doc map[string]any, parts []string, fn func(value any) (any, error)) (map[string]any, error) {
+	value, ok := doc[parts[0]]
+	if !ok || value == nil {
+		return doc, nil
+	}
+	if len(parts) > 1 {
+		var child map[string]any
+		switch v := value.(type) {
+		case map[string]any:
+			child = v
+		case bson.M:
+			child = v
+		default:
+			return doc, nil
+		}
+		transformed, err := transformPath(child, parts[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		value = transformed
+	} else {
+		var err error
+		if value, err = fn(value); err != nil {
+			return nil, err
+		}
+	}
+	result := make(map[string]any, len(doc))
+	for key, v := range doc {
+		result[key] = v
+	}
+	result[parts[0]] = value
+	return result, nil
+}
+
+// encryptValue seals the BSON encoding of value with the current key. The path is
+// authenticated, so a ciphertext cannot be moved to another field or collection.
+func (f *fieldCipher) encryptValue(path string, value any) (any, error) {
+	if _, encrypted := encryptedKeyID(value); encrypted {
+		return value, nil
+	}
+	keyID, key, err := f.provider.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := bson.Marshal(bson.D{{Key: "v", Value: value}})
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, 2+len(keyID)+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	data = append(data, encryptedFormat, byte(len(keyID)))
+	data = append(data, keyID...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	data = append(data, nonce...)
+	data = aead.Seal(data, nonce, plaintext, f.additionalData(path))
+	return bson.Binary{Subtype: encryptedSubtype, Data: data}, nil
+}
+
+// decryptValue opens a value sealed by encryptValue, other values are returned as they
+// are, so that fields written before encryption was enabled remain readable
+func (f *fieldCipher) decryptValue(path string, value any) (any, error) {
+	keyID, encrypted := encryptedKeyID(value)
+	if !encrypted {
+		return value, nil
+	}
+	key, err := f.provider.Key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	data := value.(bson.Binary).Data[2+len(keyID):]
+	if len(data) < aead.NonceSize() {
+		return nil, ErrDecryption
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], f.additionalData(path))
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	decoder := bson.NewDecoder(bson.NewDocumentReader(bytes.NewReader(plaintext)))
+	decoder.DefaultDocumentMap()
+	var decoded struct {
+		V any `bson:"v"`
+	}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecryption, err)
+	}
+	return decoded.V, nil
+}
+
+func (f *fieldCipher) additionalData(path string) []byte {
+	return []byte(f.collection + "/" + path)
+}
+
+// encryptedKeyID returns the key ID of a value sealed by encryptValue
+func encryptedKeyID(value any) (string, bool) {
+	binary, ok := value.(bson.Binary)
+	if !ok || binary.Subtype != encryptedSubtype || len(binary.Data) < 2 || binary.Data[0] != encryptedFormat {
+		return "", false
+	}
+	length := int(binary.Data[1])
+	if len(binary.Data) < 2+length {
+		return "", false
+	}
+	return string(binary.Data[2 : 2+length]), true
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
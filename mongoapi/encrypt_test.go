@@ -0,0 +1,214 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func testKeyProvider(t *testing.T, current string) *LocalKeyProvider {
+	t.Helper()
+	provider, err := NewLocalKeyProvider(current, map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 16),
+	})
+	if err != nil {
+		t.Fatalf("NewLocalKeyProvider() failed: %v", err)
+	}
+	return provider
+}
+
+func TestFieldEncryptionRoundTrip(t *testing.T) {
+	c := &MongoClient{}
+	c.SetFieldEncryption(&FieldEncryption{
+		Provider: testKeyProvider(t, "k1"),
+		Fields:   map[string][]string{"subscriptionData.authenticationData.authenticationSubscription": {"permanentKey.permanentKeyValue", "opc", "sqn"}},
+	})
+	fields := c.fieldCipher("subscriptionData.authenticationData.authenticationSubscription")
+	if c.fieldCipher("subscriptionData.provisionedData.amData") != nil {
+		t.Fatal("collection without encrypted fields should have no cipher")
+	}
+
+	doc := map[string]any{
+		"ueId":         "imsi-001010000000001",
+		"permanentKey": map[string]any{"permanentKeyValue": "8baf473f2f8fd09487cccbd7097c6862", "encryptionKey": int32(0)},
+		"opc":          map[string]any{"opcValue": "8e27b6af0e692e750f32667a3b14605d"},
+	}
+	encrypted, err := fields.encrypt(doc, "")
+	if err != nil {
+		t.Fatalf("encrypt() failed: %v", err)
+	}
+	if _, ok := doc["opc"].(map[string]any); !ok {
+		t.Fatal("encrypt() modified its input")
+	}
+	if doc["permanentKey"].(map[string]any)["permanentKeyValue"] != "8baf473f2f8fd09487cccbd7097c6862" {
+		t.Fatal("encrypt() modified a nested document of its input")
+	}
+	for _, path := range []string{"permanentKey.permanentKeyValue", "opc"} {
+		value, _ := lookupPath(encrypted, path)
+		if keyID, ok := encryptedKeyID(value); !ok || keyID != "k1" {
+			t.Errorf("%s is not encrypted with k1: %v", path, value)
+		}
+	}
+	if encrypted["ueId"] != "imsi-001010000000001" {
+		t.Errorf("unexpected ueId %v", encrypted["ueId"])
+	}
+	if _, found := encrypted["sqn"]; found {
+		t.Error("missing field should not be added")
+	}
+
+	decrypted, err := fields.decrypt(encrypted)
+	if err != nil {
+		t.Fatalf("decrypt() failed: %v", err)
+	}
+	if !reflect.DeepEqual(decrypted, doc) {
+		t.Errorf("decrypt() = %v, expected %v", decrypted, doc)
+	}
+}
+
+func TestFieldEncryptionAnyData(t *testing.T) {
+	c := &MongoClient{}
+	c.SetFieldEncryption(&FieldEncryption{
+		Provider: testKeyProvider(t, "k1"),
+		Fields:   map[string][]string{"subs": {"opc"}},
+	})
+	type subscriber struct {
+		Opc string `bson:"opc"`
+	}
+
+	for _, data := range []any{map[string]any{"opc": "secret"}, bson.M{"opc": "secret"}} {
+		encrypted, err := c.fieldCipher("subs").encryptAny(data)
+		if err != nil {
+			t.Fatalf("encryptAny(%T) failed: %v", data, err)
+		}
+		doc, ok := encrypted.(map[string]any)
+		if !ok {
+			t.Fatalf("encryptAny(%T) returned %T", data, encrypted)
+		}
+		if _, ok := encryptedKeyID(doc["opc"]); !ok {
+			t.Errorf("encryptAny(%T) did not encrypt opc: %v", data, doc["opc"])
+		}
+	}
+	if _, err := c.fieldCipher("subs").encryptAny(subscriber{Opc: "secret"}); err == nil {
+		t.Error("encryptAny() of a struct should fail for a collection with encrypted fields")
+	}
+	plain := subscriber{Opc: "secret"}
+	if data, err := c.fieldCipher("other").encryptAny(plain); err != nil || data != plain {
+		t.Errorf("encryptAny() without encrypted fields = %v, %v, expected the data unchanged", data, err)
+	}
+}
+
+func TestFieldEncryptionKeyRotation(t *testing.T) {
+	paths := []string{"sqn"}
+	old := &fieldCipher{provider: testKeyProvider(t, "k1"), collection: "auth", paths: paths}
+	encrypted, err := old.encrypt(map[string]any{"sqn": "000000000020"}, "")
+	if err != nil {
+		t.Fatalf("encrypt() failed: %v", err)
+	}
+
+	rotated := &fieldCipher{provider: testKeyProvider(t, "k2"), collection: "auth", paths: paths}
+	decrypted, err := rotated.decrypt(encrypted)
+	if err != nil || decrypted["sqn"] != "000000000020" {
+		t.Fatalf("decrypt() after rotation = %v, %v", decrypted, err)
+	}
+	reencrypted, err := rotated.encrypt(decrypted, "")
+	if err != nil {
+		t.Fatalf("encrypt() failed: %v", err)
+	}
+	if keyID, _ := encryptedKeyID(reencrypted["sqn"]); keyID != "k2" {
+		t.Errorf("expected new values to be encrypted with k2, got %s", keyID)
+	}
+
+	provider, err := NewLocalKeyProvider("k2", map[string][]byte{"k2": bytes.Repeat([]byte{2}, 16)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	retired := &fieldCipher{provider: provider, collection: "auth", paths: paths}
+	if _, err := retired.decrypt(encrypted); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected ErrUnknownKey, got %v", err)
+	}
+}
+
+func TestFieldEncryptionAuthenticatesLocation(t *testing.T) {
+	fields := &fieldCipher{provider: testKeyProvider(t, "k1"), collection: "auth", paths: []string{"k", "opc"}}
+	encrypted, err := fields.encrypt(map[string]any{"k": "secret"}, "")
+	if err != nil {
+		t.Fatalf("encrypt() failed: %v", err)
+	}
+	if _, err := fields.decrypt(map[string]any{"opc": encrypted["k"]}); !errors.Is(err, ErrDecryption) {
+		t.Errorf("expected ErrDecryption for a moved ciphertext, got %v", err)
+	}
+	other := &fieldCipher{provider: fields.provider, collection: "other", paths: []string{"k"}}
+	if _, err := other.decrypt(encrypted); !errors.Is(err, ErrDecryption) {
+		t.Errorf("expected ErrDecryption for another collection, got %v", err)
+	}
+
+	tampered := encrypted["k"].(bson.Binary)
+	tampered.Data = bytes.Clone(tampered.Data)
+	tampered.Data[len(tampered.Data)-1] ^= 1
+	if _, err := fields.decrypt(map[string]any{"k": tampered}); !errors.Is(err, ErrDecryption) {
+		t.Errorf("expected ErrDecryption for a tampered ciphertext, got %v", err)
+	}
+}
+
+func TestFieldEncryptionPrefix(t *testing.T) {
+	fields := &fieldCipher{provider: testKeyProvider(t, "k1"), collection: "auth", paths: []string{"data.k", "other"}}
+	encrypted, err := fields.encrypt(map[string]any{"k": "secret", "other": "plain"}, "data")
+	if err != nil {
+		t.Fatalf("encrypt() failed: %v", err)
+	}
+	if _, ok := encryptedKeyID(encrypted["k"]); !ok {
+		t.Error("data.k should be encrypted relative to data")
+	}
+	if encrypted["other"] != "plain" {
+		t.Error("fields outside of data should not be encrypted")
+	}
+}
+
+func TestKeyProviders(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	encoded := base64.StdEncoding.EncodeToString(key)
+
+	t.Setenv("TEST_ENC_KEY_2026a", encoded)
+	t.Setenv("TEST_ENC_CURRENT_KEY", "2026a")
+	provider, err := NewEnvKeyProvider("TEST_ENC_")
+	if err != nil {
+		t.Fatalf("NewEnvKeyProvider() failed: %v", err)
+	}
+	if id, current, _ := provider.CurrentKey(); id != "2026a" || !bytes.Equal(current, key) {
+		t.Errorf("unexpected current key %s", id)
+	}
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	content := `{"current": "b", "keys": {"a": "` + encoded + `", "b": "` + encoded + `"}}`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	provider, err = NewFileKeyProvider(path)
+	if err != nil {
+		t.Fatalf("NewFileKeyProvider() failed: %v", err)
+	}
+	if _, err := provider.Key("a"); err != nil {
+		t.Errorf("Key(a) failed: %v", err)
+	}
+	if _, err := provider.Key("c"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected ErrUnknownKey, got %v", err)
+	}
+
+	if _, err := NewLocalKeyProvider("a", map[string][]byte{"a": []byte("short")}); err == nil {
+		t.Error("NewLocalKeyProvider() should reject invalid key sizes")
+	}
+	if _, err := NewLocalKeyProvider("b", map[string][]byte{"a": key}); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected ErrUnknownKey for a missing current key, got %v", err)
+	}
+}
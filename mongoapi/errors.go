@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// The errors returned by this package match one of these sentinel errors with
+// errors.Is when the cause of the failure is known
+var (
+	// ErrNotFound is returned when a document that has to exist does not
+	ErrNotFound = errors.New("document not found")
+	// ErrDuplicateKey is returned when a write violates a unique index
+	ErrDuplicateKey = errors.New("duplicate key")
+	// ErrConflict is returned when a document kept changing concurrently while a
+	// patch was applied and the patch could not be committed
+	ErrConflict = errors.New("document was modified concurrently")
+	// ErrTimeout is returned when an operation exceeded its deadline
+	ErrTimeout = errors.New("operation timed out")
+	// ErrPoolExhausted is returned when every ID or chunk of a pool is allocated
+	ErrPoolExhausted = errors.New("pool exhausted")
+)
+
+// Error is a driver error classified as one of the sentinel errors. errors.Is matches
+// both Kind and the errors wrapped by Err, and errors.As still finds the driver
+// errors, so checks such as mongo.IsDuplicateKeyError keep working.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// classifyError returns err as an *Error if it matches the kind of a sentinel error,
+// otherwise err itself
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var classified *Error
+	if errors.As(err, &classified) {
+		return err
+	}
+	var kind error
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		kind = ErrNotFound
+	case mongo.IsDuplicateKeyError(err):
+		kind = ErrDuplicateKey
+	case mongo.IsTimeout(err) || errors.Is(err, context.DeadlineExceeded):
+		kind = ErrTimeout
+	default:
+		return err
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// HTTPStatus maps an error returned by this package to the HTTP status code of the
+// ProblemDetails reporting it
+func HTTPStatus(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrDuplicateKey), errors.Is(err, ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, ErrTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, ErrPoolExhausted):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
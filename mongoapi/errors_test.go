@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func TestClassifyError(t *testing.T) {
+	duplicate := mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: duplicateKeyErrorCode, Message: "E11000"}}}
+	testCases := []struct {
+		err    error
+		kind   error
+		status int
+	}{
+		{mongo.ErrNoDocuments, ErrNotFound, http.StatusNotFound},
+		{duplicate, ErrDuplicateKey, http.StatusConflict},
+		{context.DeadlineExceeded, ErrTimeout, http.StatusGatewayTimeout},
+		{fmt.Errorf("UpdateOne err: %w", ErrConflict), ErrConflict, http.StatusConflict},
+		{fmt.Errorf("IDPool Allocate: %w", ErrPoolExhausted), ErrPoolExhausted, http.StatusServiceUnavailable},
+	}
+	for _, tc := range testCases {
+		err := fmt.Errorf("Op err: %w", classifyError(tc.err))
+		if !errors.Is(err, tc.kind) {
+			t.Errorf("classifyError(%v) does not match %v", tc.err, tc.kind)
+		}
+		if status := HTTPStatus(err); status != tc.status {
+			t.Errorf("HTTPStatus(%v) = %d, expected %d", err, status, tc.status)
+		}
+		if err.Error() != "Op err: "+tc.err.Error() {
+			t.Errorf("classifyError(%v) changed the message to %q", tc.err, err.Error())
+		}
+	}
+
+	if err := classifyError(duplicate); !mongo.IsDuplicateKeyError(err) {
+		t.Error("classifyError() should keep the driver error reachable")
+	}
+	if err := classifyError(mongo.ErrNoDocuments); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Error("classifyError() should keep matching the driver sentinel error")
+	}
+
+	other := errors.New("connection refused")
+	if classifyError(other) != other || HTTPStatus(other) != http.StatusInternalServerError {
+		t.Error("errors of unknown kind should be returned unchanged")
+	}
+	if classifyError(nil) != nil || HTTPStatus(nil) != http.StatusOK {
+		t.Error("nil should not be classified as an error")
+	}
+}
+
+func TestMemoryDBDuplicateKeyError(t *testing.T) {
+	db := NewMemoryDB()
+	if _, err := db.CreateIndex("subs", "msisdn"); err != nil {
+		t.Fatalf("CreateIndex() failed: %v", err)
+	}
+	if _, err := db.RestfulAPIPost("subs", bson.M{"ueId": "imsi-1"}, map[string]any{"ueId": "imsi-1", "msisdn": "1"}); err != nil {
+		t.Fatalf("RestfulAPIPost() failed: %v", err)
+	}
+	_, err := db.RestfulAPIPost("subs", bson.M{"ueId": "imsi-2"}, map[string]any{"ueId": "imsi-2", "msisdn": "1"})
+	if !errors.Is(err, ErrDuplicateKey) || !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("RestfulAPIPost() of a duplicate msisdn = %v, expected ErrDuplicateKey", err)
+	}
+	err = db.RestfulAPIPutOneTimeoutWithError("subs", bson.M{"ueId": "imsi-3"}, map[string]any{"msisdn": "1"}, 0, "")
+	if !errors.Is(err, ErrDuplicateKey) {
+		t.Errorf("RestfulAPIPutOneTimeoutWithError() of a duplicate msisdn = %v, expected ErrDuplicateKey", err)
+	}
+}
@@ -0,0 +1,356 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// ErrInvalidPageToken is returned when a page token is malformed or was issued for
+// a query with a different sort order
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// SortField is a field of the sort order of a query
+type SortField struct {
+	Field      string
+	Descending bool
+}
+
+// QueryOptions configures Find, the zero value returns all matching documents in
+// _id order
+type QueryOptions struct {
+	// Limit is the maximum number of documents of a page, 0 means no limit
+	Limit int64
+	// Sort orders the documents. The _id is always appended as the last sort field, so
+	// that the order is total and pages neither skip nor repeat documents.
+	Sort []SortField
+	// Projection lists the fields to return, dotted paths select nested fields. All
+	// fields are returned if it is empty.
+	Projection []string
+	// PageToken continues the query after the last document of the previous page, as
+	// returned by DocumentIterator.NextPageToken or Page.NextPageToken
+	PageToken string
+}
+
+// Page is a page of the documents matching a query
+type Page struct {
+	Documents []map[string]any
+	// NextPageToken continues the query on the next page, it is empty once all
+	// matching documents have been returned
+	NextPageToken string
+}
+
+// DocumentIterator streams the documents of a query without loading them all into
+// memory. It must be closed after use.
+type DocumentIterator struct {
+	next  func(ctx context.Context) (map[string]any, error)
+	close func(ctx context.Context) error
+	opts  QueryOptions
+	// count is the number of documents returned so far
+	count int64
+	doc   map[string]any
+	// last is the unprojected last document, from which the page token is derived
+	last map[string]any
+	err  error
+	done bool
+}
+
+// Next advances to the next document and reports whether there is one
+func (it *DocumentIterator) Next(ctx context.Context) bool {
+	if it.done {
+		return false
+	}
+	doc, err := it.next(ctx)
+	if err != nil || doc == nil {
+		it.err = err
+		it.done = true
+		it.doc = nil
+		return false
+	}
+	it.count++
+	it.last = doc
+	it.doc = projectDocument(doc, it.opts.Projection)
+	return true
+}
+
+// Document returns the current document
+func (it *DocumentIterator) Document() map[string]any {
+	return it.doc
+}
+
+// Err returns the error that ended the iteration, if any
+func (it *DocumentIterator) Err() error {
+	return it.err
+}
+
+// Close releases the resources of the iterator
+func (it *DocumentIterator) Close(ctx context.Context) error {
+	it.done = true
+	if it.close == nil {
+		return nil
+	}
+	return it.close(ctx)
+}
+
+// NextPageToken returns the token for the page after the documents returned so far.
+// It is empty if the query has no limit, or if the page ended before the limit was
+// reached and therefore no more documents match.
+func (it *DocumentIterator) NextPageToken() string {
+	if it.opts.Limit <= 0 || it.count < it.opts.Limit || it.last == nil {
+		return ""
+	}
+	token, err := encodePageToken(it.opts.Sort, it.last)
+	if err != nil {
+		return ""
+	}
+	return token
+}
+
+// collectPage reads all documents of it into a Page
+func collectPage(ctx context.Context, it *DocumentIterator) (*Page, error) {
+	defer it.Close(context.Background())
+	page := &Page{}
+	for it.Next(ctx) {
+		page.Documents = append(page.Documents, it.Document())
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	page.NextPageToken = it.NextPageToken()
+	return page, nil
+}
+
+// forEachDocument calls fn for each document of it and stops at the first error of fn
+func forEachDocument(ctx context.Context, it *DocumentIterator, fn func(doc map[string]any) error) (string, error) {
+	defer it.Close(context.Background())
+	for it.Next(ctx) {
+		if err := fn(it.Document()); err != nil {
+			return "", err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return "", err
+	}
+	return it.NextPageToken(), nil
+}
+
+// Find returns an iterator over the documents of collName matching filter
+func (c *MongoClient) Find(ctx context.Context, collName string, filter bson.M, opts *QueryOptions) (it *DocumentIterator, err error) {
+	defer c.observe("Find", collName, time.Now(), nil, &err)
+	if opts == nil {
+		opts = &QueryOptions{}
+	}
+	query, err := pageFilter(filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("Find err: %w", classifyError(err))
+	}
+
+	findOpts := options.Find().SetSort(sortDocument(opts.Sort))
+	if opts.Limit > 0 {
+		findOpts.SetLimit(opts.Limit)
+	}
+	if len(opts.Projection) > 0 {
+		// the sort fields are needed for the page token, they are removed again by projectDocument
+		projection := bson.M{}
+		for _, field := range opts.Projection {
+			projection[field] = 1
+		}
+		for _, field := range opts.Sort {
+			projection[field.Field] = 1
+		}
+		findOpts.SetProjection(projection)
+	}
+
+	collection := c.Client.Database(c.dbName).Collection(collName)
+	cursor, err := collection.Find(ctx, query, findOpts)
+	if err != nil {
+		return nil, fmt.Errorf("Find err: %w", classifyError(err))
+	}
+	fields := c.fieldCipher(collName)
+	return &DocumentIterator{
+		next: func(ctx context.Context) (map[string]any, error) {
+			if !cursor.Next(ctx) {
+				return nil, cursor.Err()
+			}
+			var doc map[string]any
+			if err := cursor.Decode(&doc); err != nil {
+				return nil, err
+			}
+			return fields.decrypt(doc)
+		},
+		close: cursor.Close,
+		opts:  *opts,
+	}, nil
+}
+
+// FindPage returns a page of the documents of collName matching filter
+func (c *MongoClient) FindPage(ctx context.Context, collName string, filter bson.M, opts *QueryOptions) (page *Page, err error) {
+	defer c.observe("FindPage", collName, time.Now(), &page, &err)
+	it, err := c.Find(ctx, collName, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	page, err = collectPage(ctx, it)
+	if err != nil {
+		return nil, fmt.Errorf("FindPage err: %w", classifyError(err))
+	}
+	return page, nil
+}
+
+// FindEach calls fn for each document of collName matching filter, and returns the
+// token of the next page. Iteration stops at the first error returned by fn.
+func (c *MongoClient) FindEach(ctx context.Context, collName string, filter bson.M, opts *QueryOptions,
+	fn func(doc map[string]any) error,
+) (token string, err error) {
+	var count int64
+	defer c.observe("FindEach", collName, time.Now(), &count, &err)
+	it, err := c.Find(ctx, collName, filter, opts)
+	if err != nil {
+		return "", err
+	}
+	token, err = forEachDocument(ctx, it, func(doc map[string]any) error {
+		count++
+		return fn(doc)
+	})
+	if err != nil {
+		return "", fmt.Errorf("FindEach err: %w", classifyError(err))
+	}
+	return token, nil
+}
+
+// sortKeys returns sort completed with _id
+func sortKeys(sort []SortField) []SortField {
+	return append(slices.Clone(sort), SortField{Field: "_id"})
+}
+
+func sortDocument(sort []SortField) bson.D {
+	doc := bson.D{}
+	for _, field := range sortKeys(sort) {
+		direction := 1
+		if field.Descending {
+			direction = -1
+		}
+		doc = append(doc, bson.E{Key: field.Field, Value: direction})
+	}
+	return doc
+}
+
+// pageToken is the content of an encoded page token
+type pageToken struct {
+	// Fields guards against passing the token to a query with another sort order
+	Fields []string `bson:"f"`
+	Values bson.A   `bson:"v"`
+}
+
+func encodePageToken(sort []SortField, last map[string]any) (string, error) {
+	token := pageToken{}
+	for _, field := range sortKeys(sort) {
+		value, _ := lookupPath(last, field.Field)
+		token.Fields = append(token.Fields, field.Field)
+		token.Values = append(token.Values, value)
+	}
+	raw, err := bson.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodePageToken(sort []SortField, encoded string) (bson.A, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	token := pageToken{}
+	if err := bson.Unmarshal(raw, &token); err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	keys := sortKeys(sort)
+	if len(token.Fields) != len(keys) || len(token.Values) != len(keys) {
+		return nil, ErrInvalidPageToken
+	}
+	for i, field := range keys {
+		if token.Fields[i] != field.Field {
+			return nil, ErrInvalidPageToken
+		}
+	}
+	return token.Values, nil
+}
+
+// pageFilter extends filter to the documents after the position of the page token of
+// opts. A document is after the position if it equals the position in the first i sort
+// fields and is after it in the next sort field, for any i. Null and missing values sort
+// before all other values, and $gt and $lt never match them, so they are handled apart.
+func pageFilter(filter bson.M, opts *QueryOptions) (bson.M, error) {
+	if opts.PageToken == "" {
+		return filter, nil
+	}
+	values, err := decodePageToken(opts.Sort, opts.PageToken)
+	if err != nil {
+		return nil, err
+	}
+	keys := sortKeys(opts.Sort)
+	branches := bson.A{}
+	for i, field := range keys {
+		// an equality with null also matches the documents missing the field
+		branch := bson.M{}
+		for j := range i {
+			branch[keys[j].Field] = bson.M{"$eq": values[j]}
+		}
+		switch {
+		case values[i] == nil && field.Descending:
+			// nothing sorts after null in descending order
+			continue
+		case values[i] == nil:
+			branch[field.Field] = bson.M{"$ne": nil}
+		case field.Descending:
+			branch["$or"] = bson.A{
+				bson.M{field.Field: bson.M{"$lt": values[i]}},
+				bson.M{field.Field: nil},
+			}
+		default:
+			branch[field.Field] = bson.M{"$gt": values[i]}
+		}
+		branches = append(branches, branch)
+	}
+	after := bson.M{"$or": branches}
+	if len(filter) == 0 {
+		return after, nil
+	}
+	return bson.M{"$and": bson.A{filter, after}}, nil
+}
+
+// projectDocument returns the fields of doc listed in projection, or all fields if
+// projection is empty, without the internal _id and version fields
+func projectDocument(doc map[string]any, projection []string) map[string]any {
+	if len(projection) == 0 {
+		result := make(map[string]any, len(doc))
+		for key, value := range doc {
+			result[key] = value
+		}
+		delete(result, "_id")
+		delete(result, VersionField)
+		return result
+	}
+	result := make(map[string]any, len(projection))
+	for _, field := range projection {
+		if field == "_id" || field == VersionField {
+			continue
+		}
+		if value, found := lookupPath(doc, field); found {
+			// paths of existing values can always be set in the fresh result
+			_ = setPath(result, field, value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,183 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestProjectDocument(t *testing.T) {
+	doc := map[string]any{
+		"_id":        bson.NewObjectID(),
+		VersionField: int64(2),
+		"ueId":       "imsi-1",
+		"ambr":       map[string]any{"uplink": "1 Gbps", "downlink": "2 Gbps"},
+	}
+	projected := projectDocument(doc, []string{"ueId", "ambr.uplink", "missing", "_id"})
+	expected := map[string]any{"ueId": "imsi-1", "ambr": map[string]any{"uplink": "1 Gbps"}}
+	if !reflect.DeepEqual(projected, expected) {
+		t.Errorf("projectDocument() = %v, expected %v", projected, expected)
+	}
+	if all := projectDocument(doc, nil); len(all) != 2 {
+		t.Errorf("projectDocument() without projection = %v, expected all fields but _id and %s", all, VersionField)
+	}
+}
+
+func TestPageToken(t *testing.T) {
+	sort := []SortField{{Field: "plmn"}, {Field: "n", Descending: true}}
+	token, err := encodePageToken(sort, map[string]any{"_id": "x", "plmn": "00101", "n": int32(7)})
+	if err != nil {
+		t.Fatalf("encodePageToken() failed: %v", err)
+	}
+	values, err := decodePageToken(sort, token)
+	if err != nil {
+		t.Fatalf("decodePageToken() failed: %v", err)
+	}
+	if !reflect.DeepEqual(values, bson.A{"00101", int32(7), "x"}) {
+		t.Errorf("decodePageToken() = %v", values)
+	}
+	if _, err := decodePageToken(sort[:1], token); !errors.Is(err, ErrInvalidPageToken) {
+		t.Errorf("decodePageToken() with another sort order should fail with ErrInvalidPageToken, got %v", err)
+	}
+	if _, err := decodePageToken(sort, "not a token"); !errors.Is(err, ErrInvalidPageToken) {
+		t.Errorf("decodePageToken() of garbage should fail with ErrInvalidPageToken, got %v", err)
+	}
+}
+
+func TestMemoryDBFindPagination(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryDB()
+	for i := range 10 {
+		// the group has duplicates, so the pages are only stable thanks to the _id tie breaker
+		data := map[string]any{"ueId": fmt.Sprintf("imsi-%d", i), "group": int32(i % 3), "n": int32(i)}
+		if _, err := db.RestfulAPIPost("subs", bson.M{"ueId": data["ueId"]}, data); err != nil {
+			t.Fatalf("RestfulAPIPost() failed: %v", err)
+		}
+	}
+
+	opts := &QueryOptions{
+		Limit:      4,
+		Sort:       []SortField{{Field: "group", Descending: true}, {Field: "n"}},
+		Projection: []string{"n"},
+	}
+	var seen []int32
+	pages := 0
+	for {
+		page, err := db.FindPage(ctx, "subs", bson.M{"n": bson.M{"$gte": 1}}, opts)
+		if err != nil {
+			t.Fatalf("FindPage() failed: %v", err)
+		}
+		pages++
+		for _, doc := range page.Documents {
+			if len(doc) != 1 {
+				t.Errorf("projection should only return n: %v", doc)
+			}
+			seen = append(seen, doc["n"].(int32))
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		opts.PageToken = page.NextPageToken
+	}
+	expected := []int32{2, 5, 8, 1, 4, 7, 3, 6, 9}
+	if !reflect.DeepEqual(seen, expected) {
+		t.Errorf("paginated documents = %v, expected %v", seen, expected)
+	}
+	if pages != 3 {
+		t.Errorf("expected 3 pages, got %d", pages)
+	}
+}
+
+func TestMemoryDBFindPaginationMissingSortField(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryDB()
+	for i := range 6 {
+		data := map[string]any{"n": int32(i)}
+		switch i {
+		case 1, 4:
+			// no group, these sort before all groups
+		case 3:
+			data["group"] = nil
+		default:
+			data["group"] = int32(i % 2)
+		}
+		if _, err := db.RestfulAPIPost("subs", bson.M{"n": i}, data); err != nil {
+			t.Fatalf("RestfulAPIPost() failed: %v", err)
+		}
+	}
+
+	for _, descending := range []bool{false, true} {
+		opts := &QueryOptions{Limit: 2, Sort: []SortField{{Field: "group", Descending: descending}, {Field: "n"}}}
+		var seen []int32
+		for {
+			page, err := db.FindPage(ctx, "subs", bson.M{}, opts)
+			if err != nil {
+				t.Fatalf("FindPage() failed: %v", err)
+			}
+			for _, doc := range page.Documents {
+				seen = append(seen, doc["n"].(int32))
+			}
+			if page.NextPageToken == "" {
+				break
+			}
+			opts.PageToken = page.NextPageToken
+		}
+		expected := []int32{1, 3, 4, 0, 2, 5}
+		if descending {
+			expected = []int32{5, 0, 2, 1, 3, 4}
+		}
+		if !reflect.DeepEqual(seen, expected) {
+			t.Errorf("paginated documents with descending %v = %v, expected %v", descending, seen, expected)
+		}
+	}
+}
+
+func TestMemoryDBFindEach(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryDB()
+	for i := range 5 {
+		if _, err := db.RestfulAPIPost("subs", bson.M{"n": i}, map[string]any{"n": int32(i)}); err != nil {
+			t.Fatalf("RestfulAPIPost() failed: %v", err)
+		}
+	}
+
+	count := 0
+	token, err := db.FindEach(ctx, "subs", bson.M{}, &QueryOptions{Limit: 2, Sort: []SortField{{Field: "n"}}},
+		func(doc map[string]any) error {
+			count++
+			return nil
+		})
+	if err != nil || count != 2 || token == "" {
+		t.Errorf("FindEach() = %q, %v after %d documents, expected a token after 2 documents", token, err, count)
+	}
+
+	stop := errors.New("stop")
+	_, err = db.FindEach(ctx, "subs", bson.M{}, nil, func(doc map[string]any) error {
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("FindEach() should return the error of the callback, got %v", err)
+	}
+
+	it, err := db.Find(ctx, "subs", bson.M{"n": bson.M{"$lt": 3}}, nil)
+	if err != nil {
+		t.Fatalf("Find() failed: %v", err)
+	}
+	defer it.Close(ctx)
+	count = 0
+	for it.Next(ctx) {
+		count++
+	}
+	if it.Err() != nil || count != 3 || it.NextPageToken() != "" {
+		t.Errorf("iterated %d documents with error %v and token %q, expected 3 documents without token",
+			count, it.Err(), it.NextPageToken())
+	}
+}
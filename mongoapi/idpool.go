@@ -0,0 +1,461 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/bits"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	// idPoolCollection stores one configuration document per pool
+	idPoolCollection = "idPools"
+	// idPoolBlockCollection stores the allocation bitmaps of the pools
+	idPoolBlockCollection = "idPoolBlocks"
+	// idBlockWords is the number of 64 bit words of the bitmap of a block
+	idBlockWords = 1024
+	// idBlockSize is the number of IDs tracked by one block document
+	idBlockSize = idBlockWords * 64
+)
+
+var (
+	// ErrPoolNotFound is returned when opening a pool that was never created
+	ErrPoolNotFound = errors.New("pool not found")
+	// ErrIDNotAllocated is returned when releasing an ID that is not allocated
+	ErrIDNotAllocated = errors.New("id not allocated")
+	// ErrLegacyIDPool is returned by InitializePool for a pool that still holds the
+	// state of the legacy pool helpers, which ImportLegacyIDPoolMigration imports
+	ErrLegacyIDPool = errors.New("pool holds legacy state that was not imported")
+)
+
+// IDPoolConfig is the configuration of an ID pool as stored in the database.
+// The pool hands out the IDs in [Min, Max).
+type IDPoolConfig struct {
+	Name string `bson:"_id"`
+	Min  int64  `bson:"min"`
+	Max  int64  `bson:"max"`
+}
+
+func (cfg IDPoolConfig) size() int64 {
+	return cfg.Max - cfg.Min
+}
+
+func (cfg IDPoolConfig) blocks() int64 {
+	return (cfg.size() + idBlockSize - 1) / idBlockSize
+}
+
+// idPoolStore persists the configuration and the allocation bitmaps of ID pools.
+// Block bitmaps that were never written are all zeros.
+type idPoolStore interface {
+	// createIDPoolConfig stores cfg unless the pool exists and returns the stored configuration
+	createIDPoolConfig(ctx context.Context, cfg IDPoolConfig) (IDPoolConfig, error)
+	loadIDPoolConfig(ctx context.Context, name string) (IDPoolConfig, bool, error)
+	// loadIDPoolCursor returns the offset at which the next allocation starts searching
+	loadIDPoolCursor(ctx context.Context, name string) (int64, error)
+	storeIDPoolCursor(ctx context.Context, name string, cursor int64) error
+	// loadIDBlock returns the bitmap of a block and the number of its free IDs
+	loadIDBlock(ctx context.Context, cfg IDPoolConfig, block int64) ([]uint64, int64, error)
+	// updateIDBit sets or clears a bit of a block bitmap if it has the opposite value
+	// and reports whether it was changed
+	updateIDBit(ctx context.Context, cfg IDPoolConfig, block int64, word int, bit int, set bool) (bool, error)
+	deleteIDPool(ctx context.Context, name string) error
+	// observe reports an operation on the pool like MongoClient.observe
+	observe(operation, collName string, start time.Time, result any, err *error)
+}
+
+// IDPool allocates unique IDs from a range shared by all processes using the same
+// database. Allocation is tracked in bitmap documents of idBlockSize IDs each, and
+// every allocation or release is a single conditional update of one bit, so
+// concurrent allocators never hand out the same ID. Allocations start after the
+// most recently allocated ID and wrap around at the end of the range, which delays
+// the reuse of released IDs.
+type IDPool struct {
+	store  idPoolStore
+	config IDPoolConfig
+}
+
+func newIDPool(ctx context.Context, store idPoolStore, name string, minimum, maximum int64) (*IDPool, error) {
+	if minimum >= maximum {
+		return nil, fmt.Errorf("invalid range [%d, %d) for pool %s", minimum, maximum, name)
+	}
+	cfg, err := store.createIDPoolConfig(ctx, IDPoolConfig{Name: name, Min: minimum, Max: maximum})
+	if err != nil {
+		return nil, fmt.Errorf("NewIDPool err: %w", classifyError(err))
+	}
+	if cfg.Min != minimum || cfg.Max != maximum {
+		return nil, fmt.Errorf("NewIDPool err: pool %s exists with range [%d, %d)", name, cfg.Min, cfg.Max)
+	}
+	return &IDPool{store: store, config: cfg}, nil
+}
+
+func openIDPool(ctx context.Context, store idPoolStore, name string) (*IDPool, error) {
+	cfg, found, err := store.loadIDPoolConfig(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("OpenIDPool err: %w", classifyError(err))
+	}
+	if !found {
+		return nil, fmt.Errorf("OpenIDPool %s: %w", name, ErrPoolNotFound)
+	}
+	return &IDPool{store: store, config: cfg}, nil
+}
+
+// Config returns the configuration of the pool
+func (p *IDPool) Config() IDPoolConfig {
+	return p.config
+}
+
+// Allocate returns a free ID of the pool and marks it allocated. It returns
+// ErrPoolExhausted if no ID is free.
+func (p *IDPool) Allocate(ctx context.Context) (id int64, err error) {
+	defer p.store.observe("IDPoolAllocate", idPoolBlockCollection, time.Now(), nil, &err)
+	cursor, err := p.store.loadIDPoolCursor(ctx, p.config.Name)
+	if err != nil {
+		return -1, fmt.Errorf("IDPool Allocate err: %w", classifyError(err))
+	}
+	size := p.config.size()
+	if cursor < 0 || cursor >= size {
+		cursor = 0
+	}
+
+	startBlock := cursor / idBlockSize
+	blocks := p.config.blocks()
+	// the start block is visited twice when the search wraps around, first from
+	// the cursor to its end and finally from its beginning to the cursor
+	for i := int64(0); i <= blocks; i++ {
+		block := (startBlock + i) % blocks
+		from := int64(0)
+		if i == 0 {
+			from = cursor % idBlockSize
+		}
+		offset, err := p.allocateInBlock(ctx, block, from)
+		if err != nil {
+			return -1, fmt.Errorf("IDPool Allocate err: %w", classifyError(err))
+		}
+		if offset >= 0 {
+			// the cursor only guides the search, losing a concurrent update is harmless
+			_ = p.store.storeIDPoolCursor(ctx, p.config.Name, (offset+1)%size)
+			return p.config.Min + offset, nil
+		}
+	}
+	return -1, fmt.Errorf("IDPool Allocate %s: %w", p.config.Name, ErrPoolExhausted)
+}
+
+// allocateInBlock allocates the first free ID of block at or after the offset from
+// within the block. It returns the offset of the ID in the pool, or -1 if there is
+// no such free ID.
+func (p *IDPool) allocateInBlock(ctx context.Context, block int64, from int64) (int64, error) {
+	for {
+		words, free, err := p.store.loadIDBlock(ctx, p.config, block)
+		if err != nil {
+			return -1, err
+		}
+		if free == 0 {
+			return -1, nil
+		}
+		index := firstClearBit(words, from)
+		offset := block*idBlockSize + index
+		if index < 0 || offset >= p.config.size() {
+			return -1, nil
+		}
+		changed, err := p.store.updateIDBit(ctx, p.config, block, int(index/64), int(index%64), true)
+		if err != nil {
+			return -1, err
+		}
+		if changed {
+			return offset, nil
+		}
+		// another allocator took this ID, search again from the same position
+		from = index
+	}
+}
+
+// Release marks id as free again. It returns ErrIDNotAllocated if id is not allocated.
+func (p *IDPool) Release(ctx context.Context, id int64) (err error) {
+	defer p.store.observe("IDPoolRelease", idPoolBlockCollection, time.Now(), nil, &err)
+	if id < p.config.Min || id >= p.config.Max {
+		return fmt.Errorf("IDPool Release err: id %d out of range [%d, %d)", id, p.config.Min, p.config.Max)
+	}
+	offset := id - p.config.Min
+	block := offset / idBlockSize
+	index := offset % idBlockSize
+	changed, err := p.store.updateIDBit(ctx, p.config, block, int(index/64), int(index%64), false)
+	if err != nil {
+		return fmt.Errorf("IDPool Release err: %w", classifyError(err))
+	}
+	if !changed {
+		return fmt.Errorf("IDPool Release id %d: %w", id, ErrIDNotAllocated)
+	}
+	return nil
+}
+
+// Delete removes the configuration and allocation state of the pool
+func (p *IDPool) Delete(ctx context.Context) error {
+	if err := p.store.deleteIDPool(ctx, p.config.Name); err != nil {
+		return fmt.Errorf("IDPool Delete err: %w", classifyError(err))
+	}
+	return nil
+}
+
+// firstClearBit returns the index of the first clear bit at or after from, or -1
+func firstClearBit(words []uint64, from int64) int64 {
+	for w := int(from / 64); w < len(words); w++ {
+		word := words[w]
+		if w == int(from/64) {
+			// treat the bits before from as set
+			word |= (uint64(1) << (from % 64)) - 1
+		}
+		if word != ^uint64(0) {
+			return int64(w)*64 + int64(bits.TrailingZeros64(^word))
+		}
+	}
+	return -1
+}
+
+// ImportLegacyIDPoolMigration returns a migration step that imports the pool poolName of
+// the legacy InitializePool and InitializeInsertPool helpers into an IDPool handing out
+// the IDs in [minimum, maximum). The legacy helpers kept the pool in the collection
+// poolName, either as a document listing the free IDs or as one document per allocated
+// ID. The allocated IDs are marked in the bitmap before the pool configuration is
+// stored, so the pool cannot be opened before the import is complete. The legacy
+// collection is left in place.
+func ImportLegacyIDPoolMigration(poolName string, minimum, maximum int32) MigrationFunc {
+	return func(ctx context.Context, db *mongo.Database) error {
+		cursor, err := db.Collection(poolName).Find(ctx, bson.M{})
+		if err != nil {
+			return err
+		}
+		var docs []map[string]any
+		if err := cursor.All(ctx, &docs); err != nil {
+			return err
+		}
+		cfg := IDPoolConfig{Name: poolName, Min: int64(minimum), Max: int64(maximum)}
+		allocated, err := legacyAllocatedIDs(cfg, docs)
+		if err != nil {
+			return err
+		}
+		return importIDPool(ctx, &MongoClient{Client: db.Client(), dbName: db.Name()}, cfg, allocated)
+	}
+}
+
+// legacyAllocatedIDs returns the IDs allocated in a legacy pool, given the documents of
+// its collection
+func legacyAllocatedIDs(cfg IDPoolConfig, docs []map[string]any) ([]int64, error) {
+	allocated := make(map[int64]bool)
+	for _, doc := range docs {
+		if doc["_id"] == cfg.Name {
+			// the pool of InitializePool lists its free IDs
+			var ids []any
+			switch v := doc["ids"].(type) {
+			case bson.A:
+				ids = v
+			case []any:
+				ids = v
+			case nil:
+			default:
+				return nil, fmt.Errorf("legacy pool %s: unexpected type for ids: %T", cfg.Name, v)
+			}
+			free := make(map[int64]bool, len(ids))
+			for _, value := range ids {
+				id, ok := legacyID(value)
+				if !ok {
+					return nil, fmt.Errorf("legacy pool %s: unexpected id %v", cfg.Name, value)
+				}
+				free[id] = true
+			}
+			for id := cfg.Min; id < cfg.Max; id++ {
+				if !free[id] {
+					allocated[id] = true
+				}
+			}
+			continue
+		}
+		// the pool of InitializeInsertPool stores a document per allocated ID
+		id, ok := legacyID(doc["_id"])
+		if !ok {
+			return nil, fmt.Errorf("legacy pool %s: unexpected document %v", cfg.Name, doc["_id"])
+		}
+		if id < cfg.Min || id >= cfg.Max {
+			return nil, fmt.Errorf("legacy pool %s: id %d out of range [%d, %d)", cfg.Name, id, cfg.Min, cfg.Max)
+		}
+		allocated[id] = true
+	}
+	ids := make([]int64, 0, len(allocated))
+	for id := range allocated {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+func legacyID(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), v == float64(int64(v))
+	default:
+		return 0, false
+	}
+}
+
+// importIDPool marks the allocated IDs of the pool cfg and then stores cfg. An
+// interrupted import can be repeated, and an import into an existing pool with the
+// same range does nothing.
+func importIDPool(ctx context.Context, store idPoolStore, cfg IDPoolConfig, allocated []int64) error {
+	existing, found, err := store.loadIDPoolConfig(ctx, cfg.Name)
+	if err != nil {
+		return fmt.Errorf("import of pool %s err: %w", cfg.Name, classifyError(err))
+	}
+	if found {
+		if existing.Min != cfg.Min || existing.Max != cfg.Max {
+			return fmt.Errorf("import of pool %s: pool exists with range [%d, %d)", cfg.Name, existing.Min, existing.Max)
+		}
+		return nil
+	}
+	for _, id := range allocated {
+		offset := id - cfg.Min
+		index := offset % idBlockSize
+		if _, err := store.updateIDBit(ctx, cfg, offset/idBlockSize, int(index/64), int(index%64), true); err != nil {
+			return fmt.Errorf("import of pool %s id %d err: %w", cfg.Name, id, classifyError(err))
+		}
+	}
+	if _, err := store.createIDPoolConfig(ctx, cfg); err != nil {
+		return fmt.Errorf("import of pool %s err: %w", cfg.Name, classifyError(err))
+	}
+	return nil
+}
+
+// NewIDPool creates the pool name handing out the IDs in [minimum, maximum), or opens it
+// if it exists with the same range
+func (c *MongoClient) NewIDPool(ctx context.Context, name string, minimum, maximum int64) (*IDPool, error) {
+	return newIDPool(ctx, c, name, minimum, maximum)
+}
+
+// OpenIDPool opens the existing pool name with the configuration stored in the database
+func (c *MongoClient) OpenIDPool(ctx context.Context, name string) (*IDPool, error) {
+	return openIDPool(ctx, c, name)
+}
+
+func (c *MongoClient) createIDPoolConfig(ctx context.Context, cfg IDPoolConfig) (IDPoolConfig, error) {
+	collection := c.Client.Database(c.dbName).Collection(idPoolCollection)
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	update := bson.M{"$setOnInsert": bson.M{"min": cfg.Min, "max": cfg.Max, "cursor": int64(0)}}
+	var stored IDPoolConfig
+	err := collection.FindOneAndUpdate(ctx, bson.M{"_id": cfg.Name}, update, opts).Decode(&stored)
+	if mongo.IsDuplicateKeyError(err) {
+		// a concurrent upsert created the pool first
+		err = collection.FindOne(ctx, bson.M{"_id": cfg.Name}).Decode(&stored)
+	}
+	return stored, err
+}
+
+func (c *MongoClient) loadIDPoolConfig(ctx context.Context, name string) (IDPoolConfig, bool, error) {
+	collection := c.Client.Database(c.dbName).Collection(idPoolCollection)
+	var cfg IDPoolConfig
+	if err := collection.FindOne(ctx, bson.M{"_id": name}).Decode(&cfg); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return cfg, false, nil
+		}
+		return cfg, false, err
+	}
+	return cfg, true, nil
+}
+
+func (c *MongoClient) loadIDPoolCursor(ctx context.Context, name string) (int64, error) {
+	collection := c.Client.Database(c.dbName).Collection(idPoolCollection)
+	var doc struct {
+		Cursor int64 `bson:"cursor"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"cursor": 1})
+	if err := collection.FindOne(ctx, bson.M{"_id": name}, opts).Decode(&doc); err != nil {
+		return 0, err
+	}
+	return doc.Cursor, nil
+}
+
+func (c *MongoClient) storeIDPoolCursor(ctx context.Context, name string, cursor int64) error {
+	collection := c.Client.Database(c.dbName).Collection(idPoolCollection)
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": name}, bson.M{"$set": bson.M{"cursor": cursor}})
+	return err
+}
+
+func idBlockID(pool string, block int64) string {
+	return fmt.Sprintf("%s/%d", pool, block)
+}
+
+func (c *MongoClient) loadIDBlock(ctx context.Context, cfg IDPoolConfig, block int64) ([]uint64, int64, error) {
+	collection := c.Client.Database(c.dbName).Collection(idPoolBlockCollection)
+	var doc struct {
+		Words []int64 `bson:"words"`
+		Free  int64   `bson:"free"`
+	}
+	err := collection.FindOne(ctx, bson.M{"_id": idBlockID(cfg.Name, block)}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return make([]uint64, idBlockWords), min(idBlockSize, cfg.size()-block*idBlockSize), nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	words := make([]uint64, idBlockWords)
+	for i := range min(len(doc.Words), idBlockWords) {
+		words[i] = uint64(doc.Words[i])
+	}
+	return words, doc.Free, nil
+}
+
+func (c *MongoClient) updateIDBit(ctx context.Context, cfg IDPoolConfig, block int64, word int, bit int, set bool) (bool, error) {
+	collection := c.Client.Database(c.dbName).Collection(idPoolBlockCollection)
+	id := idBlockID(cfg.Name, block)
+	if set {
+		// blocks are created on their first allocation
+		initial := bson.M{
+			"pool":  cfg.Name,
+			"block": block,
+			"words": make([]int64, idBlockWords),
+			"free":  min(idBlockSize, cfg.size()-block*idBlockSize),
+		}
+		_, err := collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$setOnInsert": initial},
+			options.UpdateOne().SetUpsert(true))
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return false, err
+		}
+	}
+
+	path := fmt.Sprintf("words.%d", word)
+	mask := int64(uint64(1) << bit)
+	filter := bson.M{"_id": id}
+	var update bson.M
+	if set {
+		filter[path] = bson.M{"$bitsAllClear": bson.A{bit}}
+		update = bson.M{"$bit": bson.M{path: bson.M{"or": mask}}, "$inc": bson.M{"free": int64(-1)}}
+	} else {
+		filter[path] = bson.M{"$bitsAllSet": bson.A{bit}}
+		update = bson.M{"$bit": bson.M{path: bson.M{"and": ^mask}}, "$inc": bson.M{"free": int64(1)}}
+	}
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+func (c *MongoClient) deleteIDPool(ctx context.Context, name string) error {
+	database := c.Client.Database(c.dbName)
+	if _, err := database.Collection(idPoolBlockCollection).DeleteMany(ctx, bson.M{"pool": name}); err != nil {
+		return err
+	}
+	_, err := database.Collection(idPoolCollection).DeleteOne(ctx, bson.M{"_id": name})
+	return err
+}
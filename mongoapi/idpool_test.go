@@ -0,0 +1,279 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestFirstClearBit(t *testing.T) {
+	words := []uint64{^uint64(0), 0b1011, 0}
+	testCases := []struct {
+		from     int64
+		expected int64
+	}{
+		{0, 66},
+		{64, 66},
+		{67, 68},
+		{130, 130},
+	}
+	for _, tc := range testCases {
+		if index := firstClearBit(words, tc.from); index != tc.expected {
+			t.Errorf("firstClearBit(%d) = %d, expected %d", tc.from, index, tc.expected)
+		}
+	}
+	if index := firstClearBit([]uint64{^uint64(0)}, 0); index != -1 {
+		t.Errorf("firstClearBit() of a full bitmap = %d, expected -1", index)
+	}
+}
+
+func TestIDPoolWrapAround(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryDB()
+	pool, err := db.NewIDPool(ctx, "teid", 10, 15)
+	if err != nil {
+		t.Fatalf("NewIDPool() failed: %v", err)
+	}
+	for expected := int64(10); expected < 15; expected++ {
+		if id, err := pool.Allocate(ctx); err != nil || id != expected {
+			t.Fatalf("Allocate() = %d, %v, expected %d", id, err, expected)
+		}
+	}
+	if _, err := pool.Allocate(ctx); !errors.Is(err, ErrPoolExhausted) {
+		t.Fatalf("Allocate() of an exhausted pool should fail with ErrPoolExhausted, got %v", err)
+	}
+
+	if err := pool.Release(ctx, 12); err != nil {
+		t.Fatalf("Release() failed: %v", err)
+	}
+	if err := pool.Release(ctx, 12); !errors.Is(err, ErrIDNotAllocated) {
+		t.Errorf("Release() of a free id should fail with ErrIDNotAllocated, got %v", err)
+	}
+	if err := pool.Release(ctx, 15); err == nil {
+		t.Error("Release() of an id out of range should fail")
+	}
+	if id, err := pool.Allocate(ctx); err != nil || id != 12 {
+		t.Fatalf("Allocate() after wrapping around = %d, %v, expected 12", id, err)
+	}
+
+	// released ids are reused only after the search has wrapped around
+	for _, id := range []int64{11, 13} {
+		if err := pool.Release(ctx, id); err != nil {
+			t.Fatalf("Release() failed: %v", err)
+		}
+	}
+	if id, _ := pool.Allocate(ctx); id != 13 {
+		t.Errorf("Allocate() = %d, expected the id after the last allocated one, 13", id)
+	}
+	if id, _ := pool.Allocate(ctx); id != 11 {
+		t.Errorf("Allocate() = %d, expected 11", id)
+	}
+}
+
+func TestIDPoolConfiguration(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryDB()
+	if _, err := db.OpenIDPool(ctx, "ngap"); !errors.Is(err, ErrPoolNotFound) {
+		t.Errorf("OpenIDPool() of a missing pool should fail with ErrPoolNotFound, got %v", err)
+	}
+	if _, err := db.NewIDPool(ctx, "ngap", 5, 5); err == nil {
+		t.Error("NewIDPool() should reject an empty range")
+	}
+	if _, err := db.NewIDPool(ctx, "ngap", 1, 1000); err != nil {
+		t.Fatalf("NewIDPool() failed: %v", err)
+	}
+	if _, err := db.NewIDPool(ctx, "ngap", 1, 1000); err != nil {
+		t.Errorf("NewIDPool() with the stored range should open the pool: %v", err)
+	}
+	if _, err := db.NewIDPool(ctx, "ngap", 1, 2000); err == nil {
+		t.Error("NewIDPool() with a range different from the stored one should fail")
+	}
+	pool, err := db.OpenIDPool(ctx, "ngap")
+	if err != nil {
+		t.Fatalf("OpenIDPool() failed: %v", err)
+	}
+	if cfg := pool.Config(); cfg.Min != 1 || cfg.Max != 1000 {
+		t.Errorf("OpenIDPool() loaded %+v, expected the stored range [1, 1000)", cfg)
+	}
+	if err := pool.Delete(ctx); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	if _, err := db.OpenIDPool(ctx, "ngap"); !errors.Is(err, ErrPoolNotFound) {
+		t.Errorf("OpenIDPool() of a deleted pool should fail with ErrPoolNotFound, got %v", err)
+	}
+}
+
+func TestIDPoolConcurrentAllocators(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryDB()
+	// the range spans several blocks and does not start at a block boundary
+	const size = idBlockSize + 100
+	if _, err := db.NewIDPool(ctx, "ue", 7, 7+size); err != nil {
+		t.Fatalf("NewIDPool() failed: %v", err)
+	}
+
+	const allocators = 8
+	allocated := make([][]int64, allocators)
+	var released sync.Map
+	var wg sync.WaitGroup
+	for i := range allocators {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// every allocator opens the pool on its own, as separate processes would
+			pool, err := db.OpenIDPool(ctx, "ue")
+			if err != nil {
+				t.Errorf("OpenIDPool() failed: %v", err)
+				return
+			}
+			for {
+				id, err := pool.Allocate(ctx)
+				if errors.Is(err, ErrPoolExhausted) {
+					return
+				}
+				if err != nil {
+					t.Errorf("Allocate() failed: %v", err)
+					return
+				}
+				allocated[i] = append(allocated[i], id)
+				// release some ids once so that allocators also compete for reused ids
+				if _, loaded := released.LoadOrStore(id, true); !loaded && id%5 == 0 {
+					if err := pool.Release(ctx, id); err != nil {
+						t.Errorf("Release() failed: %v", err)
+						return
+					}
+					allocated[i] = allocated[i][:len(allocated[i])-1]
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[int64]bool, size)
+	for _, ids := range allocated {
+		for _, id := range ids {
+			if seen[id] {
+				t.Fatalf("id %d was allocated twice", id)
+			}
+			if id < 7 || id >= 7+size {
+				t.Fatalf("id %d is out of range", id)
+			}
+			seen[id] = true
+		}
+	}
+	if len(seen) != size {
+		t.Errorf("%d ids were allocated, expected all %d ids of the pool", len(seen), size)
+	}
+}
+
+func TestLegacyAllocatedIDs(t *testing.T) {
+	cfg := IDPoolConfig{Name: "teid", Min: 10, Max: 15}
+	testCases := []struct {
+		name     string
+		docs     []map[string]any
+		expected []int64
+	}{
+		{"no state", nil, []int64{}},
+		{"free list", []map[string]any{{"_id": "teid", "ids": bson.A{int32(10), int32(12), int64(14)}}}, []int64{11, 13}},
+		{"insert pool", []map[string]any{{"_id": int32(13)}, {"_id": int64(10)}, {"_id": float64(11)}}, []int64{10, 11, 13}},
+	}
+	for _, tc := range testCases {
+		allocated, err := legacyAllocatedIDs(cfg, tc.docs)
+		if err != nil || !reflect.DeepEqual(allocated, tc.expected) {
+			t.Errorf("%s: legacyAllocatedIDs() = %v, %v, expected %v", tc.name, allocated, err, tc.expected)
+		}
+	}
+	if _, err := legacyAllocatedIDs(cfg, []map[string]any{{"_id": int32(15)}}); err == nil {
+		t.Error("legacyAllocatedIDs() should reject an id out of range")
+	}
+	if _, err := legacyAllocatedIDs(cfg, []map[string]any{{"_id": "other"}}); err == nil {
+		t.Error("legacyAllocatedIDs() should reject an unexpected document")
+	}
+}
+
+func TestImportIDPool(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryDB()
+	cfg := IDPoolConfig{Name: "teid", Min: 10, Max: 15}
+	if err := importIDPool(ctx, db, cfg, []int64{10, 11, 13}); err != nil {
+		t.Fatalf("importIDPool() failed: %v", err)
+	}
+	// a repeated import of the existing pool does nothing
+	if err := importIDPool(ctx, db, cfg, []int64{12}); err != nil {
+		t.Fatalf("repeated importIDPool() failed: %v", err)
+	}
+	if err := importIDPool(ctx, db, IDPoolConfig{Name: "teid", Min: 10, Max: 20}, nil); err == nil {
+		t.Error("importIDPool() into a pool with another range should fail")
+	}
+
+	pool, err := db.OpenIDPool(ctx, "teid")
+	if err != nil {
+		t.Fatalf("OpenIDPool() of the imported pool failed: %v", err)
+	}
+	for _, expected := range []int64{12, 14} {
+		if id, err := pool.Allocate(ctx); err != nil || id != expected {
+			t.Fatalf("Allocate() = %d, %v, expected %d", id, err, expected)
+		}
+	}
+	if _, err := pool.Allocate(ctx); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("Allocate() should not hand out the imported ids, got %v", err)
+	}
+	if err := pool.Release(ctx, 13); err != nil {
+		t.Errorf("Release() of an imported id failed: %v", err)
+	}
+}
+
+// racingIDPoolStore lets a rival allocator take the ID of the next races allocations
+// right before they update its bit, as a concurrent allocator in another process could
+// between loadIDBlock and updateIDBit
+type racingIDPoolStore struct {
+	idPoolStore
+	races  int
+	stolen []int64
+}
+
+func (s *racingIDPoolStore) updateIDBit(ctx context.Context, cfg IDPoolConfig, block int64, word int, bit int,
+	set bool,
+) (bool, error) {
+	if set && s.races > 0 {
+		s.races--
+		if _, err := s.idPoolStore.updateIDBit(ctx, cfg, block, word, bit, true); err != nil {
+			return false, err
+		}
+		s.stolen = append(s.stolen, cfg.Min+block*idBlockSize+int64(word*64+bit))
+	}
+	return s.idPoolStore.updateIDBit(ctx, cfg, block, word, bit, set)
+}
+
+func TestIDPoolLostRace(t *testing.T) {
+	ctx := context.Background()
+	store := &racingIDPoolStore{idPoolStore: NewMemoryDB(), races: 2}
+	pool, err := newIDPool(ctx, store, "ue", 10, 14)
+	if err != nil {
+		t.Fatalf("newIDPool() failed: %v", err)
+	}
+	id, err := pool.Allocate(ctx)
+	if err != nil {
+		t.Fatalf("Allocate() failed: %v", err)
+	}
+	if id != 12 || !reflect.DeepEqual(store.stolen, []int64{10, 11}) {
+		t.Errorf("Allocate() = %d after losing %v, expected 12 after losing 10 and 11", id, store.stolen)
+	}
+
+	// the rival takes the last free ID, so the pool is exhausted
+	store.races = 1
+	if id, err := pool.Allocate(ctx); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("Allocate() = %d, %v, expected ErrPoolExhausted after losing the last ID", id, err)
+	}
+	if !reflect.DeepEqual(store.stolen, []int64{10, 11, 13}) {
+		t.Errorf("expected the rival to take 10, 11 and 13, got %v", store.stolen)
+	}
+}
@@ -0,0 +1,225 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/omec-project/util/logger"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/event"
+)
+
+// OperationEvent describes a completed database operation
+type OperationEvent struct {
+	// Operation is the name of the MongoClient method, or the name of the database
+	// command for events of a command monitor
+	Operation  string
+	Collection string
+	Duration   time.Duration
+	// Count is the number of documents returned by a read, or affected by a write. Writes
+	// of a single document whose outcome is not reported count as one document.
+	Count int64
+	Err   error
+}
+
+// Instrumentation observes database operations. ObserveOperation is called
+// synchronously after every operation and must not block.
+type Instrumentation interface {
+	ObserveOperation(event OperationEvent)
+}
+
+// InstrumentationFunc adapts a function to the Instrumentation interface
+type InstrumentationFunc func(event OperationEvent)
+
+func (f InstrumentationFunc) ObserveOperation(event OperationEvent) {
+	f(event)
+}
+
+// AddInstrumentation registers instrumentation to observe the operations of the client
+func (c *MongoClient) AddInstrumentation(instrumentation Instrumentation) {
+	c.instrumentationMutex.Lock()
+	defer c.instrumentationMutex.Unlock()
+
+	instrumentations := []Instrumentation{instrumentation}
+	if current := c.instrumentations.Load(); current != nil {
+		instrumentations = append(slices.Clone(*current), instrumentation)
+	}
+	c.instrumentations.Store(&instrumentations)
+}
+
+// observe reports an operation that started at start to the registered instrumentation.
+// It is deferred by the operations with pointers to their named results.
+func (c *MongoClient) observe(operation, collName string, start time.Time, result any, err *error) {
+	instrumentations := c.instrumentations.Load()
+	if instrumentations == nil {
+		return
+	}
+	event := OperationEvent{
+		Operation:  operation,
+		Collection: collName,
+		Duration:   time.Since(start),
+	}
+	if err != nil {
+		event.Err = *err
+	}
+	if event.Err == nil {
+		event.Count = resultCount(result)
+	}
+	for _, instrumentation := range *instrumentations {
+		instrumentation.ObserveOperation(event)
+	}
+}
+
+// resultCount returns the number of documents of the result of an operation
+func resultCount(result any) int64 {
+	switch r := result.(type) {
+	case *map[string]any:
+		if *r == nil {
+			return 0
+		}
+		return 1
+	case *bson.M:
+		if *r == nil {
+			return 0
+		}
+		return 1
+	case *[]map[string]any:
+		return int64(len(*r))
+	case *[]any:
+		return int64(len(*r))
+	case *[]ChunkLease:
+		return int64(len(*r))
+	case *[]AuditRecord:
+		return int64(len(*r))
+	case *int64:
+		return *r
+	case **Page:
+		if *r == nil {
+			return 0
+		}
+		return int64(len((*r).Documents))
+	case **BulkWriteResult:
+		if *r == nil {
+			return 0
+		}
+		return int64(len((*r).Items) - len((*r).Failed()))
+	default:
+		return 1
+	}
+}
+
+// SlowQueryLogger logs the operations that take at least Threshold with MongoapiLog
+type SlowQueryLogger struct {
+	Threshold time.Duration
+}
+
+func (l SlowQueryLogger) ObserveOperation(event OperationEvent) {
+	if event.Duration < l.Threshold {
+		return
+	}
+	if event.Err != nil {
+		logger.MongoapiLog.Warnf("slow %s on %s took %v and failed: %v", event.Operation, event.Collection, event.Duration, event.Err)
+		return
+	}
+	logger.MongoapiLog.Warnf("slow %s on %s took %v for %d documents", event.Operation, event.Collection, event.Duration, event.Count)
+}
+
+// MetricsRegistry records the metrics of MetricsInstrumentation. It is implemented on
+// top of a metrics library, for example with Prometheus counter and histogram vectors
+// labelled by operation, collection and result.
+type MetricsRegistry interface {
+	// IncOperation counts an operation, result is "success" or the kind of the error
+	IncOperation(operation, collection, result string)
+	ObserveDuration(operation, collection string, seconds float64)
+	AddDocuments(operation, collection string, count int64)
+}
+
+// MetricsInstrumentation reports the operations to a MetricsRegistry
+type MetricsInstrumentation struct {
+	Registry MetricsRegistry
+}
+
+func (m MetricsInstrumentation) ObserveOperation(event OperationEvent) {
+	m.Registry.IncOperation(event.Operation, event.Collection, resultLabel(event.Err))
+	m.Registry.ObserveDuration(event.Operation, event.Collection, event.Duration.Seconds())
+	if event.Count > 0 {
+		m.Registry.AddDocuments(event.Operation, event.Collection, event.Count)
+	}
+}
+
+// resultLabel returns the metrics label of the outcome of an operation
+func resultLabel(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	case errors.Is(err, ErrDuplicateKey):
+		return "duplicate_key"
+	case errors.Is(err, ErrConflict):
+		return "conflict"
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	default:
+		return "error"
+	}
+}
+
+// NewCommandMonitor returns a command monitor for options.ClientOptions.SetMonitor that
+// reports every command sent by the driver to instrumentation. Unlike the events of
+// MongoClient methods, these also cover commands issued directly on the mongo.Client.
+func NewCommandMonitor(instrumentation Instrumentation) *event.CommandMonitor {
+	// started maps the request IDs of running commands to their collection
+	var started sync.Map
+	finish := func(e event.CommandFinishedEvent, count int64, err error) {
+		collection := ""
+		if value, ok := started.LoadAndDelete(e.RequestID); ok {
+			collection = value.(string)
+		}
+		instrumentation.ObserveOperation(OperationEvent{
+			Operation:  e.CommandName,
+			Collection: collection,
+			Duration:   e.Duration,
+			Count:      count,
+			Err:        classifyError(err),
+		})
+	}
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, e *event.CommandStartedEvent) {
+			// the value of the first element of a command is the collection it operates on
+			collection, _ := e.Command.Index(0).Value().StringValueOK()
+			started.Store(e.RequestID, collection)
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			finish(e.CommandFinishedEvent, replyCount(e.Reply), nil)
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			finish(e.CommandFinishedEvent, 0, e.Failure)
+		},
+	}
+}
+
+// replyCount returns the number of documents returned or affected according to a reply
+func replyCount(reply bson.Raw) int64 {
+	for _, batch := range []string{"firstBatch", "nextBatch"} {
+		if docs, err := reply.LookupErr("cursor", batch); err == nil {
+			if array, ok := docs.ArrayOK(); ok {
+				values, _ := array.Values()
+				return int64(len(values))
+			}
+		}
+	}
+	if n, err := reply.LookupErr("n"); err == nil {
+		if count, ok := n.AsInt64OK(); ok {
+			return count
+		}
+	}
+	return 0
+}
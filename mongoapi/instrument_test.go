@@ -0,0 +1,194 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/event"
+)
+
+type testRegistry struct {
+	operations map[string]int
+	documents  map[string]int64
+}
+
+func (r *testRegistry) IncOperation(operation, collection, result string) {
+	r.operations[operation+"/"+collection+"/"+result]++
+}
+
+func (r *testRegistry) ObserveDuration(operation, collection string, seconds float64) {}
+
+func (r *testRegistry) AddDocuments(operation, collection string, count int64) {
+	r.documents[operation+"/"+collection] += count
+}
+
+func TestObserveOperations(t *testing.T) {
+	c := &MongoClient{}
+	var events []OperationEvent
+	c.AddInstrumentation(InstrumentationFunc(func(event OperationEvent) {
+		events = append(events, event)
+	}))
+
+	getMany := func(fail bool) (resultArray []map[string]any, err error) {
+		defer c.observe("RestfulAPIGetMany", "subscribers", time.Now(), &resultArray, &err)
+		if fail {
+			return nil, fmt.Errorf("RestfulAPIGetMany err: %w", ErrTimeout)
+		}
+		return []map[string]any{{"imsi": "1"}, {"imsi": "2"}}, nil
+	}
+	if _, err := getMany(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getMany(true); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[0].Operation != "RestfulAPIGetMany" || events[0].Collection != "subscribers" ||
+		events[0].Count != 2 || events[0].Err != nil {
+		t.Errorf("unexpected event %+v", events[0])
+	}
+	if events[1].Count != 0 || !errors.Is(events[1].Err, ErrTimeout) {
+		t.Errorf("unexpected event %+v", events[1])
+	}
+}
+
+func TestResultCount(t *testing.T) {
+	var missing map[string]any
+	found := map[string]any{"a": 1}
+	many := []map[string]any{{}, {}, {}}
+	count := int64(7)
+	page := &Page{Documents: many}
+	bulk := newBulkWriteResult(3)
+	bulk.Items[2].Err = ErrBulkNotExecuted
+	existed := true
+	leases := []ChunkLease{{Chunk: 1}, {Chunk: 2}}
+	records := []AuditRecord{{}}
+
+	tests := []struct {
+		name   string
+		result any
+		want   int64
+	}{
+		{"missing document", &missing, 0},
+		{"document", &found, 1},
+		{"documents", &many, 3},
+		{"count", &count, 7},
+		{"page", &page, 3},
+		{"bulk write", &bulk, 2},
+		{"single write", &existed, 1},
+		{"chunk leases", &leases, 2},
+		{"audit records", &records, 1},
+		{"no result", nil, 1},
+	}
+	for _, tc := range tests {
+		if got := resultCount(tc.result); got != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestMetricsInstrumentation(t *testing.T) {
+	registry := &testRegistry{operations: map[string]int{}, documents: map[string]int64{}}
+	metrics := MetricsInstrumentation{Registry: registry}
+
+	metrics.ObserveOperation(OperationEvent{Operation: "RestfulAPIGetMany", Collection: "subs", Count: 4})
+	metrics.ObserveOperation(OperationEvent{Operation: "RestfulAPIGetMany", Collection: "subs", Count: 1})
+	metrics.ObserveOperation(OperationEvent{Operation: "RestfulAPIPostOnly", Collection: "subs",
+		Err: fmt.Errorf("RestfulAPIPostOnly err: %w", &Error{Kind: ErrDuplicateKey, Err: errors.New("E11000")})})
+	metrics.ObserveOperation(OperationEvent{Operation: "RestfulAPIPutOnly", Collection: "subs", Err: errors.New("broken")})
+
+	want := map[string]int{
+		"RestfulAPIGetMany/subs/success":        2,
+		"RestfulAPIPostOnly/subs/duplicate_key": 1,
+		"RestfulAPIPutOnly/subs/error":          1,
+	}
+	for key, count := range want {
+		if registry.operations[key] != count {
+			t.Errorf("%s: expected %d, got %d", key, count, registry.operations[key])
+		}
+	}
+	if len(registry.operations) != len(want) {
+		t.Errorf("unexpected operations %v", registry.operations)
+	}
+	if registry.documents["RestfulAPIGetMany/subs"] != 5 || len(registry.documents) != 1 {
+		t.Errorf("unexpected documents %v", registry.documents)
+	}
+}
+
+func TestCommandMonitor(t *testing.T) {
+	var events []OperationEvent
+	monitor := NewCommandMonitor(InstrumentationFunc(func(event OperationEvent) {
+		events = append(events, event)
+	}))
+	ctx := context.Background()
+
+	mustMarshal := func(doc bson.D) bson.Raw {
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	monitor.Started(ctx, &event.CommandStartedEvent{
+		Command:     mustMarshal(bson.D{{Key: "find", Value: "subscribers"}, {Key: "filter", Value: bson.D{}}}),
+		CommandName: "find",
+		RequestID:   1,
+	})
+	monitor.Started(ctx, &event.CommandStartedEvent{
+		Command:     mustMarshal(bson.D{{Key: "delete", Value: "sessions"}}),
+		CommandName: "delete",
+		RequestID:   2,
+	})
+	monitor.Succeeded(ctx, &event.CommandSucceededEvent{
+		CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "find", RequestID: 1, Duration: time.Millisecond},
+		Reply: mustMarshal(bson.D{{Key: "cursor", Value: bson.D{
+			{Key: "firstBatch", Value: bson.A{bson.D{}, bson.D{}}},
+		}}}),
+	})
+	monitor.Failed(ctx, &event.CommandFailedEvent{
+		CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "delete", RequestID: 2},
+		Failure:              context.DeadlineExceeded,
+	})
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[0].Operation != "find" || events[0].Collection != "subscribers" || events[0].Count != 2 ||
+		events[0].Duration != time.Millisecond || events[0].Err != nil {
+		t.Errorf("unexpected event %+v", events[0])
+	}
+	if events[1].Operation != "delete" || events[1].Collection != "sessions" || !errors.Is(events[1].Err, ErrTimeout) {
+		t.Errorf("unexpected event %+v", events[1])
+	}
+}
+
+func TestReplyCount(t *testing.T) {
+	tests := []struct {
+		reply bson.D
+		want  int64
+	}{
+		{bson.D{{Key: "n", Value: int32(3)}, {Key: "ok", Value: 1.0}}, 3},
+		{bson.D{{Key: "cursor", Value: bson.D{{Key: "nextBatch", Value: bson.A{bson.D{}}}}}}, 1},
+		{bson.D{{Key: "ok", Value: 1.0}}, 0},
+	}
+	for _, tc := range tests {
+		raw, err := bson.Marshal(tc.reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := replyCount(raw); got != tc.want {
+			t.Errorf("%v: expected %d, got %d", tc.reply, tc.want, got)
+		}
+	}
+}
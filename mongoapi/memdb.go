@@ -0,0 +1,1466 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// duplicateKeyErrorCode is the server error code reported for unique index violations
+const duplicateKeyErrorCode = 11000
+
+// MemoryDB is an in-memory implementation of DBInterface intended for unit tests.
+// It mirrors the semantics of MongoClient closely enough that NFs can assign it to
+// CommonDBClient instead of connecting to a real MongoDB:
+//   - filters support field equality (including dotted paths) and the $eq, $ne, $gt,
+//     $gte, $lt, $lte, $in, $nin, $exists, $and, $or and $nor operators
+//   - documents are stored in BSON form, so values read back have the same types as
+//     values decoded by the mongo driver
+//   - unique indexes created with CreateIndex are enforced on every write and
+//     violations are reported as errors for which mongo.IsDuplicateKeyError is true
+type MemoryDB struct {
+	collections       map[string]*memCollection
+	idPools           map[string]*memIDPool
+	chunkPools        map[string]*memChunkPool
+	transactionPolicy TransactionPolicy
+	mutex             sync.Mutex
+}
+
+// memIDPool holds the state of an ID pool of a MemoryDB. Like the block documents of a
+// MongoClient, the blocks can be written before the configuration is stored.
+type memIDPool struct {
+	config     IDPoolConfig
+	configured bool
+	cursor     int64
+	blocks     map[int64][]uint64
+	free       map[int64]int64
+}
+
+// memChunkPool holds the configuration and the leases of a chunk pool of a MemoryDB.
+// Unlike the TTL index of a MongoClient, nothing removes the expired leases on its own.
+type memChunkPool struct {
+	config     chunkPoolConfig
+	configured bool
+	leases     map[int32]ChunkLease
+}
+
+type memCollection struct {
+	// docs stores the BSON encoded documents in insertion order
+	docs []bson.Raw
+	// uniqueKeys stores the fields covered by a unique index
+	uniqueKeys []string
+}
+
+var _ DBInterface = (*MemoryDB)(nil)
+
+// NewMemoryDB creates an empty MemoryDB
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{
+		collections: make(map[string]*memCollection),
+		idPools:     make(map[string]*memIDPool),
+		chunkPools:  make(map[string]*memChunkPool),
+	}
+}
+
+func (m *MemoryDB) collection(collName string) *memCollection {
+	coll, ok := m.collections[collName]
+	if !ok {
+		coll = &memCollection{}
+		m.collections[collName] = coll
+	}
+	return coll
+}
+
+func (m *MemoryDB) RestfulAPIGetOne(collName string, filter bson.M) (map[string]any, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	_, result, err := m.collection(collName).findOne(filter)
+	if err != nil {
+		return nil, fmt.Errorf("RestfulAPIGetOne err: %w", classifyError(err))
+	}
+	if result != nil {
+		// Delete "_id" entry which is auto-inserted by MongoDB
+		delete(result, "_id")
+		delete(result, VersionField)
+	}
+	return result, nil
+}
+
+func (m *MemoryDB) RestfulAPIGetMany(collName string, filter bson.M) ([]map[string]any, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	coll := m.collection(collName)
+	indexes, err := coll.find(filter)
+	if err != nil {
+		return nil, fmt.Errorf("RestfulAPIGetMany err: %w", classifyError(err))
+	}
+
+	var resultArray []map[string]any
+	for _, i := range indexes {
+		result, err := decodeDocument(coll.docs[i])
+		if err != nil {
+			return nil, fmt.Errorf("RestfulAPIGetMany err: %w", classifyError(err))
+		}
+		delete(result, "_id")
+		delete(result, VersionField)
+		resultArray = append(resultArray, result)
+	}
+	return resultArray, nil
+}
+
+func (m *MemoryDB) RestfulAPIPutOneTimeout(collName string, filter bson.M, putData map[string]any, timeout int32, timeField string) bool {
+	return m.RestfulAPIPutOneTimeoutWithError(collName, filter, putData, timeout, timeField) == nil
+}
+
+func (m *MemoryDB) RestfulAPIPutOneTimeoutWithError(collName string, filter bson.M, putData map[string]any, timeout int32, timeField string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	coll := m.collection(collName)
+	i, _, err := coll.findOne(filter)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIPutOneTimeout FindOne err: %w", err)
+	}
+	if i < 0 {
+		err = coll.insert(collName, putData)
+	} else {
+		err = coll.set(collName, i, putData)
+	}
+	if err != nil {
+		return fmt.Errorf("RestfulAPIPutOneTimeout err: %w", classifyError(err))
+	}
+	return nil
+}
+
+// if no error happened, return true means data existed and false means data not existed
+func (m *MemoryDB) RestfulAPIPutOne(collName string, filter bson.M, putData map[string]any) (bool, error) {
+	return m.RestfulAPIPutOneWithContext(context.TODO(), collName, filter, putData)
+}
+
+// if no error happened, return true means data existed and false means data not existed
+func (m *MemoryDB) RestfulAPIPutOneWithContext(ctx context.Context, collName string, filter bson.M, putData map[string]any) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, fmt.Errorf("RestfulAPIPutOneWithContext UpdateOne err: %w", classifyError(err))
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	existed, err := m.collection(collName).upsert(collName, filter, putData)
+	if err != nil {
+		return false, fmt.Errorf("RestfulAPIPutOneWithContext UpdateOne err: %w", classifyError(err))
+	}
+	return existed, nil
+}
+
+// if no error happened, return true means data existed (not updated) and false means data not existed
+func (m *MemoryDB) RestfulAPIPutOneNotUpdate(collName string, filter bson.M, putData map[string]any) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	coll := m.collection(collName)
+	i, _, err := coll.findOne(filter)
+	if err != nil {
+		return false, fmt.Errorf("RestfulAPIPutOneNotUpdate err: %w", classifyError(err))
+	}
+	if i >= 0 {
+		return true, nil
+	}
+	if err := coll.insert(collName, putData); err != nil {
+		return false, fmt.Errorf("RestfulAPIPutOneNotUpdate InsertOne err: %w", classifyError(err))
+	}
+	return false, nil
+}
+
+func (m *MemoryDB) RestfulAPIPutMany(collName string, filterArray []bson.M, putDataArray []map[string]any) error {
+	if len(filterArray) < len(putDataArray) {
+		return fmt.Errorf("RestfulAPIPutMany err: %d filters for %d documents", len(filterArray), len(putDataArray))
+	}
+	if len(putDataArray) == 0 {
+		return nil
+	}
+	filters := make(bson.A, 0, len(putDataArray))
+	for _, filter := range filterArray[:len(putDataArray)] {
+		filters = append(filters, filter)
+	}
+	m.mutex.Lock()
+	coll := m.collection(collName)
+	indexes, err := coll.find(bson.M{"$or": filters})
+	existing := make([]map[string]any, 0, len(indexes))
+	for _, i := range indexes {
+		if err != nil {
+			break
+		}
+		var doc map[string]any
+		doc, err = decodeDocument(coll.docs[i])
+		existing = append(existing, doc)
+	}
+	m.mutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("RestfulAPIPutMany Find err: %w", classifyError(err))
+	}
+
+	operations, err := putManyOperations(filterArray, putDataArray, existing)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIPutMany err: %w", err)
+	}
+	if _, err := m.RestfulAPIBulkWrite(context.TODO(), collName, operations, true); err != nil {
+		return fmt.Errorf("RestfulAPIPutMany err: %w", err)
+	}
+	return nil
+}
+
+// RestfulAPIBulkWrite applies operations one at a time. Unordered bulk writes are
+// applied in sequence as well, but continue after failed operations.
+func (m *MemoryDB) RestfulAPIBulkWrite(ctx context.Context, collName string, operations []BulkOperation,
+	ordered bool,
+) (*BulkWriteResult, error) {
+	result := newBulkWriteResult(len(operations))
+	if err := ctx.Err(); err != nil {
+		return result, fmt.Errorf("RestfulAPIBulkWrite BulkWrite err: %w", classifyError(err))
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	coll := m.collection(collName)
+	for i, operation := range operations {
+		if err := coll.bulkWrite(collName, operation, result, i); err != nil {
+			result.Items[i].Err = err
+			if ordered {
+				for j := i + 1; j < len(operations); j++ {
+					result.Items[j].Err = ErrBulkNotExecuted
+				}
+				break
+			}
+		}
+	}
+	return result, result.err("RestfulAPIBulkWrite")
+}
+
+func (m *MemoryDB) RestfulAPIDeleteOne(collName string, filter bson.M) error {
+	return m.RestfulAPIDeleteOneWithContext(context.TODO(), collName, filter)
+}
+
+func (m *MemoryDB) RestfulAPIDeleteOneWithContext(ctx context.Context, collName string, filter bson.M) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("RestfulAPIDeleteOneWithContext DeleteOne err: %w", classifyError(err))
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	coll := m.collection(collName)
+	i, _, err := coll.findOne(filter)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIDeleteOneWithContext DeleteOne err: %w", classifyError(err))
+	}
+	if i >= 0 {
+		coll.docs = append(coll.docs[:i], coll.docs[i+1:]...)
+	}
+	return nil
+}
+
+func (m *MemoryDB) RestfulAPIDeleteMany(collName string, filter bson.M) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	coll := m.collection(collName)
+	indexes, err := coll.find(filter)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIDeleteMany err: %w", classifyError(err))
+	}
+	remaining := coll.docs[:0]
+	next := 0
+	for i, doc := range coll.docs {
+		if next < len(indexes) && indexes[next] == i {
+			next++
+			continue
+		}
+		remaining = append(remaining, doc)
+	}
+	coll.docs = remaining
+	return nil
+}
+
+func (m *MemoryDB) RestfulAPIMergePatch(collName string, filter bson.M, patchData map[string]any) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	coll := m.collection(collName)
+	i, originalData, err := coll.findOne(filter)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIMergePatch getOrigData err: %w", classifyError(err))
+	}
+	if originalData != nil {
+		delete(originalData, "_id")
+		delete(originalData, VersionField)
+	}
+
+	original, err := json.Marshal(originalData)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIMergePatch Marshal err: %w", classifyError(err))
+	}
+
+	patchDataByte, err := json.Marshal(patchData)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIMergePatch Marshal err: %w", classifyError(err))
+	}
+
+	modifiedAlternative, err := jsonpatch.MergePatch(original, patchDataByte)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIMergePatch MergePatch err: %w", classifyError(err))
+	}
+
+	var modifiedData map[string]any
+	if err := json.Unmarshal(modifiedAlternative, &modifiedData); err != nil {
+		return fmt.Errorf("RestfulAPIMergePatch Unmarshal err: %w", classifyError(err))
+	}
+	if i < 0 {
+		return nil
+	}
+	if err := coll.replace(collName, i, modifiedData); err != nil {
+		return fmt.Errorf("RestfulAPIMergePatch UpdateOne err: %w", classifyError(err))
+	}
+	return nil
+}
+
+func (m *MemoryDB) RestfulAPIJSONPatch(collName string, filter bson.M, patchJSON []byte) error {
+	return m.RestfulAPIJSONPatchWithContext(context.TODO(), collName, filter, patchJSON)
+}
+
+func (m *MemoryDB) RestfulAPIJSONPatchWithContext(ctx context.Context, collName string, filter bson.M, patchJSON []byte) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("RestfulAPIJSONPatch UpdateOne err: %w", classifyError(err))
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	coll := m.collection(collName)
+	i, originalData, err := coll.findOne(filter)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIJSONPatch getOrigData err: %w", classifyError(err))
+	}
+	if originalData != nil {
+		delete(originalData, "_id")
+		delete(originalData, VersionField)
+	}
+
+	original, err := json.Marshal(originalData)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIJSONPatch Marshal err: %w", classifyError(err))
+	}
+
+	patch, err := jsonpatch.DecodePatch(patchJSON)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIJSONPatch DecodePatch err: %w", classifyError(err))
+	}
+
+	modified, err := patch.Apply(original)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIJSONPatch Apply err: %w", classifyError(err))
+	}
+
+	var modifiedData map[string]any
+	if err := json.Unmarshal(modified, &modifiedData); err != nil {
+		return fmt.Errorf("RestfulAPIJSONPatch Unmarshal err: %w", classifyError(err))
+	}
+	if i < 0 {
+		return nil
+	}
+	if err := coll.replace(collName, i, modifiedData); err != nil {
+		return fmt.Errorf("RestfulAPIJSONPatch UpdateOne err: %w", classifyError(err))
+	}
+	return nil
+}
+
+func (m *MemoryDB) RestfulAPIJSONPatchExtend(collName string, filter bson.M, patchJSON []byte, dataName string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	coll := m.collection(collName)
+	i, originalDataCover, err := coll.findOne(filter)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIJSONPatchExtend getOrigData err: %w", classifyError(err))
+	}
+
+	originalData := originalDataCover[dataName]
+	original, err := json.Marshal(originalData)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIJSONPatchExtend Marshal err: %w", classifyError(err))
+	}
+
+	patch, err := jsonpatch.DecodePatch(patchJSON)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIJSONPatchExtend DecodePatch err: %w", classifyError(err))
+	}
+
+	modified, err := patch.Apply(original)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIJSONPatchExtend Apply err: %w", classifyError(err))
+	}
+
+	var modifiedData map[string]any
+	if err := json.Unmarshal(modified, &modifiedData); err != nil {
+		return fmt.Errorf("RestfulAPIJSONPatchExtend Unmarshal err: %w", classifyError(err))
+	}
+	if i < 0 {
+		return nil
+	}
+	if err := coll.set(collName, i, map[string]any{dataName: modifiedData}); err != nil {
+		return fmt.Errorf("RestfulAPIJSONPatchExtend UpdateOne err: %w", classifyError(err))
+	}
+	return nil
+}
+
+func (m *MemoryDB) RestfulAPIPost(collName string, filter bson.M, postData map[string]any) (bool, error) {
+	return m.RestfulAPIPutOne(collName, filter, postData)
+}
+
+func (m *MemoryDB) RestfulAPIPostWithContext(ctx context.Context, collName string, filter bson.M, postData map[string]any) (bool, error) {
+	return m.RestfulAPIPutOneWithContext(ctx, collName, filter, postData)
+}
+
+func (m *MemoryDB) RestfulAPIPostMany(collName string, filter bson.M, postDataArray []any) error {
+	return m.RestfulAPIPostManyWithContext(context.TODO(), collName, filter, postDataArray)
+}
+
+func (m *MemoryDB) RestfulAPIPostManyWithContext(ctx context.Context, collName string, filter bson.M, postDataArray []any) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("RestfulAPIPostManyWithContext InsertMany err: %w", classifyError(err))
+	}
+	if len(postDataArray) == 0 {
+		return errors.New("RestfulAPIPostManyWithContext InsertMany err: must provide at least one element")
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	coll := m.collection(collName)
+	for _, postData := range postDataArray {
+		if err := coll.insert(collName, postData); err != nil {
+			return fmt.Errorf("RestfulAPIPostManyWithContext InsertMany err: %w", classifyError(err))
+		}
+	}
+	return nil
+}
+
+/* Get unique identity from counter collection. */
+func (m *MemoryDB) GetUniqueIdentity(idName string) int32 {
+	count, err := m.GetUniqueIdentityWithError(idName)
+	if err != nil {
+		return -1
+	}
+	return count
+}
+
+func (m *MemoryDB) GetUniqueIdentityWithError(idName string) (int32, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	coll := m.collection("counter")
+	i, data, err := coll.findOne(bson.M{"_id": idName})
+	if err != nil {
+		return -1, fmt.Errorf("GetUniqueIdentity %s err: %w", idName, classifyError(err))
+	}
+	if i < 0 {
+		data = map[string]any{"_id": idName, "count": int32(1)}
+		if err := coll.insert("counter", data); err != nil {
+			return -1, fmt.Errorf("GetUniqueIdentity %s err: %w", idName, classifyError(err))
+		}
+		i = len(coll.docs) - 1
+	}
+	count, ok := data["count"].(int32)
+	if !ok {
+		return -1, fmt.Errorf("GetUniqueIdentity %s: unexpected type for count: %T", idName, data["count"])
+	}
+	if err := coll.set("counter", i, map[string]any{"count": count + 1}); err != nil {
+		return -1, fmt.Errorf("GetUniqueIdentity %s err: %w", idName, classifyError(err))
+	}
+	return count, nil
+}
+
+func (m *MemoryDB) CreateIndex(collName string, keyField string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	coll := m.collection(collName)
+	for _, key := range coll.uniqueKeys {
+		if key == keyField {
+			return true, nil
+		}
+	}
+	for i := range coll.docs {
+		doc, err := decodeDocument(coll.docs[i])
+		if err != nil {
+			return false, err
+		}
+		if err := coll.checkUnique(collName, []string{keyField}, doc, i); err != nil {
+			return false, err
+		}
+	}
+	coll.uniqueKeys = append(coll.uniqueKeys, keyField)
+	return true, nil
+}
+
+// StartSession is not supported by MemoryDB since it has no server to hold the session
+func (m *MemoryDB) StartSession() (*mongo.Session, error) {
+	return nil, errors.New("MemoryDB does not support sessions")
+}
+
+func (m *MemoryDB) SupportsTransactions() (bool, error) {
+	return false, nil
+}
+
+// SetTransactionPolicy sets the behavior of WithTransaction. MemoryDB behaves like a
+// standalone deployment, so tests of code using transactions should select
+// TransactionBestEffort.
+func (m *MemoryDB) SetTransactionPolicy(policy TransactionPolicy) {
+	m.transactionPolicy = policy
+}
+
+func (m *MemoryDB) WithTransaction(ctx context.Context, fn func(txCtx context.Context) error) error {
+	if m.transactionPolicy == TransactionBestEffort {
+		return fn(ctx)
+	}
+	return fmt.Errorf("WithTransaction err: %w", ErrTransactionsNotSupported)
+}
+
+// find returns the indexes of all documents matching filter
+func (coll *memCollection) find(filter bson.M) ([]int, error) {
+	normFilter, err := normalizeDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+	var indexes []int
+	for i, raw := range coll.docs {
+		doc, err := decodeDocument(raw)
+		if err != nil {
+			return nil, err
+		}
+		matched, err := matchDocument(doc, normFilter)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes, nil
+}
+
+// findOne returns the index and a copy of the first document matching filter,
+// or -1 and nil if there is no such document
+func (coll *memCollection) findOne(filter bson.M) (int, map[string]any, error) {
+	indexes, err := coll.find(filter)
+	if err != nil || len(indexes) == 0 {
+		return -1, nil, err
+	}
+	doc, err := decodeDocument(coll.docs[indexes[0]])
+	if err != nil {
+		return -1, nil, err
+	}
+	return indexes[0], doc, nil
+}
+
+func (coll *memCollection) insert(collName string, data any) error {
+	doc, err := normalizeDocument(data)
+	if err != nil {
+		return err
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = bson.NewObjectID()
+	}
+	return coll.store(collName, doc, -1)
+}
+
+// set applies the fields of setData to the i-th document as a $set update would
+func (coll *memCollection) set(collName string, i int, setData map[string]any) error {
+	return coll.update(collName, i, setData, nil)
+}
+
+// bulkWrite applies the i-th operation of a bulk write and accounts for it in result
+func (coll *memCollection) bulkWrite(collName string, operation BulkOperation, result *BulkWriteResult, i int) error {
+	switch operation.Type {
+	case BulkUpsert:
+		existed, err := coll.upsert(collName, operation.Filter, operation.Data)
+		if err != nil {
+			return err
+		}
+		if existed {
+			result.MatchedCount++
+			result.ModifiedCount++
+		} else {
+			result.UpsertedCount++
+			result.Items[i].Upserted = true
+		}
+	case BulkDelete:
+		index, _, err := coll.findOne(operation.Filter)
+		if err != nil {
+			return err
+		}
+		if index >= 0 {
+			coll.docs = append(coll.docs[:index], coll.docs[index+1:]...)
+			result.DeletedCount++
+		}
+	case BulkMergePatch:
+		// the member names are checked as by MongoClient
+		if _, err := mergePatchUpdate(operation.Data); err != nil {
+			return err
+		}
+		patch, err := normalizeDocument(operation.Data)
+		if err != nil {
+			return err
+		}
+		index, doc, err := coll.findOne(operation.Filter)
+		if err != nil {
+			return err
+		}
+		if index < 0 {
+			return nil
+		}
+		if err := coll.store(collName, applyMergePatch(doc, patch), index); err != nil {
+			return err
+		}
+		result.MatchedCount++
+		result.ModifiedCount++
+	case BulkInsert:
+		if err := coll.insert(collName, operation.Data); err != nil {
+			return err
+		}
+		result.InsertedCount++
+	case BulkUpdate:
+		index, _, err := coll.findOne(operation.Filter)
+		if err != nil {
+			return err
+		}
+		if index < 0 {
+			return nil
+		}
+		if err := coll.set(collName, index, operation.Data); err != nil {
+			return err
+		}
+		result.MatchedCount++
+		result.ModifiedCount++
+	default:
+		return fmt.Errorf("unknown bulk operation type: %v", operation.Type)
+	}
+	return nil
+}
+
+// update applies a $set of set and an $unset of the fields of unset to the i-th document
+func (coll *memCollection) update(collName string, i int, set, unset map[string]any) error {
+	doc, err := decodeDocument(coll.docs[i])
+	if err != nil {
+		return err
+	}
+	if len(set) > 0 {
+		values, err := normalizeDocument(set)
+		if err != nil {
+			return err
+		}
+		for key, value := range values {
+			if err := setPath(doc, key, value); err != nil {
+				return err
+			}
+		}
+	}
+	for key := range unset {
+		unsetPath(doc, key)
+	}
+	return coll.store(collName, doc, i)
+}
+
+// replace substitutes the i-th document with data, keeping its _id
+func (coll *memCollection) replace(collName string, i int, data map[string]any) error {
+	current, err := decodeDocument(coll.docs[i])
+	if err != nil {
+		return err
+	}
+	doc, err := normalizeDocument(data)
+	if err != nil {
+		return err
+	}
+	doc["_id"] = current["_id"]
+	return coll.store(collName, doc, i)
+}
+
+// upsert updates the first document matching filter with a $set of putData or,
+// if there is none, inserts a document built from the equality fields of filter
+// and putData. It reports whether a document matched.
+func (coll *memCollection) upsert(collName string, filter bson.M, putData map[string]any) (bool, error) {
+	i, _, err := coll.findOne(filter)
+	if err != nil {
+		return false, err
+	}
+	if i >= 0 {
+		return true, coll.set(collName, i, putData)
+	}
+
+	normFilter, err := normalizeDocument(filter)
+	if err != nil {
+		return false, err
+	}
+	doc := make(map[string]any)
+	for key, value := range normFilter {
+		if strings.HasPrefix(key, "$") {
+			continue
+		}
+		if cond, ok := value.(map[string]any); ok && isOperatorDocument(cond) {
+			eq, ok := cond["$eq"]
+			if !ok {
+				continue
+			}
+			value = eq
+		}
+		if err := setPath(doc, key, value); err != nil {
+			return false, err
+		}
+	}
+	update, err := normalizeDocument(putData)
+	if err != nil {
+		return false, err
+	}
+	for key, value := range update {
+		if err := setPath(doc, key, value); err != nil {
+			return false, err
+		}
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = bson.NewObjectID()
+	}
+	return false, coll.store(collName, doc, -1)
+}
+
+// store writes doc at position i, or appends it when i is negative, after
+// checking that no unique index is violated. Like the writes of MongoClient it
+// increments VersionField, which starts at 1 for an appended document.
+func (coll *memCollection) store(collName string, doc map[string]any, i int) error {
+	if err := coll.checkUnique(collName, append([]string{"_id"}, coll.uniqueKeys...), doc, i); err != nil {
+		return err
+	}
+	version := int64(1)
+	if i >= 0 {
+		if previous, ok := coll.docs[i].Lookup(VersionField).AsInt64OK(); ok {
+			version = previous + 1
+		}
+	}
+	doc[VersionField] = version
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	if i < 0 {
+		coll.docs = append(coll.docs, raw)
+	} else {
+		coll.docs[i] = raw
+	}
+	return nil
+}
+
+// checkUnique returns a duplicate key error if any document other than the
+// skip-th one has the same value as doc for one of keys
+func (coll *memCollection) checkUnique(collName string, keys []string, doc map[string]any, skip int) error {
+	for _, key := range keys {
+		value, _ := lookupPath(doc, key)
+		for j, raw := range coll.docs {
+			if j == skip {
+				continue
+			}
+			other, err := decodeDocument(raw)
+			if err != nil {
+				return err
+			}
+			otherValue, _ := lookupPath(other, key)
+			if valuesEqual(value, otherValue) {
+				return mongo.WriteException{
+					WriteErrors: []mongo.WriteError{{
+						Code: duplicateKeyErrorCode,
+						Message: fmt.Sprintf("E11000 duplicate key error collection: %s index: %s_1 dup key: { %s: %v }",
+							collName, key, key, value),
+					}},
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// normalizeDocument converts data to the representation returned by the mongo
+// driver with DefaultDocumentMap set
+func normalizeDocument(data any) (map[string]any, error) {
+	if data == nil || (reflect.ValueOf(data).Kind() == reflect.Map && reflect.ValueOf(data).IsNil()) {
+		return make(map[string]any), nil
+	}
+	raw, err := bson.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDocument(raw)
+}
+
+func decodeDocument(raw bson.Raw) (map[string]any, error) {
+	decoder := bson.NewDecoder(bson.NewDocumentReader(bytes.NewReader(raw)))
+	decoder.DefaultDocumentMap()
+	var doc map[string]any
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func isOperatorDocument(doc map[string]any) bool {
+	if len(doc) == 0 {
+		return false
+	}
+	for key := range doc {
+		if !strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupPath resolves a dotted field path in doc
+func lookupPath(doc map[string]any, path string) (any, bool) {
+	var current any = doc
+	for _, part := range strings.Split(path, ".") {
+		switch v := current.(type) {
+		case map[string]any:
+			next, ok := v[part]
+			if !ok {
+				return nil, false
+			}
+			current = next
+		case bson.A:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, false
+			}
+			current = v[index]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// setPath sets a dotted field path in doc, creating intermediate documents as needed
+func setPath(doc map[string]any, path string, value any) error {
+	parts := strings.Split(path, ".")
+	current := doc
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part]
+		if !ok || next == nil {
+			child := make(map[string]any)
+			current[part] = child
+			current = child
+			continue
+		}
+		child, ok := next.(map[string]any)
+		if !ok {
+			return fmt.Errorf("cannot create field '%s' in element {%s: %v}", path, part, next)
+		}
+		current = child
+	}
+	current[parts[len(parts)-1]] = value
+	return nil
+}
+
+// unsetPath removes a dotted field path from doc if it exists
+func unsetPath(doc map[string]any, path string) {
+	parts := strings.Split(path, ".")
+	current := doc
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := current[part].(map[string]any)
+		if !ok {
+			return
+		}
+		current = child
+	}
+	delete(current, parts[len(parts)-1])
+}
+
+func matchDocument(doc map[string]any, filter map[string]any) (bool, error) {
+	for key, cond := range filter {
+		var (
+			matched bool
+			err     error
+		)
+		switch key {
+		case "$and", "$or", "$nor":
+			matched, err = matchLogical(doc, key, cond)
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, fmt.Errorf("unsupported top-level operator: %s", key)
+			}
+			value, found := lookupPath(doc, key)
+			matched, err = matchField(value, found, cond)
+		}
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchLogical(doc map[string]any, operator string, cond any) (bool, error) {
+	clauses, ok := cond.(bson.A)
+	if !ok || len(clauses) == 0 {
+		return false, fmt.Errorf("%s must be a nonempty array", operator)
+	}
+	for _, clause := range clauses {
+		subFilter, ok := clause.(map[string]any)
+		if !ok {
+			return false, fmt.Errorf("%s entries must be documents", operator)
+		}
+		matched, err := matchDocument(doc, subFilter)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case operator == "$and" && !matched:
+			return false, nil
+		case operator == "$or" && matched:
+			return true, nil
+		case operator == "$nor" && matched:
+			return false, nil
+		}
+	}
+	return operator != "$or", nil
+}
+
+func matchField(value any, found bool, cond any) (bool, error) {
+	operators, ok := cond.(map[string]any)
+	if !ok || !isOperatorDocument(operators) {
+		return matchEqual(value, found, cond), nil
+	}
+	for operator, operand := range operators {
+		var matched bool
+		switch operator {
+		case "$eq":
+			matched = matchEqual(value, found, operand)
+		case "$ne":
+			matched = !matchEqual(value, found, operand)
+		case "$gt", "$gte", "$lt", "$lte":
+			matched = matchCompare(value, found, operator, operand)
+		case "$in", "$nin":
+			candidates, ok := operand.(bson.A)
+			if !ok {
+				return false, fmt.Errorf("%s needs an array", operator)
+			}
+			for _, candidate := range candidates {
+				if matchEqual(value, found, candidate) {
+					matched = true
+					break
+				}
+			}
+			if operator == "$nin" {
+				matched = !matched
+			}
+		case "$exists":
+			exists, ok := operand.(bool)
+			if !ok {
+				return false, errors.New("$exists needs a boolean")
+			}
+			matched = found == exists
+		default:
+			return false, fmt.Errorf("unsupported operator: %s", operator)
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// matchEqual follows MongoDB equality semantics: a missing field equals null and
+// an array field matches if the array itself or any of its elements is equal
+func matchEqual(value any, found bool, operand any) bool {
+	if !found {
+		return operand == nil
+	}
+	if valuesEqual(value, operand) {
+		return true
+	}
+	if array, ok := value.(bson.A); ok {
+		for _, element := range array {
+			if valuesEqual(element, operand) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchCompare(value any, found bool, operator string, operand any) bool {
+	if !found {
+		return false
+	}
+	if array, ok := value.(bson.A); ok {
+		for _, element := range array {
+			if matchCompare(element, true, operator, operand) {
+				return true
+			}
+		}
+		return false
+	}
+	result, ok := compareValues(value, operand)
+	if !ok {
+		return false
+	}
+	switch operator {
+	case "$gt":
+		return result > 0
+	case "$gte":
+		return result >= 0
+	case "$lt":
+		return result < 0
+	default:
+		return result <= 0
+	}
+}
+
+func valuesEqual(a, b any) bool {
+	if result, ok := compareValues(a, b); ok {
+		return result == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compareValues orders two values of the same BSON type class. It returns false
+// if the values cannot be ordered against each other.
+func compareValues(a, b any) (int, bool) {
+	if x, ok := toFloat(a); ok {
+		if y, ok := toFloat(b); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			default:
+				return 0, true
+			}
+		}
+		return 0, false
+	}
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case bson.DateTime:
+		if y, ok := b.(bson.DateTime); ok {
+			return compareInt64(int64(x), int64(y)), true
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return x.Compare(y), true
+		}
+	case bson.ObjectID:
+		if y, ok := b.(bson.ObjectID); ok {
+			return bytes.Compare(x[:], y[:]), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0, true
+			case !x:
+				return -1, true
+			default:
+				return 1, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func compareInt64(x, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
+
+// NewIDPool creates the pool name handing out the IDs in [minimum, maximum), or opens it
+// if it exists with the same range
+func (m *MemoryDB) NewIDPool(ctx context.Context, name string, minimum, maximum int64) (*IDPool, error) {
+	return newIDPool(ctx, m, name, minimum, maximum)
+}
+
+// OpenIDPool opens the existing pool name
+func (m *MemoryDB) OpenIDPool(ctx context.Context, name string) (*IDPool, error) {
+	return openIDPool(ctx, m, name)
+}
+
+func (m *MemoryDB) createIDPoolConfig(ctx context.Context, cfg IDPoolConfig) (IDPoolConfig, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	pool, ok := m.idPools[cfg.Name]
+	if !ok {
+		pool = newMemIDPool()
+		m.idPools[cfg.Name] = pool
+	}
+	if !pool.configured {
+		pool.config, pool.configured = cfg, true
+	}
+	return pool.config, nil
+}
+
+func newMemIDPool() *memIDPool {
+	return &memIDPool{blocks: make(map[int64][]uint64), free: make(map[int64]int64)}
+}
+
+func (m *MemoryDB) loadIDPoolConfig(ctx context.Context, name string) (IDPoolConfig, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	pool, ok := m.idPools[name]
+	if !ok || !pool.configured {
+		return IDPoolConfig{}, false, nil
+	}
+	return pool.config, true, nil
+}
+
+func (m *MemoryDB) loadIDPoolCursor(ctx context.Context, name string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	pool, ok := m.idPools[name]
+	if !ok {
+		return 0, ErrPoolNotFound
+	}
+	return pool.cursor, nil
+}
+
+func (m *MemoryDB) storeIDPoolCursor(ctx context.Context, name string, cursor int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	pool, ok := m.idPools[name]
+	if !ok {
+		return ErrPoolNotFound
+	}
+	pool.cursor = cursor
+	return nil
+}
+
+func (m *MemoryDB) loadIDBlock(ctx context.Context, cfg IDPoolConfig, block int64) ([]uint64, int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	pool, ok := m.idPools[cfg.Name]
+	if !ok {
+		return nil, 0, ErrPoolNotFound
+	}
+	words := make([]uint64, idBlockWords)
+	if stored, ok := pool.blocks[block]; ok {
+		copy(words, stored)
+		return words, pool.free[block], nil
+	}
+	return words, min(idBlockSize, cfg.size()-block*idBlockSize), nil
+}
+
+func (m *MemoryDB) updateIDBit(ctx context.Context, cfg IDPoolConfig, block int64, word int, bit int, set bool) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	pool, ok := m.idPools[cfg.Name]
+	if !ok {
+		pool = newMemIDPool()
+		m.idPools[cfg.Name] = pool
+	}
+	words, ok := pool.blocks[block]
+	if !ok {
+		words = make([]uint64, idBlockWords)
+		pool.blocks[block] = words
+		pool.free[block] = min(idBlockSize, cfg.size()-block*idBlockSize)
+	}
+	mask := uint64(1) << bit
+	if (words[word]&mask != 0) == set {
+		return false, nil
+	}
+	if set {
+		words[word] |= mask
+		pool.free[block]--
+	} else {
+		words[word] &^= mask
+		pool.free[block]++
+	}
+	return true, nil
+}
+
+func (m *MemoryDB) deleteIDPool(ctx context.Context, name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.idPools, name)
+	return nil
+}
+
+// observe does nothing, a MemoryDB has no instrumentation
+func (m *MemoryDB) observe(operation, collName string, start time.Time, result any, err *error) {}
+
+// chunkPool returns the chunk pool name, creating it if it does not exist
+func (m *MemoryDB) chunkPool(name string) *memChunkPool {
+	pool, ok := m.chunkPools[name]
+	if !ok {
+		pool = &memChunkPool{leases: make(map[int32]ChunkLease)}
+		m.chunkPools[name] = pool
+	}
+	return pool
+}
+
+func (m *MemoryDB) initChunkPool(ctx context.Context, name string, cfg chunkPoolConfig) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	pool := m.chunkPool(name)
+	pool.config, pool.configured = cfg, true
+	return nil
+}
+
+func (m *MemoryDB) loadChunkPoolConfig(ctx context.Context, name string) (chunkPoolConfig, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	pool, ok := m.chunkPools[name]
+	if !ok || !pool.configured {
+		return chunkPoolConfig{}, false, nil
+	}
+	return pool.config, true, nil
+}
+
+func (m *MemoryDB) insertChunkLease(ctx context.Context, pool string, lease ChunkLease) (ChunkLease, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	leases := m.chunkPool(pool).leases
+	if existing, ok := leases[lease.Chunk]; ok {
+		return existing, false, nil
+	}
+	leases[lease.Chunk] = lease
+	return lease, true, nil
+}
+
+func (m *MemoryDB) replaceChunkLease(ctx context.Context, pool string, existing ChunkLease, lease ChunkLease) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	leases := m.chunkPool(pool).leases
+	stored, ok := leases[existing.Chunk]
+	if !ok || stored.Owner != existing.Owner || !stored.ExpireAt.Equal(existing.ExpireAt) {
+		return false, nil
+	}
+	leases[existing.Chunk] = lease
+	return true, nil
+}
+
+func (m *MemoryDB) loadActiveChunks(ctx context.Context, pool string, now time.Time) ([]int32, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var chunks []int32
+	for chunk, lease := range m.chunkPool(pool).leases {
+		if lease.ExpireAt.IsZero() || !lease.ExpireAt.Before(now) {
+			chunks = append(chunks, chunk)
+		}
+	}
+	return chunks, nil
+}
+
+func (m *MemoryDB) renewChunkLease(ctx context.Context, pool string, chunk int32, owner string, expireAt time.Time) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	leases := m.chunkPool(pool).leases
+	lease, ok := leases[chunk]
+	if !ok || lease.Owner != owner {
+		return false, nil
+	}
+	lease.ExpireAt = expireAt
+	leases[chunk] = lease
+	return true, nil
+}
+
+func (m *MemoryDB) deleteChunkLease(ctx context.Context, pool string, chunk int32, owner string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	leases := m.chunkPool(pool).leases
+	if lease, ok := leases[chunk]; !ok || lease.Owner != owner {
+		return false, nil
+	}
+	delete(leases, chunk)
+	return true, nil
+}
+
+func (m *MemoryDB) loadExpiredChunkLeases(ctx context.Context, pool string, now time.Time) ([]ChunkLease, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var leases []ChunkLease
+	for _, lease := range m.chunkPool(pool).leases {
+		if !lease.ExpireAt.IsZero() && lease.ExpireAt.Before(now) {
+			leases = append(leases, lease)
+		}
+	}
+	slices.SortFunc(leases, func(a, b ChunkLease) int { return cmp.Compare(a.Chunk, b.Chunk) })
+	return leases, nil
+}
+
+func (m *MemoryDB) deleteExpiredChunkLeases(ctx context.Context, pool string, now time.Time) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var released int64
+	leases := m.chunkPool(pool).leases
+	for chunk, lease := range leases {
+		if !lease.ExpireAt.IsZero() && lease.ExpireAt.Before(now) {
+			delete(leases, chunk)
+			released++
+		}
+	}
+	return released, nil
+}
+
+// Find returns an iterator over the documents of collName matching filter. The
+// matching documents are copied when Find is called.
+func (m *MemoryDB) Find(ctx context.Context, collName string, filter bson.M, opts *QueryOptions) (*DocumentIterator, error) {
+	if opts == nil {
+		opts = &QueryOptions{}
+	}
+	query, err := pageFilter(filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("Find err: %w", classifyError(err))
+	}
+
+	m.mutex.Lock()
+	coll := m.collection(collName)
+	indexes, err := coll.find(query)
+	docs := make([]map[string]any, 0, len(indexes))
+	for _, i := range indexes {
+		if err != nil {
+			break
+		}
+		var doc map[string]any
+		doc, err = decodeDocument(coll.docs[i])
+		docs = append(docs, doc)
+	}
+	m.mutex.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("Find err: %w", classifyError(err))
+	}
+
+	keys := sortKeys(opts.Sort)
+	slices.SortStableFunc(docs, func(a, b map[string]any) int {
+		for _, key := range keys {
+			result := compareSortValues(a, b, key.Field)
+			if key.Descending {
+				result = -result
+			}
+			if result != 0 {
+				return result
+			}
+		}
+		return 0
+	})
+	if opts.Limit > 0 && int64(len(docs)) > opts.Limit {
+		docs = docs[:opts.Limit]
+	}
+
+	return &DocumentIterator{
+		next: func(ctx context.Context) (map[string]any, error) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if len(docs) == 0 {
+				return nil, nil
+			}
+			doc := docs[0]
+			docs = docs[1:]
+			return doc, nil
+		},
+		opts: *opts,
+	}, nil
+}
+
+// FindPage returns a page of the documents of collName matching filter
+func (m *MemoryDB) FindPage(ctx context.Context, collName string, filter bson.M, opts *QueryOptions) (*Page, error) {
+	it, err := m.Find(ctx, collName, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	page, err := collectPage(ctx, it)
+	if err != nil {
+		return nil, fmt.Errorf("FindPage err: %w", classifyError(err))
+	}
+	return page, nil
+}
+
+// FindEach calls fn for each document of collName matching filter, and returns the
+// token of the next page. Iteration stops at the first error returned by fn.
+func (m *MemoryDB) FindEach(ctx context.Context, collName string, filter bson.M, opts *QueryOptions,
+	fn func(doc map[string]any) error,
+) (string, error) {
+	it, err := m.Find(ctx, collName, filter, opts)
+	if err != nil {
+		return "", err
+	}
+	token, err := forEachDocument(ctx, it, fn)
+	if err != nil {
+		return "", fmt.Errorf("FindEach err: %w", classifyError(err))
+	}
+	return token, nil
+}
+
+// compareSortValues orders two documents by field, null and missing values sort first
+func compareSortValues(a, b map[string]any, field string) int {
+	x, foundX := lookupPath(a, field)
+	y, foundY := lookupPath(b, field)
+	foundX, foundY = foundX && x != nil, foundY && y != nil
+	switch {
+	case !foundX && !foundY:
+		return 0
+	case !foundX:
+		return -1
+	case !foundY:
+		return 1
+	}
+	result, _ := compareValues(x, y)
+	return result
+}
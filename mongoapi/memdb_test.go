@@ -0,0 +1,236 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func TestMemoryDBPutGet(t *testing.T) {
+	db := NewMemoryDB()
+
+	existed, err := db.RestfulAPIPutOne("subs", bson.M{"ueId": "imsi-1"}, map[string]any{"plmn": "00101", "sqn": 5})
+	if err != nil || existed {
+		t.Fatalf("RestfulAPIPutOne() = %v, %v, expected false, nil", existed, err)
+	}
+	existed, err = db.RestfulAPIPutOne("subs", bson.M{"ueId": "imsi-1"}, map[string]any{"sqn": 6})
+	if err != nil || !existed {
+		t.Fatalf("RestfulAPIPutOne() = %v, %v, expected true, nil", existed, err)
+	}
+
+	result, err := db.RestfulAPIGetOne("subs", bson.M{"ueId": "imsi-1"})
+	if err != nil {
+		t.Fatalf("RestfulAPIGetOne() failed: %v", err)
+	}
+	if result["ueId"] != "imsi-1" || result["plmn"] != "00101" || result["sqn"] != int32(6) {
+		t.Errorf("RestfulAPIGetOne() returned unexpected document %v", result)
+	}
+	if _, ok := result["_id"]; ok {
+		t.Error("RestfulAPIGetOne() should strip _id")
+	}
+	if _, ok := result[VersionField]; ok {
+		t.Errorf("RestfulAPIGetOne() should strip %s", VersionField)
+	}
+	if version, _ := db.collection("subs").docs[0].Lookup(VersionField).AsInt64OK(); version != 2 {
+		t.Errorf("%s of a document written twice = %d, expected 2", VersionField, version)
+	}
+
+	result, err = db.RestfulAPIGetOne("subs", bson.M{"ueId": "imsi-2"})
+	if err != nil || result != nil {
+		t.Errorf("RestfulAPIGetOne() = %v, %v, expected nil, nil", result, err)
+	}
+}
+
+func TestMemoryDBFilterOperators(t *testing.T) {
+	db := NewMemoryDB()
+	docs := []any{
+		bson.M{"name": "a", "n": 1, "tags": bson.A{"x", "y"}, "sub": bson.M{"k": "v"}},
+		bson.M{"name": "b", "n": 2, "tags": bson.A{"y"}},
+		bson.M{"name": "c", "n": int64(3)},
+	}
+	if err := db.RestfulAPIPostMany("coll", nil, docs); err != nil {
+		t.Fatalf("RestfulAPIPostMany() failed: %v", err)
+	}
+
+	testCases := []struct {
+		filter   bson.M
+		expected int
+	}{
+		{bson.M{}, 3},
+		{bson.M{"n": 3}, 1},
+		{bson.M{"n": bson.M{"$gt": 1}}, 2},
+		{bson.M{"n": bson.M{"$gte": 1, "$lt": 3}}, 2},
+		{bson.M{"n": bson.M{"$ne": 2}}, 2},
+		{bson.M{"name": bson.M{"$in": bson.A{"a", "c"}}}, 2},
+		{bson.M{"name": bson.M{"$nin": bson.A{"a", "c"}}}, 1},
+		{bson.M{"tags": "y"}, 2},
+		{bson.M{"tags": bson.M{"$exists": false}}, 1},
+		{bson.M{"sub.k": "v"}, 1},
+		{bson.M{"$or": bson.A{bson.M{"name": "a"}, bson.M{"n": 2}}}, 2},
+		{bson.M{"$and": bson.A{bson.M{"name": "a"}, bson.M{"n": 2}}}, 0},
+		{bson.M{"$nor": bson.A{bson.M{"name": "a"}}}, 2},
+	}
+	for _, tc := range testCases {
+		results, err := db.RestfulAPIGetMany("coll", tc.filter)
+		if err != nil {
+			t.Errorf("RestfulAPIGetMany(%v) failed: %v", tc.filter, err)
+			continue
+		}
+		if len(results) != tc.expected {
+			t.Errorf("RestfulAPIGetMany(%v) returned %d documents, expected %d", tc.filter, len(results), tc.expected)
+		}
+	}
+
+	if _, err := db.RestfulAPIGetMany("coll", bson.M{"n": bson.M{"$regex": "a"}}); err == nil {
+		t.Error("RestfulAPIGetMany() should fail for an unsupported operator")
+	}
+}
+
+func TestMemoryDBDelete(t *testing.T) {
+	db := NewMemoryDB()
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := db.RestfulAPIPost("coll", bson.M{"name": name}, map[string]any{"group": 1}); err != nil {
+			t.Fatalf("RestfulAPIPost() failed: %v", err)
+		}
+	}
+	if err := db.RestfulAPIDeleteOneWithContext(context.Background(), "coll", bson.M{"name": "a"}); err != nil {
+		t.Fatalf("RestfulAPIDeleteOne() failed: %v", err)
+	}
+	if results, _ := db.RestfulAPIGetMany("coll", bson.M{}); len(results) != 2 {
+		t.Errorf("expected 2 documents after RestfulAPIDeleteOne(), got %d", len(results))
+	}
+	if err := db.RestfulAPIDeleteMany("coll", bson.M{"group": 1}); err != nil {
+		t.Fatalf("RestfulAPIDeleteMany() failed: %v", err)
+	}
+	if results, _ := db.RestfulAPIGetMany("coll", bson.M{}); len(results) != 0 {
+		t.Errorf("expected no documents after RestfulAPIDeleteMany(), got %d", len(results))
+	}
+}
+
+func TestMemoryDBPatch(t *testing.T) {
+	db := NewMemoryDB()
+	filter := bson.M{"ueId": "imsi-1"}
+	if _, err := db.RestfulAPIPutOne("subs", filter, map[string]any{
+		"nssai": map[string]any{"sst": 1, "sd": "010203"},
+		"ambr":  "1 Gbps",
+	}); err != nil {
+		t.Fatalf("RestfulAPIPutOne() failed: %v", err)
+	}
+
+	if err := db.RestfulAPIMergePatch("subs", filter, map[string]any{"ambr": "2 Gbps"}); err != nil {
+		t.Fatalf("RestfulAPIMergePatch() failed: %v", err)
+	}
+	patch := []byte(`[{"op": "replace", "path": "/nssai/sst", "value": 2}]`)
+	if err := db.RestfulAPIJSONPatch("subs", filter, patch); err != nil {
+		t.Fatalf("RestfulAPIJSONPatch() failed: %v", err)
+	}
+	patch = []byte(`[{"op": "add", "path": "/sd", "value": "aabbcc"}]`)
+	if err := db.RestfulAPIJSONPatchExtend("subs", filter, patch, "nssai"); err != nil {
+		t.Fatalf("RestfulAPIJSONPatchExtend() failed: %v", err)
+	}
+	patch = []byte(`[{"op": "test", "path": "/ambr", "value": "1 Gbps"}]`)
+	if err := db.RestfulAPIJSONPatch("subs", filter, patch); err == nil {
+		t.Error("RestfulAPIJSONPatch() should fail when a test operation fails")
+	}
+
+	result, _ := db.RestfulAPIGetOne("subs", filter)
+	nssai, _ := result["nssai"].(map[string]any)
+	if result["ambr"] != "2 Gbps" || nssai["sst"] != float64(2) || nssai["sd"] != "aabbcc" {
+		t.Errorf("unexpected document after patches: %v", result)
+	}
+
+	patch = []byte(`[{"op": "remove", "path": "/ambr"}]`)
+	if err := db.RestfulAPIJSONPatch("subs", filter, patch); err != nil {
+		t.Fatalf("RestfulAPIJSONPatch() failed: %v", err)
+	}
+	result, _ = db.RestfulAPIGetOne("subs", filter)
+	if _, ok := result["ambr"]; ok || result["ueId"] != "imsi-1" {
+		t.Errorf("RestfulAPIJSONPatch() remove did not remove the field: %v", result)
+	}
+}
+
+func TestMemoryDBUniqueIndex(t *testing.T) {
+	db := NewMemoryDB()
+	if _, err := db.CreateIndex("subs", "ueId"); err != nil {
+		t.Fatalf("CreateIndex() failed: %v", err)
+	}
+	if _, err := db.RestfulAPIPutOneNotUpdate("subs", bson.M{"ueId": "imsi-1"}, map[string]any{"ueId": "imsi-1"}); err != nil {
+		t.Fatalf("RestfulAPIPutOneNotUpdate() failed: %v", err)
+	}
+	err := db.RestfulAPIPostMany("subs", nil, []any{bson.M{"ueId": "imsi-1"}})
+	if !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("expected duplicate key error, got %v", err)
+	}
+
+	if err := db.RestfulAPIPostMany("other", nil, []any{bson.M{"k": 1}, bson.M{"k": 1}}); err != nil {
+		t.Fatalf("RestfulAPIPostMany() failed: %v", err)
+	}
+	if _, err := db.CreateIndex("other", "k"); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("CreateIndex() on duplicate data: expected duplicate key error, got %v", err)
+	}
+}
+
+func TestMemoryDBGetUniqueIdentity(t *testing.T) {
+	db := NewMemoryDB()
+	for expected := int32(1); expected <= 3; expected++ {
+		if id := db.GetUniqueIdentity("amfUeNgapId"); id != expected {
+			t.Errorf("GetUniqueIdentity() = %d, expected %d", id, expected)
+		}
+	}
+	if id := db.GetUniqueIdentity("other"); id != 1 {
+		t.Errorf("GetUniqueIdentity() for a new counter = %d, expected 1", id)
+	}
+
+	// a counter collection that cannot be read must not be reset
+	counters := db.collection("counter")
+	counters.docs = append(counters.docs, bson.Raw("corrupt"))
+	if id, err := db.GetUniqueIdentityWithError("amfUeNgapId"); err == nil {
+		t.Errorf("GetUniqueIdentityWithError() = %d, expected an error", id)
+	}
+	if len(counters.docs) != 3 {
+		t.Errorf("GetUniqueIdentityWithError() should not create a counter after an error, got %d documents", len(counters.docs))
+	}
+}
+
+func TestMemoryDBAsCommonDBClient(t *testing.T) {
+	saved := CommonDBClient
+	defer func() { CommonDBClient = saved }()
+
+	CommonDBClient = NewMemoryDB()
+	if _, err := CommonDBClient.RestfulAPIPutOne("coll", bson.M{"k": "v"}, map[string]any{"x": 1}); err != nil {
+		t.Fatalf("RestfulAPIPutOne() failed: %v", err)
+	}
+	if supported, err := CommonDBClient.SupportsTransactions(); supported || err != nil {
+		t.Errorf("SupportsTransactions() = %v, %v, expected false, nil", supported, err)
+	}
+}
+
+func TestMemoryDBWithTransaction(t *testing.T) {
+	db := NewMemoryDB()
+	run := func(txCtx context.Context) error {
+		_, err := db.RestfulAPIPutOneWithContext(txCtx, "subs", bson.M{"ueId": "imsi-1"}, map[string]any{"n": 1})
+		return err
+	}
+
+	if err := db.WithTransaction(context.Background(), run); !errors.Is(err, ErrTransactionsNotSupported) {
+		t.Errorf("WithTransaction() = %v, expected ErrTransactionsNotSupported", err)
+	}
+	if doc, _ := db.RestfulAPIGetOne("subs", bson.M{"ueId": "imsi-1"}); doc != nil {
+		t.Error("WithTransaction() should not run the function when transactions are required")
+	}
+
+	db.SetTransactionPolicy(TransactionBestEffort)
+	if err := db.WithTransaction(context.Background(), run); err != nil {
+		t.Errorf("WithTransaction() failed: %v", err)
+	}
+	if doc, _ := db.RestfulAPIGetOne("subs", bson.M{"ueId": "imsi-1"}); doc == nil {
+		t.Error("WithTransaction() should run the function without a transaction")
+	}
+}
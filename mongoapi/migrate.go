@@ -0,0 +1,398 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/omec-project/util/logger"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	// migrationCollection stores one record per applied migration
+	migrationCollection = "migrations"
+	// migrationLockCollection stores the locks that serialize migration runs
+	migrationLockCollection = "migrationLocks"
+	defaultMigrationLockTTL = 30 * time.Second
+	migrationLockRetry      = time.Second
+)
+
+// ErrMigrationLockLost is returned by Migrator.Run when the migration lock could not be
+// renewed, since another instance may have taken it over
+var ErrMigrationLockLost = errors.New("migration lock lost")
+
+// MigrationFunc applies a migration to the database
+type MigrationFunc func(ctx context.Context, db *mongo.Database) error
+
+// Migration is a versioned change of the indexes or documents of a component. Versions
+// are applied in ascending order, and each version is applied only once.
+type Migration struct {
+	Version     int
+	Description string
+	Up          MigrationFunc
+}
+
+type migrationRecord struct {
+	ID          string    `bson:"_id"`
+	Component   string    `bson:"component"`
+	Version     int       `bson:"version"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"appliedAt"`
+}
+
+// Migrator applies the migrations registered by a component. Concurrent instances of
+// the component serialize on a lock in the database, so every migration is applied
+// by exactly one of them.
+type Migrator struct {
+	client    *MongoClient
+	component string
+	owner     string
+	// LockTTL is how long the lock is held without renewal, so the lock of a crashed
+	// instance is taken over after at most LockTTL. 30 seconds by default.
+	LockTTL    time.Duration
+	migrations []Migration
+}
+
+// NewMigrator creates a Migrator for the migrations of component. The owner identifies
+// the instance running the migrations and must be unique among the instances.
+func (c *MongoClient) NewMigrator(component string, owner string) *Migrator {
+	return &Migrator{client: c, component: component, owner: owner}
+}
+
+// Register adds migrations to the migrator
+func (m *Migrator) Register(migrations ...Migration) {
+	m.migrations = append(m.migrations, migrations...)
+}
+
+// Run applies the registered migrations that have not been applied yet, in order of
+// their version. It waits for the lock while another instance runs the migrations, and
+// stops at the first failing migration, which is retried by the next Run. The context
+// passed to the migrations is canceled if the lock is lost, and Run then fails with
+// ErrMigrationLockLost without recording the running migration.
+func (m *Migrator) Run(ctx context.Context) (err error) {
+	defer m.client.observe("MigratorRun", migrationCollection, time.Now(), nil, &err)
+	if _, err := sortMigrations(m.migrations); err != nil {
+		return fmt.Errorf("Migrator Run err: %w", err)
+	}
+	ctx, release, err := m.lock(ctx)
+	if err != nil {
+		return fmt.Errorf("Migrator Run lock err: %w", err)
+	}
+	defer release()
+
+	db := m.client.Client.Database(m.client.dbName)
+	applied, err := m.appliedVersions(ctx, db)
+	if err != nil {
+		return fmt.Errorf("Migrator Run err: %w", classifyError(err))
+	}
+	pending, err := pendingMigrations(m.migrations, applied)
+	if err != nil {
+		return fmt.Errorf("Migrator Run err: %w", err)
+	}
+	return applyMigrations(ctx, db, m.component, pending, func(ctx context.Context, record migrationRecord) error {
+		_, err := db.Collection(migrationCollection).InsertOne(ctx, record)
+		return err
+	})
+}
+
+// applyMigrations applies pending in order and records each applied migration. A
+// migration is not recorded once ctx is done, since the lock may be held by another
+// instance by then.
+func applyMigrations(ctx context.Context, db *mongo.Database, component string, pending []Migration,
+	record func(ctx context.Context, record migrationRecord) error,
+) error {
+	for _, migration := range pending {
+		logger.MongoapiLog.Infof("applying migration %d of %s: %s", migration.Version, component, migration.Description)
+		err := migration.Up(ctx, db)
+		if ctx.Err() != nil {
+			return fmt.Errorf("Migrator Run migration %d err: %w", migration.Version, context.Cause(ctx))
+		}
+		if err != nil {
+			return fmt.Errorf("Migrator Run migration %d err: %w", migration.Version, classifyError(err))
+		}
+		applied := migrationRecord{
+			ID:          fmt.Sprintf("%s/%d", component, migration.Version),
+			Component:   component,
+			Version:     migration.Version,
+			Description: migration.Description,
+			AppliedAt:   time.Now(),
+		}
+		if err := record(ctx, applied); err != nil {
+			return fmt.Errorf("Migrator Run record %d err: %w", migration.Version, classifyError(err))
+		}
+	}
+	return nil
+}
+
+// AppliedVersions returns the versions of the migrations of the component that have been applied
+func (m *Migrator) AppliedVersions(ctx context.Context) ([]int, error) {
+	applied, err := m.appliedVersions(ctx, m.client.Client.Database(m.client.dbName))
+	if err != nil {
+		return nil, fmt.Errorf("Migrator AppliedVersions err: %w", classifyError(err))
+	}
+	versions := make([]int, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	slices.Sort(versions)
+	return versions, nil
+}
+
+func (m *Migrator) appliedVersions(ctx context.Context, db *mongo.Database) (map[int]bool, error) {
+	cursor, err := db.Collection(migrationCollection).Find(ctx, bson.M{"component": m.component})
+	if err != nil {
+		return nil, err
+	}
+	var records []migrationRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]bool, len(records))
+	for _, record := range records {
+		applied[record.Version] = true
+	}
+	return applied, nil
+}
+
+// lock acquires the migration lock of the component and keeps renewing it until the
+// returned function is called. The returned context is derived from ctx and canceled
+// when the lock is lost.
+func (m *Migrator) lock(ctx context.Context) (context.Context, func(), error) {
+	ttl := m.LockTTL
+	if ttl <= 0 {
+		ttl = defaultMigrationLockTTL
+	}
+	locks := m.client.Client.Database(m.client.dbName).Collection(migrationLockCollection)
+	acquire := func(ctx context.Context) (bool, error) {
+		// the upsert fails with a duplicate key error if another owner holds the lock
+		now := time.Now()
+		update := bson.M{"$set": bson.M{"owner": m.owner, "expireAt": now.Add(ttl)}}
+		_, err := locks.UpdateOne(ctx, migrationLockFilter(m.component, m.owner, now), update,
+			options.UpdateOne().SetUpsert(true))
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	for {
+		acquired, err := acquire(ctx)
+		if err != nil {
+			return nil, nil, classifyError(err)
+		}
+		if acquired {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(migrationLockRetry):
+		}
+	}
+
+	lockCtx, stop := holdMigrationLock(ctx, m.component, ttl/3, acquire)
+	return lockCtx, func() {
+		stop()
+		if _, err := locks.DeleteOne(context.Background(), bson.M{"_id": m.component, "owner": m.owner}); err != nil {
+			logger.MongoapiLog.Warnf("failed to release migration lock of %s: %v", m.component, err)
+		}
+	}, nil
+}
+
+// migrationLockFilter matches the lock of component if it is held by owner or has
+// expired at now. A lock that does not exist is not matched and is created by the upsert.
+func migrationLockFilter(component, owner string, now time.Time) bson.M {
+	return bson.M{"_id": component, "$or": bson.A{
+		bson.M{"owner": owner},
+		bson.M{"expireAt": bson.M{"$lt": now}},
+	}}
+}
+
+// holdMigrationLock renews the lock of component with acquire every interval until the
+// returned function is called. The returned context is derived from ctx and canceled
+// with ErrMigrationLockLost as soon as a renewal fails or finds the lock taken over.
+func holdMigrationLock(ctx context.Context, component string, interval time.Duration,
+	acquire func(ctx context.Context) (bool, error),
+) (context.Context, func()) {
+	lockCtx, lost := context.WithCancelCause(ctx)
+	renewCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-renewCtx.Done():
+				return
+			case <-ticker.C:
+				acquired, err := acquire(renewCtx)
+				if renewCtx.Err() != nil {
+					return
+				}
+				if err != nil {
+					logger.MongoapiLog.Warnf("failed to renew migration lock of %s: %v", component, err)
+					lost(fmt.Errorf("%w: %w", ErrMigrationLockLost, classifyError(err)))
+					return
+				}
+				if !acquired {
+					logger.MongoapiLog.Warnf("migration lock of %s was taken over", component)
+					lost(ErrMigrationLockLost)
+					return
+				}
+			}
+		}
+	}()
+	return lockCtx, func() {
+		cancel()
+		<-done
+		lost(nil)
+	}
+}
+
+// sortMigrations returns migrations ordered by version and rejects invalid or duplicate versions
+func sortMigrations(migrations []Migration) ([]Migration, error) {
+	sorted := slices.Clone(migrations)
+	slices.SortStableFunc(sorted, func(a, b Migration) int {
+		return a.Version - b.Version
+	})
+	for i, migration := range sorted {
+		if migration.Version <= 0 {
+			return nil, fmt.Errorf("invalid migration version %d", migration.Version)
+		}
+		if migration.Up == nil {
+			return nil, fmt.Errorf("migration %d has no Up function", migration.Version)
+		}
+		if i > 0 && sorted[i-1].Version == migration.Version {
+			return nil, fmt.Errorf("duplicate migration version %d", migration.Version)
+		}
+	}
+	return sorted, nil
+}
+
+// pendingMigrations returns the migrations that have not been applied, in order of their version
+func pendingMigrations(migrations []Migration, applied map[int]bool) ([]Migration, error) {
+	sorted, err := sortMigrations(migrations)
+	if err != nil {
+		return nil, err
+	}
+	pending := sorted[:0]
+	for _, migration := range sorted {
+		if !applied[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// CreateIndexMigration returns a migration step that creates index on collName
+func CreateIndexMigration(collName string, index mongo.IndexModel) MigrationFunc {
+	return func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection(collName).Indexes().CreateOne(ctx, index)
+		return err
+	}
+}
+
+// DropIndexMigration returns a migration step that drops the index on field of collName,
+// if there is one
+func DropIndexMigration(collName string, field string) MigrationFunc {
+	return func(ctx context.Context, db *mongo.Database) error {
+		collection := db.Collection(collName)
+		name, found, err := findIndexOnField(ctx, collection, field)
+		if err != nil || !found {
+			return err
+		}
+		return collection.Indexes().DropOne(ctx, name)
+	}
+}
+
+// SetTTLMigration returns a migration step that makes documents of collName expire ttl
+// after the time in field. An existing index on field is modified in place, otherwise
+// the index is created.
+func SetTTLMigration(collName string, field string, ttl time.Duration) MigrationFunc {
+	return func(ctx context.Context, db *mongo.Database) error {
+		collection := db.Collection(collName)
+		_, found, err := findIndexOnField(ctx, collection, field)
+		if err != nil {
+			return err
+		}
+		seconds := int32(ttl / time.Second)
+		if !found {
+			index := mongo.IndexModel{
+				Keys:    bson.D{{Key: field, Value: 1}},
+				Options: options.Index().SetExpireAfterSeconds(seconds).SetName(field),
+			}
+			_, err := collection.Indexes().CreateOne(ctx, index)
+			return err
+		}
+		command := bson.D{
+			{Key: "collMod", Value: collName},
+			{Key: "index", Value: bson.D{
+				{Key: "keyPattern", Value: bson.D{{Key: field, Value: 1}}},
+				{Key: "expireAfterSeconds", Value: seconds},
+			}},
+		}
+		return db.RunCommand(ctx, command).Err()
+	}
+}
+
+// TransformMigration returns a migration step that replaces every document of collName
+// matching filter by the result of transform. The _id of a document is preserved and its
+// VersionField is incremented.
+func TransformMigration(collName string, filter bson.M, transform func(doc map[string]any) (map[string]any, error)) MigrationFunc {
+	return func(ctx context.Context, db *mongo.Database) error {
+		collection := db.Collection(collName)
+		cursor, err := collection.Find(ctx, filter)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close(ctx)
+		for cursor.Next(ctx) {
+			var doc map[string]any
+			if err := cursor.Decode(&doc); err != nil {
+				return err
+			}
+			id, version := doc["_id"], doc[VersionField]
+			result, err := transform(doc)
+			if err != nil {
+				return fmt.Errorf("transform of %v err: %w", id, err)
+			}
+			result["_id"] = id
+			result[VersionField] = nextVersion(version)
+			if _, err := collection.ReplaceOne(ctx, bson.M{"_id": id}, result); err != nil {
+				return err
+			}
+		}
+		return cursor.Err()
+	}
+}
+
+// findIndexOnField returns the name of the single field index on field, if there is one
+func findIndexOnField(ctx context.Context, collection *mongo.Collection, field string) (string, bool, error) {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	var indexes []struct {
+		Name string `bson:"name"`
+		Key  bson.D `bson:"key"`
+	}
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return "", false, err
+	}
+	for _, index := range indexes {
+		if len(index.Key) == 1 && index.Key[0].Key == field {
+			return index.Name, true, nil
+		}
+	}
+	return "", false, nil
+}
@@ -0,0 +1,139 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func TestPendingMigrations(t *testing.T) {
+	up := func(ctx context.Context, db *mongo.Database) error { return nil }
+	migrations := []Migration{
+		{Version: 3, Description: "ttl", Up: up},
+		{Version: 1, Description: "index", Up: up},
+		{Version: 2, Description: "transform", Up: up},
+	}
+
+	pending, err := pendingMigrations(migrations, map[int]bool{2: true})
+	if err != nil {
+		t.Fatalf("pendingMigrations() failed: %v", err)
+	}
+	if len(pending) != 2 || pending[0].Version != 1 || pending[1].Version != 3 {
+		t.Errorf("pendingMigrations() = %+v, expected versions 1 and 3 in order", pending)
+	}
+	if migrations[0].Version != 3 {
+		t.Error("pendingMigrations() should not reorder the registered migrations")
+	}
+
+	invalid := map[string][]Migration{
+		"duplicate version": {{Version: 1, Up: up}, {Version: 1, Up: up}},
+		"zero version":      {{Version: 0, Up: up}},
+		"missing Up":        {{Version: 1}},
+	}
+	for name, migrations := range invalid {
+		if _, err := pendingMigrations(migrations, nil); err == nil {
+			t.Errorf("pendingMigrations() should reject a %s", name)
+		}
+	}
+}
+
+func TestMigrationLockFilter(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		lock    map[string]any
+		matched bool
+	}{
+		{"renewal by the owner", map[string]any{"_id": "smf", "owner": "a", "expireAt": now.Add(time.Second)}, true},
+		{"held by another owner", map[string]any{"_id": "smf", "owner": "b", "expireAt": now.Add(time.Second)}, false},
+		{"takeover after expiry", map[string]any{"_id": "smf", "owner": "b", "expireAt": now.Add(-time.Second)}, true},
+		{"lock of another component", map[string]any{"_id": "amf", "owner": "a", "expireAt": now.Add(time.Second)}, false},
+	}
+	filter, err := normalizeDocument(migrationLockFilter("smf", "a", now))
+	if err != nil {
+		t.Fatalf("normalizeDocument() failed: %v", err)
+	}
+	for _, tc := range tests {
+		lock, err := normalizeDocument(tc.lock)
+		if err != nil {
+			t.Fatalf("normalizeDocument() failed: %v", err)
+		}
+		if matched, err := matchDocument(lock, filter); err != nil || matched != tc.matched {
+			t.Errorf("%s: matchDocument() = %v, %v, expected %v", tc.name, matched, err, tc.matched)
+		}
+	}
+}
+
+func TestHoldMigrationLock(t *testing.T) {
+	var renewals atomic.Int32
+	ctx, stop := holdMigrationLock(context.Background(), "smf", time.Millisecond,
+		func(ctx context.Context) (bool, error) {
+			return renewals.Add(1) < 3, nil
+		})
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the context should be canceled when the lock is taken over")
+	}
+	if !errors.Is(context.Cause(ctx), ErrMigrationLockLost) {
+		t.Errorf("expected ErrMigrationLockLost as cause, got %v", context.Cause(ctx))
+	}
+	stop()
+	if renewals.Load() != 3 {
+		t.Errorf("expected the renewals to stop once the lock is lost, got %d renewals", renewals.Load())
+	}
+
+	errRenew := errors.New("renewal failed")
+	ctx, stop = holdMigrationLock(context.Background(), "smf", time.Millisecond,
+		func(ctx context.Context) (bool, error) {
+			return false, errRenew
+		})
+	<-ctx.Done()
+	if cause := context.Cause(ctx); !errors.Is(cause, ErrMigrationLockLost) || !errors.Is(cause, errRenew) {
+		t.Errorf("expected ErrMigrationLockLost wrapping the renewal error, got %v", cause)
+	}
+	stop()
+
+	ctx, stop = holdMigrationLock(context.Background(), "smf", time.Millisecond,
+		func(ctx context.Context) (bool, error) {
+			return true, nil
+		})
+	time.Sleep(10 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Errorf("the context should not be canceled while the lock is renewed: %v", context.Cause(ctx))
+	}
+	stop()
+}
+
+func TestApplyMigrations(t *testing.T) {
+	ctx, lose := context.WithCancelCause(context.Background())
+	var recorded []int
+	record := func(ctx context.Context, record migrationRecord) error {
+		recorded = append(recorded, record.Version)
+		return nil
+	}
+	pending := []Migration{
+		{Version: 1, Up: func(ctx context.Context, db *mongo.Database) error { return nil }},
+		{Version: 2, Up: func(ctx context.Context, db *mongo.Database) error {
+			// the lock is lost while the migration runs, which ignores the cancellation
+			lose(ErrMigrationLockLost)
+			return nil
+		}},
+		{Version: 3, Up: func(ctx context.Context, db *mongo.Database) error { return nil }},
+	}
+	err := applyMigrations(ctx, nil, "smf", pending, record)
+	if !errors.Is(err, ErrMigrationLockLost) {
+		t.Errorf("applyMigrations() should fail with ErrMigrationLockLost, got %v", err)
+	}
+	if len(recorded) != 1 || recorded[0] != 1 {
+		t.Errorf("expected only migration 1 to be recorded, got %v", recorded)
+	}
+}
@@ -10,48 +10,63 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math/rand"
-	"os"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/omec-project/util/logger"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 type MongoClient struct {
-	Client *mongo.Client
-	dbName string
-	url    string
-	pools  map[string]map[string]int32
+	Client            *mongo.Client
+	dbName            string
+	url               string
+	transactionPolicy TransactionPolicy
+	fieldEncryption   *FieldEncryption
+	audit             *AuditConfig
+	// instrumentations is replaced as a whole by AddInstrumentation, so observe reads it without locking
+	instrumentations     atomic.Pointer[[]Instrumentation]
+	instrumentationMutex sync.Mutex
+	// transactionSupport caches the result of SupportsTransactions
+	transactionSupport atomic.Pointer[bool]
 }
 
 func NewMongoClient(url string, dbName string) (*MongoClient, error) {
-	c := MongoClient{url: url, dbName: dbName, pools: make(map[string]map[string]int32)}
 	opts := options.Client().
-		ApplyURI(c.url).
+		ApplyURI(url).
 		SetBSONOptions(&options.BSONOptions{
 			DefaultDocumentMap: true,
 		})
+	return newMongoClient(opts, url, dbName, defaultPingTimeout)
+}
+
+func newMongoClient(opts *options.ClientOptions, url string, dbName string, pingTimeout time.Duration) (*MongoClient, error) {
+	c := MongoClient{url: url, dbName: dbName}
 	client, err := mongo.Connect(opts)
 	if err != nil {
-		return nil, fmt.Errorf("MongoClient Creation err: %w", err)
+		return nil, fmt.Errorf("MongoClient Creation err: %w", classifyError(err))
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
 	defer cancel()
 	if err = client.Ping(ctx, nil); err != nil {
 		_ = client.Disconnect(context.Background())
-		return nil, fmt.Errorf("MongoClient Ping err: %w", err)
+		return nil, fmt.Errorf("MongoClient Ping err: %w", classifyError(err))
 	}
 	c.Client = client
 	return &c, nil
 }
 
 func findOneAndDecode(collection *mongo.Collection, filter bson.M) (map[string]any, error) {
+	return findOneAndDecodeWithContext(context.TODO(), collection, filter)
+}
+
+func findOneAndDecodeWithContext(ctx context.Context, collection *mongo.Collection, filter bson.M) (map[string]any, error) {
 	var result map[string]any
-	if err := collection.FindOne(context.TODO(), filter).Decode(&result); err != nil {
+	if err := collection.FindOne(ctx, filter).Decode(&result); err != nil {
 		// ErrNoDocuments means that the filter did not match any documents in
 		// the collection.
 		if err == mongo.ErrNoDocuments {
@@ -70,6 +85,7 @@ func getOrigData(collection *mongo.Collection, filter bson.M) (map[string]any, e
 	if result != nil {
 		// Delete "_id" entry which is auto-inserted by MongoDB
 		delete(result, "_id")
+		delete(result, VersionField)
 	}
 	return result, nil
 }
@@ -90,23 +106,29 @@ func (c *MongoClient) GetCollection(collName string) *mongo.Collection {
 	return collection
 }
 
-func (c *MongoClient) RestfulAPIGetOne(collName string, filter bson.M) (map[string]any, error) {
+func (c *MongoClient) RestfulAPIGetOne(collName string, filter bson.M) (result map[string]any, err error) {
+	defer c.observe("RestfulAPIGetOne", collName, time.Now(), &result, &err)
 	collection := c.Client.Database(c.dbName).Collection(collName)
-	result, err := getOrigData(collection, filter)
+	result, err = getOrigData(collection, filter)
+	if err == nil {
+		result, err = c.fieldCipher(collName).decrypt(result)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("RestfulAPIGetOne err: %w", err)
+		return nil, fmt.Errorf("RestfulAPIGetOne err: %w", classifyError(err))
 	}
 	return result, nil
 }
 
-func (c *MongoClient) RestfulAPIGetMany(collName string, filter bson.M) ([]map[string]any, error) {
+func (c *MongoClient) RestfulAPIGetMany(collName string, filter bson.M) (resultArray []map[string]any, err error) {
+	defer c.observe("RestfulAPIGetMany", collName, time.Now(), &resultArray, &err)
 	collection := c.Client.Database(c.dbName).Collection(collName)
+	fields := c.fieldCipher(collName)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 	cur, err := collection.Find(ctx, filter)
 	if err != nil {
-		return nil, fmt.Errorf("RestfulAPIGetMany err: %w", err)
+		return nil, fmt.Errorf("RestfulAPIGetMany err: %w", classifyError(err))
 	}
 	defer func(ctx context.Context) {
 		if err := cur.Close(ctx); err != nil {
@@ -114,19 +136,22 @@ func (c *MongoClient) RestfulAPIGetMany(collName string, filter bson.M) ([]map[s
 		}
 	}(ctx)
 
-	var resultArray []map[string]any
 	for cur.Next(ctx) {
 		var result map[string]any
 		if err := cur.Decode(&result); err != nil {
-			return nil, fmt.Errorf("RestfulAPIGetMany err: %w", err)
+			return nil, fmt.Errorf("RestfulAPIGetMany err: %w", classifyError(err))
 		}
 
 		// Delete "_id" entry which is auto-inserted by MongoDB
 		delete(result, "_id")
+		delete(result, VersionField)
+		if result, err = fields.decrypt(result); err != nil {
+			return nil, fmt.Errorf("RestfulAPIGetMany err: %w", err)
+		}
 		resultArray = append(resultArray, result)
 	}
 	if err := cur.Err(); err != nil {
-		return nil, fmt.Errorf("RestfulAPIGetMany err: %w", err)
+		return nil, fmt.Errorf("RestfulAPIGetMany err: %w", classifyError(err))
 	}
 
 	return resultArray, nil
@@ -138,13 +163,22 @@ func (c *MongoClient) RestfulAPIPutOne(collName string, filter bson.M, putData m
 }
 
 // if no error happened, return true means data existed and false means data not existed
-func (c *MongoClient) RestfulAPIPutOneWithContext(ctx context.Context, collName string, filter bson.M, putData map[string]any) (bool, error) {
+func (c *MongoClient) RestfulAPIPutOneWithContext(ctx context.Context, collName string, filter bson.M, putData map[string]any) (existed bool, err error) {
+	defer c.observe("RestfulAPIPutOne", collName, time.Now(), &existed, &err)
 	collection := c.Client.Database(c.dbName).Collection(collName)
+	if putData, err = c.fieldCipher(collName).encrypt(putData, ""); err != nil {
+		return false, fmt.Errorf("RestfulAPIPutOneWithContext err: %w", err)
+	}
+	change, err := c.beginAudit(ctx, collName, filter, AuditPut, false)
+	if err != nil {
+		return false, fmt.Errorf("RestfulAPIPutOneWithContext %w", err)
+	}
 	opts := options.UpdateOne().SetUpsert(true)
-	result, err := collection.UpdateOne(ctx, filter, bson.M{"$set": putData}, opts)
+	result, err := collection.UpdateOne(ctx, filter, setVersioned(putData), opts)
 	if err != nil {
-		return false, fmt.Errorf("RestfulAPIPutOneWithContext UpdateOne err: %w", err)
+		return false, fmt.Errorf("RestfulAPIPutOneWithContext UpdateOne err: %w", classifyError(err))
 	}
+	change.commit(ctx)
 	return result.MatchedCount > 0, nil
 }
 
@@ -152,106 +186,108 @@ func (c *MongoClient) RestfulAPIPullOne(collName string, filter bson.M, putData
 	return c.RestfulAPIPullOneWithContext(context.TODO(), collName, filter, putData)
 }
 
-func (c *MongoClient) RestfulAPIPullOneWithContext(ctx context.Context, collName string, filter bson.M, putData map[string]any) error {
+func (c *MongoClient) RestfulAPIPullOneWithContext(ctx context.Context, collName string, filter bson.M, putData map[string]any) (err error) {
+	defer c.observe("RestfulAPIPullOne", collName, time.Now(), nil, &err)
 	collection := c.Client.Database(c.dbName).Collection(collName)
-	if _, err := collection.UpdateOne(ctx, filter, bson.M{"$pull": putData}); err != nil {
-		return fmt.Errorf("RestfulAPIPullOneWithContext UpdateOne err: %w", err)
+	change, err := c.beginAudit(ctx, collName, filter, AuditPatch, false)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIPullOneWithContext %w", err)
+	}
+	update := bson.M{"$pull": putData, "$inc": bson.M{VersionField: 1}}
+	if _, err := collection.UpdateOne(ctx, filter, update); err != nil {
+		return fmt.Errorf("RestfulAPIPullOneWithContext UpdateOne err: %w", classifyError(err))
 	}
+	change.commit(ctx)
 	return nil
 }
 
 // if no error happened, return true means data existed (not updated) and false means data not existed
-func (c *MongoClient) RestfulAPIPutOneNotUpdate(collName string, filter bson.M, putData map[string]any) (bool, error) {
+func (c *MongoClient) RestfulAPIPutOneNotUpdate(collName string, filter bson.M, putData map[string]any) (existed bool, err error) {
+	defer c.observe("RestfulAPIPutOneNotUpdate", collName, time.Now(), &existed, &err)
 	collection := c.Client.Database(c.dbName).Collection(collName)
-	existed, err := checkDataExisted(collection, filter)
+	existed, err = checkDataExisted(collection, filter)
 	if err != nil {
-		return false, fmt.Errorf("RestfulAPIPutOneNotUpdate err: %w", err)
+		return false, fmt.Errorf("RestfulAPIPutOneNotUpdate err: %w", classifyError(err))
 	}
 
 	if existed {
 		return true, nil
 	}
 
-	if _, err := collection.InsertOne(context.TODO(), putData); err != nil {
-		return false, fmt.Errorf("RestfulAPIPutOneNotUpdate InsertOne err: %w", err)
+	if putData, err = c.fieldCipher(collName).encrypt(putData, ""); err != nil {
+		return false, fmt.Errorf("RestfulAPIPutOneNotUpdate err: %w", err)
 	}
-	return false, nil
-}
-
-func (c *MongoClient) RestfulAPIPutMany(collName string, filterArray []bson.M, putDataArray []map[string]any) error {
-	collection := c.Client.Database(c.dbName).Collection(collName)
-
-	for i, putData := range putDataArray {
-		filter := filterArray[i]
-		existed, err := checkDataExisted(collection, filter)
-		if err != nil {
-			return fmt.Errorf("RestfulAPIPutMany err: %w", err)
-		}
-
-		if existed {
-			if _, err := collection.UpdateOne(context.TODO(), filter, bson.M{"$set": putData}); err != nil {
-				return fmt.Errorf("RestfulAPIPutMany UpdateOne err: %w", err)
-			}
-		} else {
-			if _, err := collection.InsertOne(context.TODO(), putData); err != nil {
-				return fmt.Errorf("RestfulAPIPutMany InsertOne err: %w", err)
-			}
-		}
+	result, err := collection.InsertOne(context.TODO(), withVersion(putData))
+	if err != nil {
+		return false, fmt.Errorf("RestfulAPIPutOneNotUpdate InsertOne err: %w", classifyError(err))
 	}
-	return nil
+	c.auditInserted(context.TODO(), collName, result.InsertedID)
+	return false, nil
 }
 
 func (c *MongoClient) RestfulAPIDeleteOne(collName string, filter bson.M) error {
 	return c.RestfulAPIDeleteOneWithContext(context.TODO(), collName, filter)
 }
 
-func (c *MongoClient) RestfulAPIDeleteOneWithContext(ctx context.Context, collName string, filter bson.M) error {
+func (c *MongoClient) RestfulAPIDeleteOneWithContext(ctx context.Context, collName string, filter bson.M) (err error) {
+	defer c.observe("RestfulAPIDeleteOne", collName, time.Now(), nil, &err)
 	collection := c.Client.Database(c.dbName).Collection(collName)
 
+	change, err := c.beginAudit(ctx, collName, filter, AuditDelete, false)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIDeleteOneWithContext %w", err)
+	}
 	if _, err := collection.DeleteOne(ctx, filter); err != nil {
-		return fmt.Errorf("RestfulAPIDeleteOneWithContext DeleteOne err: %w", err)
+		return fmt.Errorf("RestfulAPIDeleteOneWithContext DeleteOne err: %w", classifyError(err))
 	}
+	change.commit(ctx)
 	return nil
 }
 
-func (c *MongoClient) RestfulAPIDeleteMany(collName string, filter bson.M) error {
+func (c *MongoClient) RestfulAPIDeleteMany(collName string, filter bson.M) (err error) {
+	defer c.observe("RestfulAPIDeleteMany", collName, time.Now(), nil, &err)
 	collection := c.Client.Database(c.dbName).Collection(collName)
 
+	change, err := c.beginAudit(context.TODO(), collName, filter, AuditDelete, true)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIDeleteMany %w", err)
+	}
 	if _, err := collection.DeleteMany(context.TODO(), filter); err != nil {
-		return fmt.Errorf("RestfulAPIDeleteMany err: %w", err)
+		return fmt.Errorf("RestfulAPIDeleteMany err: %w", classifyError(err))
 	}
+	change.commit(context.TODO())
 	return nil
 }
 
-func (c *MongoClient) RestfulAPIMergePatch(collName string, filter bson.M, patchData map[string]any) error {
+// RestfulAPIMergePatch applies an RFC 7386 merge patch to the document matching filter.
+// Concurrent patches of the same document are serialized through VersionField.
+func (c *MongoClient) RestfulAPIMergePatch(collName string, filter bson.M, patchData map[string]any) (err error) {
+	defer c.observe("RestfulAPIMergePatch", collName, time.Now(), nil, &err)
 	collection := c.Client.Database(c.dbName).Collection(collName)
 
-	originalData, err := getOrigData(collection, filter)
-	if err != nil {
-		return fmt.Errorf("RestfulAPIMergePatch getOrigData err: %w", err)
-	}
-
-	original, err := json.Marshal(originalData)
-	if err != nil {
-		return fmt.Errorf("RestfulAPIMergePatch Marshal err: %w", err)
-	}
-
 	patchDataByte, err := json.Marshal(patchData)
 	if err != nil {
-		return fmt.Errorf("RestfulAPIMergePatch Marshal err: %w", err)
+		return fmt.Errorf("RestfulAPIMergePatch Marshal err: %w", classifyError(err))
 	}
-
-	modifiedAlternative, err := jsonpatch.MergePatch(original, patchDataByte)
+	change, err := c.beginAudit(context.TODO(), collName, filter, AuditPatch, false)
 	if err != nil {
-		return fmt.Errorf("RestfulAPIMergePatch MergePatch err: %w", err)
+		return fmt.Errorf("RestfulAPIMergePatch %w", err)
 	}
+	defer func() {
+		if err == nil {
+			change.commit(context.TODO())
+		}
+	}()
 
-	var modifiedData map[string]any
-	if err := json.Unmarshal(modifiedAlternative, &modifiedData); err != nil {
-		return fmt.Errorf("RestfulAPIMergePatch Unmarshal err: %w", err)
-	}
-	if _, err := collection.UpdateOne(context.TODO(), filter, bson.M{"$set": modifiedData}); err != nil {
-		return fmt.Errorf("RestfulAPIMergePatch UpdateOne err: %w", err)
+	err = patchDocument(context.TODO(), collection, filter, "", c.fieldCipher(collName), func(original []byte) ([]byte, error) {
+		modifiedAlternative, err := jsonpatch.MergePatch(original, patchDataByte)
+		if err != nil {
+			return nil, fmt.Errorf("MergePatch err: %w", classifyError(err))
+		}
+		return modifiedAlternative, nil
+	})
+	if err != nil {
+		return fmt.Errorf("RestfulAPIMergePatch %w", classifyError(err))
 	}
 	return nil
 }
@@ -260,69 +296,94 @@ func (c *MongoClient) RestfulAPIJSONPatch(collName string, filter bson.M, patchJ
 	return c.RestfulAPIJSONPatchWithContext(context.TODO(), collName, filter, patchJSON)
 }
 
-func (c *MongoClient) RestfulAPIJSONPatchWithContext(ctx context.Context, collName string, filter bson.M, patchJSON []byte) error {
+// RestfulAPIJSONPatchWithContext applies an RFC 6902 patch to the document matching filter.
+// Patches that only add, replace, remove or test object members are applied atomically
+// by the server, other patches are applied as a read-modify-write that is retried if
+// the document changes concurrently.
+func (c *MongoClient) RestfulAPIJSONPatchWithContext(ctx context.Context, collName string, filter bson.M, patchJSON []byte) (err error) {
+	defer c.observe("RestfulAPIJSONPatch", collName, time.Now(), nil, &err)
 	collection := c.Client.Database(c.dbName).Collection(collName)
 
-	originalData, err := getOrigData(collection, filter)
+	patch, err := jsonpatch.DecodePatch(patchJSON)
 	if err != nil {
-		return fmt.Errorf("RestfulAPIJSONPatch getOrigData err: %w", err)
+		return fmt.Errorf("RestfulAPIJSONPatch DecodePatch err: %w", classifyError(err))
 	}
-
-	original, err := json.Marshal(originalData)
+	change, err := c.beginAudit(ctx, collName, filter, AuditPatch, false)
 	if err != nil {
-		return fmt.Errorf("RestfulAPIJSONPatch Marshal err: %w", err)
+		return fmt.Errorf("RestfulAPIJSONPatch %w", err)
 	}
+	defer func() {
+		if err == nil {
+			change.commit(ctx)
+		}
+	}()
 
-	patch, err := jsonpatch.DecodePatch(patchJSON)
-	if err != nil {
-		return fmt.Errorf("RestfulAPIJSONPatch DecodePatch err: %w", err)
+	// encrypted fields can only be patched after they are decrypted
+	fields := c.fieldCipher(collName)
+	if fields == nil {
+		applied, err := applyNativeJSONPatch(ctx, collection, filter, patchJSON, "")
+		if err != nil {
+			return fmt.Errorf("RestfulAPIJSONPatch %w", classifyError(err))
+		}
+		if applied {
+			return nil
+		}
 	}
 
-	modified, err := patch.Apply(original)
+	err = patchDocument(ctx, collection, filter, "", fields, func(original []byte) ([]byte, error) {
+		modified, err := patch.Apply(original)
+		if err != nil {
+			return nil, fmt.Errorf("Apply err: %w", classifyError(err))
+		}
+		return modified, nil
+	})
 	if err != nil {
-		return fmt.Errorf("RestfulAPIJSONPatch Apply err: %w", err)
-	}
-
-	var modifiedData map[string]any
-	if err := json.Unmarshal(modified, &modifiedData); err != nil {
-		return fmt.Errorf("RestfulAPIJSONPatch Unmarshal err: %w", err)
-	}
-	if _, err := collection.UpdateOne(ctx, filter, bson.M{"$set": modifiedData}); err != nil {
-		return fmt.Errorf("RestfulAPIJSONPatch UpdateOne err: %w", err)
+		return fmt.Errorf("RestfulAPIJSONPatch %w", classifyError(err))
 	}
 	return nil
 }
 
-func (c *MongoClient) RestfulAPIJSONPatchExtend(collName string, filter bson.M, patchJSON []byte, dataName string) error {
+// RestfulAPIJSONPatchExtend applies an RFC 6902 patch to the dataName field of the
+// document matching filter, with the same atomicity as RestfulAPIJSONPatchWithContext.
+func (c *MongoClient) RestfulAPIJSONPatchExtend(collName string, filter bson.M, patchJSON []byte, dataName string) (err error) {
+	defer c.observe("RestfulAPIJSONPatchExtend", collName, time.Now(), nil, &err)
 	collection := c.Client.Database(c.dbName).Collection(collName)
 
-	originalDataCover, err := getOrigData(collection, filter)
+	patch, err := jsonpatch.DecodePatch(patchJSON)
 	if err != nil {
-		return fmt.Errorf("RestfulAPIJSONPatchExtend getOrigData err: %w", err)
+		return fmt.Errorf("RestfulAPIJSONPatchExtend DecodePatch err: %w", classifyError(err))
 	}
-
-	originalData := originalDataCover[dataName]
-	original, err := json.Marshal(originalData)
+	change, err := c.beginAudit(context.TODO(), collName, filter, AuditPatch, false)
 	if err != nil {
-		return fmt.Errorf("RestfulAPIJSONPatchExtend Marshal err: %w", err)
+		return fmt.Errorf("RestfulAPIJSONPatchExtend %w", err)
 	}
+	defer func() {
+		if err == nil {
+			change.commit(context.TODO())
+		}
+	}()
 
-	patch, err := jsonpatch.DecodePatch(patchJSON)
-	if err != nil {
-		return fmt.Errorf("RestfulAPIJSONPatchExtend DecodePatch err: %w", err)
+	// encrypted fields can only be patched after they are decrypted
+	fields := c.fieldCipher(collName)
+	if fields == nil {
+		applied, err := applyNativeJSONPatch(context.TODO(), collection, filter, patchJSON, dataName)
+		if err != nil {
+			return fmt.Errorf("RestfulAPIJSONPatchExtend %w", classifyError(err))
+		}
+		if applied {
+			return nil
+		}
 	}
 
-	modified, err := patch.Apply(original)
+	err = patchDocument(context.TODO(), collection, filter, dataName, fields, func(original []byte) ([]byte, error) {
+		modified, err := patch.Apply(original)
+		if err != nil {
+			return nil, fmt.Errorf("Apply err: %w", classifyError(err))
+		}
+		return modified, nil
+	})
 	if err != nil {
-		return fmt.Errorf("RestfulAPIJSONPatchExtend Apply err: %w", err)
-	}
-
-	var modifiedData map[string]any
-	if err := json.Unmarshal(modified, &modifiedData); err != nil {
-		return fmt.Errorf("RestfulAPIJSONPatchExtend Unmarshal err: %w", err)
-	}
-	if _, err := collection.UpdateOne(context.TODO(), filter, bson.M{"$set": bson.M{dataName: modifiedData}}); err != nil {
-		return fmt.Errorf("RestfulAPIJSONPatchExtend UpdateOne err: %w", err)
+		return fmt.Errorf("RestfulAPIJSONPatchExtend %w", classifyError(err))
 	}
 	return nil
 }
@@ -339,358 +400,270 @@ func (c *MongoClient) RestfulAPIPostMany(collName string, filter bson.M, postDat
 	return c.RestfulAPIPostManyWithContext(context.TODO(), collName, filter, postDataArray)
 }
 
-func (c *MongoClient) RestfulAPIPostManyWithContext(ctx context.Context, collName string, filter bson.M, postDataArray []any) error {
+func (c *MongoClient) RestfulAPIPostManyWithContext(ctx context.Context, collName string, filter bson.M, postDataArray []any) (err error) {
+	defer c.observe("RestfulAPIPostMany", collName, time.Now(), &postDataArray, &err)
 	collection := c.Client.Database(c.dbName).Collection(collName)
 
-	if _, err := collection.InsertMany(ctx, postDataArray); err != nil {
-		return fmt.Errorf("RestfulAPIPostManyWithContext InsertMany err: %w", err)
+	fields := c.fieldCipher(collName)
+	documents := make([]any, len(postDataArray))
+	for i, postData := range postDataArray {
+		if postData, err = fields.encryptAny(postData); err != nil {
+			return fmt.Errorf("RestfulAPIPostManyWithContext document %d err: %w", i, err)
+		}
+		documents[i] = withVersionAny(postData)
+	}
+	postDataArray = documents
+	result, err := collection.InsertMany(ctx, postDataArray)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIPostManyWithContext InsertMany err: %w", classifyError(err))
 	}
+	c.auditInserted(ctx, collName, result.InsertedIDs...)
 	return nil
 }
 
-func (c *MongoClient) RestfulAPICount(collName string, filter bson.M) (int64, error) {
+func (c *MongoClient) RestfulAPICount(collName string, filter bson.M) (count int64, err error) {
+	defer c.observe("RestfulAPICount", collName, time.Now(), &count, &err)
 	collection := c.Client.Database(c.dbName).Collection(collName)
-	result, err := collection.CountDocuments(context.TODO(), filter)
+	count, err = collection.CountDocuments(context.TODO(), filter)
 	if err != nil {
-		return 0, fmt.Errorf("RestfulAPICount err: %w", err)
+		return 0, fmt.Errorf("RestfulAPICount err: %w", classifyError(err))
 	}
-	return result, nil
+	return count, nil
 }
 
-func (c *MongoClient) Drop(collName string) error {
+func (c *MongoClient) Drop(collName string) (err error) {
+	defer c.observe("Drop", collName, time.Now(), nil, &err)
 	collection := c.Client.Database(c.dbName).Collection(collName)
-	return collection.Drop(context.TODO())
+	if err := collection.Drop(context.TODO()); err != nil {
+		return fmt.Errorf("Drop err: %w", classifyError(err))
+	}
+	return nil
 }
 
 /* Get unique identity from counter collection. */
 func (c *MongoClient) GetUniqueIdentity(idName string) int32 {
-	counterCollection := c.Client.Database(c.dbName).Collection("counter")
-
-	counterFilter := bson.M{}
-	counterFilter["_id"] = idName
-
-	for {
-		count := counterCollection.FindOneAndUpdate(context.TODO(), counterFilter, bson.M{"$inc": bson.M{"count": 1}})
-
-		if count.Err() != nil {
-			// logger.MongoDBLog.Println("FindOneAndUpdate error. Create entry for field  ")
-			counterData := bson.M{}
-			counterData["count"] = 1
-			counterData["_id"] = idName
-			counterCollection.InsertOne(context.TODO(), counterData)
-
-			continue
-		} else {
-			// logger.MongoDBLog.Println("found entry. inc and return")
-			data := bson.M{}
-			count.Decode(&data)
-			decodedCount := data["count"].(int32)
-			return decodedCount
-		}
+	count, err := c.GetUniqueIdentityWithError(idName)
+	if err != nil {
+		logger.MongoapiLog.Errorln(err)
+		return -1
 	}
+	return count
 }
 
-/* Get a unique id within a given range. */
-func (c *MongoClient) GetUniqueIdentityWithinRange(pool string, minimum int32, maximum int32) int32 {
-	rangeCollection := c.Client.Database(c.dbName).Collection("range")
+// GetUniqueIdentityWithError is GetUniqueIdentity returning the cause of a failure
+func (c *MongoClient) GetUniqueIdentityWithError(idName string) (count int32, err error) {
+	defer c.observe("GetUniqueIdentity", "counter", time.Now(), nil, &err)
+	counterCollection := c.Client.Database(c.dbName).Collection("counter")
 
-	rangeFilter := bson.M{}
-	rangeFilter["_id"] = pool
-
-	for {
-		count := rangeCollection.FindOneAndUpdate(context.TODO(), rangeFilter, bson.M{"$inc": bson.M{"count": 1}})
-
-		if count.Err() != nil {
-			counterData := bson.M{}
-			counterData["count"] = minimum
-			counterData["_id"] = pool
-			rangeCollection.InsertOne(context.TODO(), counterData)
-
-			continue
-		} else {
-			data := bson.M{}
-			count.Decode(&data)
-			decodedCount := data["count"].(int32)
-
-			if decodedCount >= maximum || decodedCount <= minimum {
-				// err := errors.New("Unique identity is out of range.")
-				// logger.MongoDBLog.Println(err)
-				return -1
-			}
-			return decodedCount
-		}
+	// the upsert creates the counter on first use, so concurrent callers never race on its creation
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	data := bson.M{}
+	err = counterCollection.FindOneAndUpdate(context.TODO(), bson.M{"_id": idName},
+		bson.M{"$inc": bson.M{"count": int32(1)}}, opts).Decode(&data)
+	if err != nil {
+		return -1, fmt.Errorf("GetUniqueIdentity %s err: %w", idName, classifyError(err))
 	}
-}
-
-/* Initialize pool of ids with maximum and minimum values and chunk size and amount of retries to get a chunk. */
-func (c *MongoClient) InitializeChunkPool(poolName string, minimum int32, maximum int32, retries int32, chunkSize int32) {
-	// logger.MongoDBLog.Println("ENTERING InitializeChunkPool")
-	poolData := map[string]int32{}
-	poolData["min"] = minimum
-	poolData["max"] = maximum
-	poolData["retries"] = retries
-	poolData["chunkSize"] = chunkSize
-
-	c.pools[poolName] = poolData
-	// logger.MongoDBLog.Println("Pools: ", pools)
-}
-
-/* Get id by inserting into collection. If insert succeeds, that id is available. Else, it isn't available so retry. */
-func (c *MongoClient) GetChunkFromPool(poolName string) (int32, int32, int32, error) {
-	// logger.MongoDBLog.Println("ENTERING GetChunkFromPool")
-
-	pool := c.pools[poolName]
-
-	if pool == nil {
-		err := errors.New("this pool has not been initialized yet. Initialize by calling InitializeChunkPool")
-		return -1, -1, -1, err
+	count, ok := data["count"].(int32)
+	if !ok {
+		return -1, fmt.Errorf("GetUniqueIdentity %s: unexpected type for count: %T", idName, data["count"])
 	}
+	return count, nil
+}
 
-	minimum := pool["min"]
-	maximum := pool["max"]
-	retries := pool["retries"]
-	chunkSize := pool["chunkSize"]
-	totalChunks := (maximum - minimum) / chunkSize
-
-	var i int32 = 0
-	for i < retries {
-		random := rand.Int31n(totalChunks)
-		lower := minimum + (random * chunkSize)
-		upper := lower + chunkSize
-		poolCollection := c.Client.Database(c.dbName).Collection(poolName)
-
-		// Create an instance of an options and set the desired options
-		data := bson.M{}
-		data["_id"] = random
-		data["lower"] = lower
-		data["upper"] = upper
-		data["owner"] = os.Getenv("HOSTNAME")
-		result := poolCollection.FindOneAndUpdate(context.TODO(), bson.M{"_id": random}, bson.M{"$setOnInsert": data}, options.FindOneAndUpdate().SetUpsert(true))
-
-		if result.Err() != nil {
-			// means that there was no document with that id, so the upsert should have been successful
-			if result.Err() == mongo.ErrNoDocuments {
-				// logger.MongoDBLog.Println("Assigned chunk # ", random, " with range ", lower, " - ", upper)
-				return random, lower, upper, nil
-			}
-
-			return -1, -1, -1, result.Err()
-		}
-		// means there was a document before the update and result contains that document.
-		// logger.MongoDBLog.Println("Chunk", random, " has already been assigned. ", retries-i-1, " retries left.")
-		i++
+/*
+Get a unique id within [minimum, maximum). The ids are handed out in sequence and the
+sequence wraps around to minimum after maximum-1, so an id is only unique among the
+last maximum-minimum ids handed out.
+*/
+func (c *MongoClient) GetUniqueIdentityWithinRange(pool string, minimum int32, maximum int32) int32 {
+	count, err := c.GetUniqueIdentityWithinRangeWithError(pool, minimum, maximum)
+	if err != nil {
+		logger.MongoapiLog.Errorln(err)
+		return -1
 	}
-
-	err := errors.New("no id found after retries")
-	return -1, -1, -1, err
+	return count
 }
 
-/* Release the provided id to the provided pool. */
-func (c *MongoClient) ReleaseChunkToPool(poolName string, id int32) {
-	// logger.MongoDBLog.Println("ENTERING ReleaseChunkToPool")
-	poolCollection := c.Client.Database(c.dbName).Collection(poolName)
-
-	// only want to delete if the currentApp is the owner of this id.
-	currentApp := os.Getenv("HOSTNAME")
-	// logger.MongoDBLog.Println(currentApp)
+// GetUniqueIdentityWithinRangeWithError is GetUniqueIdentityWithinRange returning the cause of a failure
+func (c *MongoClient) GetUniqueIdentityWithinRangeWithError(pool string, minimum int32, maximum int32) (count int32, err error) {
+	defer c.observe("GetUniqueIdentityWithinRange", "range", time.Now(), nil, &err)
+	if minimum >= maximum {
+		return -1, fmt.Errorf("GetUniqueIdentityWithinRange %s: invalid range [%d, %d)", pool, minimum, maximum)
+	}
+	rangeCollection := c.Client.Database(c.dbName).Collection("range")
 
-	_, err := poolCollection.DeleteOne(context.TODO(), bson.M{"_id": id, "owner": currentApp})
-	if err != nil {
-		// logger.MongoDBLog.Println("Release Chunk(", id, ") to Pool(", poolName, ") failed : ", err)
+	// a missing or out of range counter, including one at the end of the range, restarts at minimum
+	restart := bson.M{"$or": bson.A{
+		bson.M{"$lt": bson.A{"$count", minimum}},
+		bson.M{"$gte": bson.A{"$count", maximum - 1}},
+	}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"count": bson.M{"$cond": bson.A{restart, minimum, bson.M{"$add": bson.A{"$count", int32(1)}}}},
+	}}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	data := bson.M{}
+	if err := rangeCollection.FindOneAndUpdate(context.TODO(), bson.M{"_id": pool}, update, opts).Decode(&data); err != nil {
+		return -1, fmt.Errorf("GetUniqueIdentityWithinRange %s err: %w", pool, classifyError(err))
+	}
+	count, ok := data["count"].(int32)
+	if !ok {
+		return -1, fmt.Errorf("GetUniqueIdentityWithinRange %s: unexpected type for count: %T", pool, data["count"])
 	}
+	return count, nil
 }
 
-/* Initialize pool of ids with maximum and minimum values. */
+/*
+Initialize pool of ids with maximum and minimum values. The pool is an IDPool, so the
+retries are no longer needed and only kept for compatibility. A pool created by an
+earlier version must be imported with ImportLegacyIDPoolMigration.
+*/
 func (c *MongoClient) InitializeInsertPool(poolName string, minimum int32, maximum int32, retries int32) {
-	// logger.MongoDBLog.Println("ENTERING InitializeInsertPool")
-	poolData := map[string]int32{}
-	poolData["min"] = minimum
-	poolData["max"] = maximum
-	poolData["retries"] = retries
+	c.InitializePool(poolName, minimum, maximum)
+}
 
-	c.pools[poolName] = poolData
-	// logger.MongoDBLog.Println("Pools: ", pools)
+// InitializeInsertPoolWithError is InitializeInsertPool returning the cause of a failure
+func (c *MongoClient) InitializeInsertPoolWithError(poolName string, minimum int32, maximum int32, retries int32) error {
+	return c.InitializePoolWithError(poolName, minimum, maximum)
 }
 
-/* Get id by inserting into collection. If insert succeeds, that id is available. Else, it isn't available so retry. */
+/* Get a free id from a pool created by InitializeInsertPool. */
 func (c *MongoClient) GetIDFromInsertPool(poolName string) (int32, error) {
-	// logger.MongoDBLog.Println("ENTERING GetIDFromInsertPool")
-
-	pool := c.pools[poolName]
-
-	if pool == nil {
-		err := errors.New("this pool has not been initialized yet. Initialize by calling InitializeInsertPool")
-		return -1, err
-	}
-
-	minimum := pool["min"]
-	maximum := pool["max"]
-	retries := pool["retries"]
-	var i int32 = 0
-	for i < retries {
-		random := rand.Int31n(maximum-minimum) + minimum // returns random int in [0, maximum-minimum-1] + minimum
-		poolCollection := c.Client.Database(c.dbName).Collection(poolName)
-
-		// Create an instance of an options and set the desired options
-		result := poolCollection.FindOneAndUpdate(context.TODO(), bson.M{"_id": random}, bson.M{"$set": bson.M{"_id": random}}, options.FindOneAndUpdate().SetUpsert(true))
-
-		if result.Err() != nil {
-			// means that there was no document with that id, so the upsert should have been successful
-			if result.Err().Error() == "mongo: no documents in result" {
-				// logger.MongoDBLog.Println("Assigned id: ", random)
-				return random, nil
-			}
-
-			return -1, result.Err()
-		}
-		// means there was a document before the update and result contains that document.
-		// logger.MongoDBLog.Println("This id has already been assigned. ")
-		doc := bson.M{}
-		result.Decode(&doc)
-		// logger.MongoDBLog.Println(doc)
-
-		i++
-	}
-
-	err := errors.New("no id found after retries")
-	return -1, err
+	return c.GetIDFromPool(poolName)
 }
 
 /* Release the provided id to the provided pool. */
 func (c *MongoClient) ReleaseIDToInsertPool(poolName string, id int32) {
-	// logger.MongoDBLog.Println("ENTERING ReleaseIDToInsertPool")
-	poolCollection := c.Client.Database(c.dbName).Collection(poolName)
+	c.ReleaseIDToPool(poolName, id)
+}
 
-	_, err := poolCollection.DeleteOne(context.TODO(), bson.M{"_id": id})
-	if err != nil {
-		// logger.MongoDBLog.Println("Release Id(", id, ") to Pool(", poolName, ") failed : ", err)
-	}
+// ReleaseIDToInsertPoolWithError is ReleaseIDToInsertPool returning the cause of a failure
+func (c *MongoClient) ReleaseIDToInsertPoolWithError(poolName string, id int32) error {
+	return c.ReleaseIDToPoolWithError(poolName, id)
 }
 
-/* Initialize pool of ids with maximum and minimum values. */
+/*
+Initialize pool of ids with maximum and minimum values, see IDPool. A pool created by an
+earlier version must be imported with ImportLegacyIDPoolMigration, until then it fails
+with ErrLegacyIDPool so that the IDs allocated by the earlier version are not handed out.
+*/
 func (c *MongoClient) InitializePool(poolName string, minimum int32, maximum int32) {
-	// logger.MongoDBLog.Println("ENTERING InitializePool")
-	poolCollection := c.Client.Database(c.dbName).Collection(poolName)
-	names, err := c.Client.Database(c.dbName).ListCollectionNames(context.TODO(), bson.M{})
-	if err != nil {
-		// logger.MongoDBLog.Println(err)
-		return
+	if err := c.InitializePoolWithError(poolName, minimum, maximum); err != nil {
+		logger.MongoapiLog.Errorln(err)
 	}
+}
 
-	// logger.MongoDBLog.Println(names)
-
-	exists := false
-	for _, name := range names {
-		if name == poolName {
-			// logger.MongoDBLog.Println("The collection exists!")
-			exists = true
-			break
-		}
+// InitializePoolWithError is InitializePool returning the cause of a failure
+func (c *MongoClient) InitializePoolWithError(poolName string, minimum int32, maximum int32) error {
+	_, found, err := c.loadIDPoolConfig(context.TODO(), poolName)
+	if err != nil {
+		return fmt.Errorf("InitializePool %s err: %w", poolName, classifyError(err))
 	}
-	if !exists {
-		// logger.MongoDBLog.Println("Creating collection")
-
-		array := []int32{}
-		for i := minimum; i < maximum; i++ {
-			array = append(array, i)
+	if !found {
+		// the legacy helpers kept the pool in the collection poolName
+		legacy, err := c.Client.Database(c.dbName).Collection(poolName).CountDocuments(context.TODO(), bson.M{},
+			options.Count().SetLimit(1))
+		if err != nil {
+			return fmt.Errorf("InitializePool %s err: %w", poolName, classifyError(err))
 		}
-		poolData := bson.M{}
-		poolData["ids"] = array
-		poolData["_id"] = poolName
-
-		// collection is created when inserting document.
-		// "If a collection does not exist, MongoDB creates the collection when you first store data for that collection."
-		poolCollection.InsertOne(context.TODO(), poolData)
+		if legacy > 0 {
+			return fmt.Errorf("InitializePool %s: %w", poolName, ErrLegacyIDPool)
+		}
+	}
+	if _, err := c.NewIDPool(context.TODO(), poolName, int64(minimum), int64(maximum)); err != nil {
+		return fmt.Errorf("InitializePool %s err: %w", poolName, err)
 	}
+	return nil
 }
 
 /* For example IP addresses need to be assigned and then returned to be used again. */
-func (c *MongoClient) GetIDFromPool(poolName string) (int32, error) {
-	// logger.MongoDBLog.Println("ENTERING GetIDFromPool")
-	poolCollection := c.Client.Database(c.dbName).Collection(poolName)
-
-	result := bson.M{}
-	if err := poolCollection.FindOneAndUpdate(context.TODO(), bson.M{"_id": poolName}, bson.M{"$pop": bson.M{"ids": 1}}).Decode(&result); err != nil {
-		return -1, fmt.Errorf("GetIDFromPool decode err: %w", err)
-	}
-
-	idsRaw, ok := result["ids"]
-	if !ok || idsRaw == nil {
-		return -1, errors.New("there are no available ids")
-	}
-	ids, ok := idsRaw.(bson.A)
-	if !ok {
-		return -1, fmt.Errorf("GetIDFromPool: unexpected type for ids: %T", idsRaw)
-	}
-
-	var array []int32
-	for _, s := range ids {
-		switch v := s.(type) {
-		case int32:
-			array = append(array, v)
-		case int64:
-			const maxInt32 = int64(^uint32(0) >> 1)
-			const minInt32 = -maxInt32 - 1
-			if v > maxInt32 || v < minInt32 {
-				return -1, fmt.Errorf("GetIDFromPool: id out of int32 range: %d", v)
-			}
-			array = append(array, int32(v))
-		default:
-			return -1, fmt.Errorf("GetIDFromPool: unexpected element type %T", s)
-		}
+func (c *MongoClient) GetIDFromPool(poolName string) (id int32, err error) {
+	defer c.observe("GetIDFromPool", idPoolBlockCollection, time.Now(), nil, &err)
+	pool, err := c.OpenIDPool(context.TODO(), poolName)
+	if err != nil {
+		return -1, fmt.Errorf("GetIDFromPool err: %w", err)
 	}
-
-	// logger.MongoDBLog.Println("Array of ids: ", array)
-	if len(array) > 0 {
-		return array[len(array)-1], nil
+	allocated, err := pool.Allocate(context.TODO())
+	if err != nil {
+		return -1, fmt.Errorf("GetIDFromPool err: %w", err)
 	}
-	return -1, errors.New("there are no available ids")
+	// the pool was created with an int32 range
+	return int32(allocated), nil
 }
 
 /* Release the provided id to the provided pool. */
 func (c *MongoClient) ReleaseIDToPool(poolName string, id int32) {
-	// logger.MongoDBLog.Println("ENTERING ReleaseIDToPool")
-	poolCollection := c.Client.Database(c.dbName).Collection(poolName)
+	if err := c.ReleaseIDToPoolWithError(poolName, id); err != nil {
+		logger.MongoapiLog.Errorln(err)
+	}
+}
 
-	poolCollection.UpdateOne(context.TODO(), bson.M{"_id": poolName}, bson.M{"$push": bson.M{"ids": id}})
+// ReleaseIDToPoolWithError is ReleaseIDToPool returning the cause of a failure
+func (c *MongoClient) ReleaseIDToPoolWithError(poolName string, id int32) (err error) {
+	defer c.observe("ReleaseIDToPool", idPoolBlockCollection, time.Now(), nil, &err)
+	pool, err := c.OpenIDPool(context.TODO(), poolName)
+	if err == nil {
+		err = pool.Release(context.TODO(), int64(id))
+	}
+	if err != nil {
+		return fmt.Errorf("ReleaseIDToPool %s id %d err: %w", poolName, id, err)
+	}
+	return nil
 }
 
-func (c *MongoClient) GetOneCustomDataStructure(collName string, filter bson.M) (bson.M, error) {
+func (c *MongoClient) GetOneCustomDataStructure(collName string, filter bson.M) (result bson.M, err error) {
+	defer c.observe("GetOneCustomDataStructure", collName, time.Now(), &result, &err)
 	collection := c.Client.Database(c.dbName).Collection(collName)
 
 	val := collection.FindOne(context.TODO(), filter)
 
 	if val.Err() != nil {
-		return bson.M{}, val.Err()
+		return bson.M{}, fmt.Errorf("GetOneCustomDataStructure err: %w", classifyError(val.Err()))
 	}
 
-	var result bson.M
-	err := val.Decode(&result)
-	return result, err
+	if err = val.Decode(&result); err != nil {
+		return result, fmt.Errorf("GetOneCustomDataStructure err: %w", classifyError(err))
+	}
+	delete(result, VersionField)
+	decrypted, err := c.fieldCipher(collName).decrypt(result)
+	return decrypted, err
 }
 
-func (c *MongoClient) PutOneCustomDataStructure(collName string, filter bson.M, putData any) (bool, error) {
+func (c *MongoClient) PutOneCustomDataStructure(collName string, filter bson.M, putData any) (stored bool, err error) {
+	defer c.observe("PutOneCustomDataStructure", collName, time.Now(), nil, &err)
+	if putData, err = c.fieldCipher(collName).encryptAny(putData); err != nil {
+		return false, fmt.Errorf("PutOneCustomDataStructure err: %w", err)
+	}
 	collection := c.Client.Database(c.dbName).Collection(collName)
 
 	var checkItem map[string]any
 	if err := collection.FindOne(context.TODO(), filter).Decode(&checkItem); err != nil && err != mongo.ErrNoDocuments {
-		return false, fmt.Errorf("PutOneCustomDataStructure FindOne err: %w", err)
+		return false, fmt.Errorf("PutOneCustomDataStructure FindOne err: %w", classifyError(err))
+	}
+	change, err := c.beginAudit(context.TODO(), collName, filter, AuditPut, false)
+	if err != nil {
+		return false, fmt.Errorf("PutOneCustomDataStructure %w", err)
 	}
+	defer func() {
+		if err == nil {
+			change.commit(context.TODO())
+		}
+	}()
 
 	if checkItem == nil {
-		_, err := collection.InsertOne(context.TODO(), putData)
+		_, err := collection.InsertOne(context.TODO(), withVersionAny(putData))
 		if err != nil {
-			return false, err
+			return false, fmt.Errorf("PutOneCustomDataStructure InsertOne err: %w", classifyError(err))
 		}
 		return true, nil
 	}
-	if _, err := collection.UpdateOne(context.TODO(), filter, bson.M{"$set": putData}); err != nil {
-		return false, err
+	if _, err := collection.UpdateOne(context.TODO(), filter, setVersioned(putData)); err != nil {
+		return false, fmt.Errorf("PutOneCustomDataStructure UpdateOne err: %w", classifyError(err))
 	}
 	return true, nil
 }
 
-func (c *MongoClient) CreateIndex(collName string, keyField string) (bool, error) {
+func (c *MongoClient) CreateIndex(collName string, keyField string) (created bool, err error) {
+	defer c.observe("CreateIndex", collName, time.Now(), nil, &err)
 	collection := c.Client.Database(c.dbName).Collection(collName)
 
 	index := mongo.IndexModel{
@@ -698,14 +671,10 @@ func (c *MongoClient) CreateIndex(collName string, keyField string) (bool, error
 		Options: options.Index().SetUnique(true),
 	}
 
-	_, err := collection.Indexes().CreateOne(context.Background(), index)
+	_, err = collection.Indexes().CreateOne(context.Background(), index)
 	if err != nil {
-		// logger.MongoDBLog.Error("Create Index failed : ", keyField, err)
-		return false, err
+		return false, fmt.Errorf("CreateIndex %s on %s err: %w", keyField, collName, classifyError(err))
 	}
-
-	// logger.MongoDBLog.Println("Created index : ", idx, " on keyField : ", keyField, " for Collection : ", collName)
-
 	return true, nil
 }
 
@@ -714,34 +683,55 @@ func (c *MongoClient) CreateIndex(collName string, keyField string) (bool, error
 // To create Index with common timeout use timefield name like : updatedAt
 // To create Index with custom timeout use timefield name like : expireAt
 func (c *MongoClient) RestfulAPICreateTTLIndex(collName string, timeout int32, timeField string) bool {
+	return c.RestfulAPICreateTTLIndexWithError(collName, timeout, timeField) == nil
+}
+
+// RestfulAPICreateTTLIndexWithError is RestfulAPICreateTTLIndex returning the cause of a failure
+func (c *MongoClient) RestfulAPICreateTTLIndexWithError(collName string, timeout int32, timeField string) (err error) {
+	defer c.observe("RestfulAPICreateTTLIndex", collName, time.Now(), nil, &err)
 	collection := c.Client.Database(c.dbName).Collection(collName)
 	index := mongo.IndexModel{
 		Keys:    bson.D{{Key: timeField, Value: 1}},
 		Options: options.Index().SetExpireAfterSeconds(timeout).SetName(timeField),
 	}
 
-	_, err := collection.Indexes().CreateOne(context.Background(), index)
-	return err == nil
+	if _, err := collection.Indexes().CreateOne(context.Background(), index); err != nil {
+		return fmt.Errorf("RestfulAPICreateTTLIndex err: %w", classifyError(err))
+	}
+	return nil
 }
 
 // Use this API to drop TTL Index.
 func (c *MongoClient) RestfulAPIDropTTLIndex(collName string, timeField string) bool {
+	return c.RestfulAPIDropTTLIndexWithError(collName, timeField) == nil
+}
+
+// RestfulAPIDropTTLIndexWithError is RestfulAPIDropTTLIndex returning the cause of a failure
+func (c *MongoClient) RestfulAPIDropTTLIndexWithError(collName string, timeField string) (err error) {
+	defer c.observe("RestfulAPIDropTTLIndex", collName, time.Now(), nil, &err)
 	collection := c.Client.Database(c.dbName).Collection(collName)
-	err := collection.Indexes().DropOne(context.Background(), timeField)
-	return err == nil
+	if err := collection.Indexes().DropOne(context.Background(), timeField); err != nil {
+		return fmt.Errorf("RestfulAPIDropTTLIndex err: %w", classifyError(err))
+	}
+	return nil
 }
 
 // Use this API to update timeout value for TTL Index.
 func (c *MongoClient) RestfulAPIPatchTTLIndex(collName string, timeout int32, timeField string) bool {
+	return c.RestfulAPIPatchTTLIndexWithError(collName, timeout, timeField) == nil
+}
+
+// RestfulAPIPatchTTLIndexWithError is RestfulAPIPatchTTLIndex returning the cause of a failure
+func (c *MongoClient) RestfulAPIPatchTTLIndexWithError(collName string, timeout int32, timeField string) (err error) {
+	defer c.observe("RestfulAPIPatchTTLIndex", collName, time.Now(), nil, &err)
 	collection := c.Client.Database(c.dbName).Collection(collName)
-	err := collection.Indexes().DropOne(context.Background(), timeField)
+	err = collection.Indexes().DropOne(context.Background(), timeField)
 	if err != nil {
 		// Ignore "index not found" (code 27): the index may not exist yet,
 		// but we should still proceed to create the new TTL index.
 		var cmdErr mongo.CommandError
 		if !errors.As(err, &cmdErr) || cmdErr.Code != 27 {
-			// logger.MongoDBLog.Println("Drop Index on field (", timeField, ") for collection (", collName, ") failed : ", err)
-			return false
+			return fmt.Errorf("RestfulAPIPatchTTLIndex DropOne err: %w", classifyError(err))
 		}
 	}
 
@@ -751,54 +741,35 @@ func (c *MongoClient) RestfulAPIPatchTTLIndex(collName string, timeout int32, ti
 		Options: options.Index().SetExpireAfterSeconds(timeout).SetName(timeField),
 	}
 
-	_, err = collection.Indexes().CreateOne(context.Background(), index)
-	return err == nil
+	if _, err = collection.Indexes().CreateOne(context.Background(), index); err != nil {
+		return fmt.Errorf("RestfulAPIPatchTTLIndex CreateOne err: %w", classifyError(err))
+	}
+	return nil
 }
 
 // This API adds document to collection with name : "collName"
 // This API should be used when we wish to update the timeout value for the TTL index
-// It checks if an Index with name "indexName" exists on the collection.
-// If such an Index is "indexName" is found, we drop the index and then
+// It checks if an Index on the field "timeField" exists on the collection.
+// If such an Index is found, we drop the index and then
 // add new Index with new timeout value.
+// Prefer a migration with SetTTLMigration, which modifies the index in place.
 func (c *MongoClient) RestfulAPIPatchOneTimeout(collName string, filter bson.M, putData map[string]any, timeout int32, timeField string) bool {
+	return c.RestfulAPIPatchOneTimeoutWithError(collName, filter, putData, timeout, timeField) == nil
+}
+
+// RestfulAPIPatchOneTimeoutWithError is RestfulAPIPatchOneTimeout returning the cause of a failure
+func (c *MongoClient) RestfulAPIPatchOneTimeoutWithError(collName string, filter bson.M, putData map[string]any, timeout int32, timeField string) (err error) {
+	defer c.observe("RestfulAPIPatchOneTimeout", collName, time.Now(), nil, &err)
 	collection := c.Client.Database(c.dbName).Collection(collName)
-	var checkItem map[string]any
 
-	// fetch all Indexes on collection
-	cursor, err := collection.Indexes().List(context.TODO())
-	if err != nil {
-		// logger.MongoDBLog.Println("RestfulAPIPatchOneTimeout : List Index failed for collection (", collName, ") : ", err)
-		return false
-	}
-
-	var result []bson.M
-	// convert to map
-	if err = cursor.All(context.TODO(), &result); err != nil {
-		// logger.MongoDBLog.Println("RestfulAPIPatchOneTimeout : Cursor decode failed for collection (", collName, ") : ", err)
-		return false
-	}
-
-	// loop through the map and check for entry with key as name
-	// for every entry with key as name,check if the value string contains the timeField string.
-	// the Indexes are generally named such as follows :
-	// field name : createdAt, index name : createdAt_1
-	// drop the index if found.
-	drop := false
-	for _, v := range result {
-		for k1, v1 := range v {
-			valStr := fmt.Sprint(v1)
-			if (k1 == "name") && strings.Contains(valStr, timeField) {
-				err = collection.Indexes().DropOne(context.Background(), valStr)
-				if err != nil {
-					// logger.MongoDBLog.Println("Drop Index on field (", timeField, ") for collection (", collName, ") failed : ", err)
-					return false
-				}
-				drop = true
-				break
-			}
-		}
-		if drop {
-			break
+	// drop the index on timeField, if there is one
+	name, found, err := findIndexOnField(context.TODO(), collection, timeField)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIPatchOneTimeout List err: %w", classifyError(err))
+	}
+	if found {
+		if err := collection.Indexes().DropOne(context.Background(), name); err != nil {
+			return fmt.Errorf("RestfulAPIPatchOneTimeout DropOne err: %w", classifyError(err))
 		}
 	}
 
@@ -808,25 +779,22 @@ func (c *MongoClient) RestfulAPIPatchOneTimeout(collName string, filter bson.M,
 		Options: options.Index().SetExpireAfterSeconds(timeout),
 	}
 
-	_, err = collection.Indexes().CreateOne(context.Background(), index)
-	if err != nil {
-		// logger.MongoDBLog.Println("Index on field (", timeField, ") for collection (", collName, ") already exists : ", err)
+	if _, err := collection.Indexes().CreateOne(context.Background(), index); err != nil {
+		logger.MongoapiLog.Warnf("index on field %s for collection %s already exists: %v", timeField, collName, err)
 	}
 
-	if err := collection.FindOne(context.TODO(), filter).Decode(&checkItem); err != nil && err != mongo.ErrNoDocuments {
-		return false
+	if putData, err = c.fieldCipher(collName).encrypt(putData, ""); err != nil {
+		return fmt.Errorf("RestfulAPIPatchOneTimeout err: %w", err)
 	}
-
-	if checkItem == nil {
-		if _, err := collection.InsertOne(context.TODO(), putData); err != nil {
-			return false
-		}
-		return true
+	change, err := c.beginAudit(context.TODO(), collName, filter, AuditPut, false)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIPatchOneTimeout %w", err)
 	}
-	if _, err := collection.UpdateOne(context.TODO(), filter, bson.M{"$set": putData}); err != nil {
-		return false
+	if err := putOrInsert(collection, filter, putData, "RestfulAPIPatchOneTimeout"); err != nil {
+		return err
 	}
-	return true
+	change.commit(context.TODO())
+	return nil
 }
 
 // This API adds document to collection with name : "collName"
@@ -838,60 +806,112 @@ func (c *MongoClient) RestfulAPIPatchOneTimeout(collName string, filter bson.M,
 // If the Index exists on the same "timeField" with a different timeout,
 // then API will return error saying Index already exists.
 func (c *MongoClient) RestfulAPIPutOneTimeout(collName string, filter bson.M, putData map[string]any, timeout int32, timeField string) bool {
+	return c.RestfulAPIPutOneTimeoutWithError(collName, filter, putData, timeout, timeField) == nil
+}
+
+// RestfulAPIPutOneTimeoutWithError is RestfulAPIPutOneTimeout returning the cause of a failure
+func (c *MongoClient) RestfulAPIPutOneTimeoutWithError(collName string, filter bson.M, putData map[string]any, timeout int32, timeField string) (err error) {
+	defer c.observe("RestfulAPIPutOneTimeout", collName, time.Now(), nil, &err)
 	collection := c.Client.Database(c.dbName).Collection(collName)
-	var checkItem map[string]any
+	if putData, err = c.fieldCipher(collName).encrypt(putData, ""); err != nil {
+		return fmt.Errorf("RestfulAPIPutOneTimeout err: %w", err)
+	}
+	change, err := c.beginAudit(context.TODO(), collName, filter, AuditPut, false)
+	if err != nil {
+		return fmt.Errorf("RestfulAPIPutOneTimeout %w", err)
+	}
+	if err := putOrInsert(collection, filter, putData, "RestfulAPIPutOneTimeout"); err != nil {
+		return err
+	}
+	change.commit(context.TODO())
+	return nil
+}
 
+// putOrInsert updates the document matching filter with putData, or inserts putData
+// if there is no such document
+func putOrInsert(collection *mongo.Collection, filter bson.M, putData map[string]any, op string) error {
+	var checkItem map[string]any
 	if err := collection.FindOne(context.TODO(), filter).Decode(&checkItem); err != nil && err != mongo.ErrNoDocuments {
-		return false
+		return fmt.Errorf("%s FindOne err: %w", op, classifyError(err))
 	}
 
 	if checkItem == nil {
-		if _, err := collection.InsertOne(context.TODO(), putData); err != nil {
-			return false
+		if _, err := collection.InsertOne(context.TODO(), withVersion(putData)); err != nil {
+			return fmt.Errorf("%s InsertOne err: %w", op, classifyError(err))
 		}
-		return true
+		return nil
 	}
-	if _, err := collection.UpdateOne(context.TODO(), filter, bson.M{"$set": putData}); err != nil {
-		return false
+	if _, err := collection.UpdateOne(context.TODO(), filter, setVersioned(putData)); err != nil {
+		return fmt.Errorf("%s UpdateOne err: %w", op, classifyError(err))
 	}
-	return true
+	return nil
 }
 
 func (c *MongoClient) RestfulAPIPostOnly(collName string, filter bson.M, postData map[string]any) bool {
+	return c.RestfulAPIPostOnlyWithError(collName, filter, postData) == nil
+}
+
+// RestfulAPIPostOnlyWithError is RestfulAPIPostOnly returning the cause of a failure,
+// ErrDuplicateKey if the document violates a unique index
+func (c *MongoClient) RestfulAPIPostOnlyWithError(collName string, filter bson.M, postData map[string]any) (err error) {
+	defer c.observe("RestfulAPIPostOnly", collName, time.Now(), nil, &err)
 	collection := c.Client.Database(c.dbName).Collection(collName)
 
-	_, err := collection.InsertOne(context.TODO(), postData)
-	return err == nil
+	if postData, err = c.fieldCipher(collName).encrypt(postData, ""); err != nil {
+		return fmt.Errorf("RestfulAPIPostOnly err: %w", err)
+	}
+	result, err := collection.InsertOne(context.TODO(), withVersion(postData))
+	if err != nil {
+		return fmt.Errorf("RestfulAPIPostOnly err: %w", classifyError(err))
+	}
+	c.auditInserted(context.TODO(), collName, result.InsertedID)
+	return nil
 }
 
-func (c *MongoClient) RestfulAPIPutOnly(collName string, filter bson.M, putData map[string]any) error {
+// RestfulAPIPutOnly updates the document matching filter with putData, it returns
+// ErrNotFound if there is no such document
+func (c *MongoClient) RestfulAPIPutOnly(collName string, filter bson.M, putData map[string]any) (err error) {
+	defer c.observe("RestfulAPIPutOnly", collName, time.Now(), nil, &err)
 	collection := c.Client.Database(c.dbName).Collection(collName)
 
-	result, err := collection.UpdateOne(context.TODO(), filter, bson.M{"$set": putData})
-	if result.MatchedCount != 0 {
-		// logger.MongoDBLog.Println("matched and replaced an existing document")
-		return nil
+	if putData, err = c.fieldCipher(collName).encrypt(putData, ""); err != nil {
+		return fmt.Errorf("failed to encrypt document: %w", err)
+	}
+	change, err := c.beginAudit(context.TODO(), collName, filter, AuditPut, false)
+	if err != nil {
+		return fmt.Errorf("failed to update document: %w", err)
+	}
+	result, err := collection.UpdateOne(context.TODO(), filter, setVersioned(putData))
+	if err != nil {
+		return fmt.Errorf("failed to update document: %w", classifyError(err))
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("failed to update document: %w", ErrNotFound)
 	}
-	err = fmt.Errorf("failed to update document: %s", err)
-	return err
+	change.commit(context.TODO())
+	return nil
 }
 
 func (c *MongoClient) StartSession() (*mongo.Session, error) {
 	return c.Client.StartSession()
 }
 
+// SupportsTransactions reports whether the deployment is a replica set or a sharded
+// cluster. The answer is cached by the client, since the topology of a deployment
+// does not change while it is connected.
 func (c *MongoClient) SupportsTransactions() (bool, error) {
+	if supported := c.transactionSupport.Load(); supported != nil {
+		return *supported, nil
+	}
 	command := bson.D{{Key: "hello", Value: 1}}
 	result := c.Client.Database(c.dbName).RunCommand(context.Background(), command)
 	var status bson.M
 	if err := result.Decode(&status); err != nil {
 		return false, fmt.Errorf("failed to get server status: %v", err)
 	}
-	if msg, ok := status["msg"]; ok && msg == "isdbgrid" {
-		return true, nil // Sharded clusters support transactions
-	}
-	if _, ok := status["setName"]; ok {
-		return true, nil
-	}
-	return false, nil
+	_, replicaSet := status["setName"]
+	// sharded clusters support transactions as well
+	supported := status["msg"] == "isdbgrid" || replicaSet
+	c.transactionSupport.Store(&supported)
+	return supported, nil
 }
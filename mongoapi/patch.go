@@ -0,0 +1,286 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// VersionField is the document field used for optimistic concurrency control.
+// It is set to 1 by every insert and incremented by every update, and it is
+// stripped from the documents returned by every read.
+const VersionField = "_version"
+
+// maxPatchRetries bounds how often a patch is retried when the document is
+// modified concurrently between the read and the conditional write
+const maxPatchRetries = 10
+
+// patchDocument applies modify to the document matching filter, or to its dataName
+// field if dataName is not empty, as an optimistic read-modify-write: the result is
+// only written if VersionField is unchanged since the read, otherwise the document is
+// read again and modify is reapplied. Encrypted fields are decrypted before modify and
+// encrypted again afterwards. The returned error is prefixed with the step that failed
+// so callers can wrap it with their own name.
+func patchDocument(ctx context.Context, collection *mongo.Collection, filter bson.M, dataName string,
+	fields *fieldCipher, modify func(original []byte) ([]byte, error),
+) error {
+	for range maxPatchRetries {
+		current, err := findOneAndDecodeWithContext(ctx, collection, filter)
+		if err != nil {
+			return fmt.Errorf("getOrigData err: %w", err)
+		}
+		if current, err = fields.decrypt(current); err != nil {
+			return fmt.Errorf("Decrypt err: %w", err)
+		}
+
+		var (
+			id, version any
+			hasVersion  bool
+			target      any
+		)
+		if current != nil {
+			id = current["_id"]
+			version, hasVersion = current[VersionField]
+			delete(current, "_id")
+			delete(current, VersionField)
+			target = current
+			if dataName != "" {
+				target = current[dataName]
+			}
+		}
+
+		original, err := json.Marshal(target)
+		if err != nil {
+			return fmt.Errorf("Marshal err: %w", err)
+		}
+		modified, err := modify(original)
+		if err != nil {
+			return err
+		}
+		var modifiedData map[string]any
+		if err := json.Unmarshal(modified, &modifiedData); err != nil {
+			return fmt.Errorf("Unmarshal err: %w", err)
+		}
+		if current == nil {
+			// nothing to update, as with an UpdateOne that matches no document
+			return nil
+		}
+		if modifiedData, err = fields.encrypt(modifiedData, dataName); err != nil {
+			return fmt.Errorf("Encrypt err: %w", err)
+		}
+
+		versionFilter := bson.M{"_id": id, VersionField: version}
+		if !hasVersion {
+			versionFilter[VersionField] = bson.M{"$exists": false}
+		}
+		next := nextVersion(version)
+
+		var result *mongo.UpdateResult
+		if dataName == "" {
+			if modifiedData == nil {
+				modifiedData = make(map[string]any)
+			}
+			modifiedData[VersionField] = next
+			result, err = collection.ReplaceOne(ctx, versionFilter, modifiedData)
+		} else {
+			result, err = collection.UpdateOne(ctx, versionFilter,
+				bson.M{"$set": bson.M{dataName: modifiedData, VersionField: next}})
+		}
+		if err != nil {
+			return fmt.Errorf("UpdateOne err: %w", err)
+		}
+		if result.MatchedCount > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("UpdateOne err: %w", ErrConflict)
+}
+
+// applyNativeJSONPatch tries to apply patchJSON with a single conditional UpdateOne.
+// It returns true if the patch was applied by the server and false if the caller
+// must fall back to patchDocument, either because the patch cannot be translated or
+// because the document did not satisfy its preconditions.
+func applyNativeJSONPatch(ctx context.Context, collection *mongo.Collection, filter bson.M, patchJSON []byte,
+	dataName string,
+) (bool, error) {
+	conditions, update, ok := translateJSONPatch(patchJSON, dataName)
+	if !ok {
+		return false, nil
+	}
+	result, err := collection.UpdateOne(ctx, bson.M{"$and": append(bson.A{filter}, conditions...)}, update)
+	if err != nil {
+		var writeErr mongo.WriteException
+		if errors.As(err, &writeErr) {
+			// the server rejected the update for this document shape, let the
+			// read-modify-write path report the precise error
+			return false, nil
+		}
+		return false, fmt.Errorf("UpdateOne err: %w", err)
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// translateJSONPatch converts an RFC 6902 patch into filter conditions and a MongoDB
+// update document with the same effect. Only add, remove, replace and test operations
+// on object members are translated, and only if no two operations touch overlapping
+// paths, since array positions and operation ordering have no exact equivalent in an
+// update document.
+func translateJSONPatch(patchJSON []byte, dataName string) (bson.A, bson.M, bool) {
+	var operations []map[string]any
+	if err := json.Unmarshal(patchJSON, &operations); err != nil || len(operations) == 0 {
+		return nil, nil, false
+	}
+
+	var (
+		conditions bson.A
+		paths      []string
+		checked    = make(map[string]bool)
+		set        = bson.M{}
+		unset      = bson.M{}
+	)
+	requireObject := func(path string) {
+		if !checked[path] {
+			checked[path] = true
+			conditions = append(conditions, bson.M{path: bson.M{"$type": "object", "$not": bson.M{"$type": "array"}}})
+		}
+	}
+
+	for _, operation := range operations {
+		op, _ := operation["op"].(string)
+		pointer, _ := operation["path"].(string)
+		segments, ok := parseJSONPointer(pointer)
+		if !ok {
+			return nil, nil, false
+		}
+		if dataName != "" {
+			segments = append([]string{dataName}, segments...)
+		}
+		path := strings.Join(segments, ".")
+		for _, other := range paths {
+			if other == path || strings.HasPrefix(other, path+".") || strings.HasPrefix(path, other+".") {
+				return nil, nil, false
+			}
+		}
+		paths = append(paths, path)
+		for i := 1; i < len(segments); i++ {
+			requireObject(strings.Join(segments[:i], "."))
+		}
+
+		value, hasValue := operation["value"]
+		switch op {
+		case "add":
+			if !hasValue {
+				return nil, nil, false
+			}
+			set[path] = value
+		case "replace":
+			if !hasValue {
+				return nil, nil, false
+			}
+			conditions = append(conditions, bson.M{path: bson.M{"$exists": true}})
+			set[path] = value
+		case "remove":
+			conditions = append(conditions, bson.M{path: bson.M{"$exists": true}})
+			unset[path] = ""
+		case "test":
+			switch value.(type) {
+			case string, float64, bool:
+			default:
+				return nil, nil, false
+			}
+			conditions = append(conditions, bson.M{path: bson.M{"$eq": value, "$not": bson.M{"$type": "array"}}})
+		default:
+			return nil, nil, false
+		}
+	}
+	if len(set) == 0 && len(unset) == 0 {
+		return nil, nil, false
+	}
+
+	update := bson.M{"$inc": bson.M{VersionField: 1}}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return conditions, update, true
+}
+
+// parseJSONPointer splits an RFC 6901 pointer into object member names. It returns
+// false for pointers that cannot be expressed as a MongoDB dotted path, including
+// the document root and anything that may address an array element.
+func parseJSONPointer(pointer string) ([]string, bool) {
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, false
+	}
+	segments := strings.Split(pointer[1:], "/")
+	for i, segment := range segments {
+		segment = strings.ReplaceAll(strings.ReplaceAll(segment, "~1", "/"), "~0", "~")
+		if segment == "" || segment == "-" || strings.Contains(segment, ".") ||
+			strings.HasPrefix(segment, "$") || isDigits(segment) {
+			return nil, false
+		}
+		segments[i] = segment
+	}
+	return segments, true
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// setVersioned returns the update that sets the fields of putData and increments VersionField
+func setVersioned(putData any) bson.M {
+	return bson.M{"$set": putData, "$inc": bson.M{VersionField: 1}}
+}
+
+// withVersion returns a copy of doc with the VersionField of a newly inserted document
+func withVersion(doc map[string]any) map[string]any {
+	versioned := make(map[string]any, len(doc)+1)
+	for key, value := range doc {
+		versioned[key] = value
+	}
+	versioned[VersionField] = 1
+	return versioned
+}
+
+// withVersionAny is withVersion for data of any type, data that is not a document is
+// returned unchanged
+func withVersionAny(data any) any {
+	switch doc := data.(type) {
+	case map[string]any:
+		return withVersion(doc)
+	case bson.M:
+		return withVersion(doc)
+	default:
+		return data
+	}
+}
+
+func nextVersion(version any) int64 {
+	switch v := version.(type) {
+	case int32:
+		return int64(v) + 1
+	case int64:
+		return v + 1
+	case float64:
+		return int64(v) + 1
+	default:
+		return 1
+	}
+}
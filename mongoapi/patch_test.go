@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestParseJSONPointer(t *testing.T) {
+	testCases := []struct {
+		pointer  string
+		expected []string
+		ok       bool
+	}{
+		{"/a", []string{"a"}, true},
+		{"/a/b", []string{"a", "b"}, true},
+		{"/a~1b/c~0d", []string{"a/b", "c~d"}, true},
+		{"", nil, false},
+		{"/", nil, false},
+		{"a", nil, false},
+		{"/list/0", nil, false},
+		{"/list/-", nil, false},
+		{"/a.b", nil, false},
+		{"/$set", nil, false},
+	}
+	for _, tc := range testCases {
+		segments, ok := parseJSONPointer(tc.pointer)
+		if ok != tc.ok || (ok && !reflect.DeepEqual(segments, tc.expected)) {
+			t.Errorf("parseJSONPointer(%q) = %v, %v, expected %v, %v", tc.pointer, segments, ok, tc.expected, tc.ok)
+		}
+	}
+}
+
+func TestTranslateJSONPatch(t *testing.T) {
+	patch := []byte(`[
+		{"op": "test", "path": "/ambr/uplink", "value": "1 Gbps"},
+		{"op": "replace", "path": "/ambr/downlink", "value": "2 Gbps"},
+		{"op": "add", "path": "/dnn", "value": "internet"},
+		{"op": "remove", "path": "/stale"}
+	]`)
+	conditions, update, ok := translateJSONPatch(patch, "")
+	if !ok {
+		t.Fatal("translateJSONPatch() should translate a patch on object members")
+	}
+
+	expectedConditions := bson.A{
+		bson.M{"ambr": bson.M{"$type": "object", "$not": bson.M{"$type": "array"}}},
+		bson.M{"ambr.uplink": bson.M{"$eq": "1 Gbps", "$not": bson.M{"$type": "array"}}},
+		bson.M{"ambr.downlink": bson.M{"$exists": true}},
+		bson.M{"stale": bson.M{"$exists": true}},
+	}
+	if !reflect.DeepEqual(conditions, expectedConditions) {
+		t.Errorf("unexpected conditions: %v", conditions)
+	}
+	expectedUpdate := bson.M{
+		"$inc":   bson.M{VersionField: 1},
+		"$set":   bson.M{"ambr.downlink": "2 Gbps", "dnn": "internet"},
+		"$unset": bson.M{"stale": ""},
+	}
+	if !reflect.DeepEqual(update, expectedUpdate) {
+		t.Errorf("unexpected update: %v", update)
+	}
+
+	conditions, update, ok = translateJSONPatch([]byte(`[{"op": "add", "path": "/sd", "value": "010203"}]`), "nssai")
+	if !ok {
+		t.Fatal("translateJSONPatch() should translate a patch on a data field")
+	}
+	if !reflect.DeepEqual(conditions, bson.A{bson.M{"nssai": bson.M{"$type": "object", "$not": bson.M{"$type": "array"}}}}) {
+		t.Errorf("unexpected conditions for data field patch: %v", conditions)
+	}
+	if !reflect.DeepEqual(update["$set"], bson.M{"nssai.sd": "010203"}) {
+		t.Errorf("unexpected update for data field patch: %v", update)
+	}
+}
+
+func TestTranslateJSONPatchFallback(t *testing.T) {
+	patches := []string{
+		`[{"op": "add", "path": "/list/0", "value": 1}]`,
+		`[{"op": "add", "path": "/list/-", "value": 1}]`,
+		`[{"op": "move", "from": "/a", "path": "/b"}]`,
+		`[{"op": "copy", "from": "/a", "path": "/b"}]`,
+		`[{"op": "test", "path": "/a", "value": {"b": 1}}]`,
+		`[{"op": "test", "path": "/a", "value": "x"}]`,
+		`[{"op": "replace", "path": "/a", "value": 1}, {"op": "replace", "path": "/a/b", "value": 2}]`,
+		`[{"op": "replace", "path": "", "value": {}}]`,
+		`[]`,
+		`not json`,
+	}
+	for _, patch := range patches {
+		if _, _, ok := translateJSONPatch([]byte(patch), ""); ok {
+			t.Errorf("translateJSONPatch(%s) should fall back to read-modify-write", patch)
+		}
+	}
+}
+
+func TestNextVersion(t *testing.T) {
+	testCases := []struct {
+		version  any
+		expected int64
+	}{
+		{nil, 1},
+		{int32(1), 2},
+		{int64(41), 42},
+		{float64(3), 4},
+	}
+	for _, tc := range testCases {
+		if next := nextVersion(tc.version); next != tc.expected {
+			t.Errorf("nextVersion(%v) = %d, expected %d", tc.version, next, tc.expected)
+		}
+	}
+}
+
+func TestWithVersionAny(t *testing.T) {
+	for _, data := range []any{map[string]any{"ueId": "imsi-1"}, bson.M{"ueId": "imsi-1"}} {
+		doc, ok := withVersionAny(data).(map[string]any)
+		if !ok || doc[VersionField] != 1 || doc["ueId"] != "imsi-1" {
+			t.Errorf("withVersionAny(%T) = %v, expected the document with its version", data, doc)
+		}
+	}
+	type subscriber struct {
+		UeID string `bson:"ueId"`
+	}
+	if data := withVersionAny(subscriber{UeID: "imsi-1"}); data != (subscriber{UeID: "imsi-1"}) {
+		t.Errorf("withVersionAny() should not change a struct, got %v", data)
+	}
+}
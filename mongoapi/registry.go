@@ -0,0 +1,133 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// DefaultClientName is the name under which ConnectCommonDBClient registers CommonDBClient
+const DefaultClientName = "default"
+
+// maxDatabaseNameLength is the longest database name accepted by MongoDB
+const maxDatabaseNameLength = 63
+
+// ErrClientNotRegistered is returned when no client is registered under a name
+var ErrClientNotRegistered = errors.New("client not registered")
+
+// Database returns a client for the database dbName that shares the connections of c,
+// so scoping is cheap and needs no disconnect of its own. The scoped client starts
+// with the transaction policy, field encryption, audit configuration and
+// instrumentation of c.
+func (c *MongoClient) Database(dbName string) *MongoClient {
+	scoped := &MongoClient{
+		Client:            c.Client,
+		dbName:            dbName,
+		url:               c.url,
+		transactionPolicy: c.transactionPolicy,
+		fieldEncryption:   c.fieldEncryption,
+		audit:             c.audit,
+	}
+	scoped.instrumentations.Store(c.instrumentations.Load())
+	scoped.transactionSupport.Store(c.transactionSupport.Load())
+	return scoped
+}
+
+// ForTenant returns a client for the database of tenant, named after the database of
+// c and the tenant, for example "sdcore_enterprise1". The data of each tenant is
+// thereby isolated in its own database.
+func (c *MongoClient) ForTenant(tenant string) (*MongoClient, error) {
+	dbName, err := tenantDatabaseName(c.dbName, tenant)
+	if err != nil {
+		return nil, fmt.Errorf("ForTenant err: %w", err)
+	}
+	return c.Database(dbName), nil
+}
+
+// DatabaseName returns the name of the database of the client
+func (c *MongoClient) DatabaseName() string {
+	return c.dbName
+}
+
+func tenantDatabaseName(dbName string, tenant string) (string, error) {
+	if tenant == "" || strings.ContainsAny(tenant, "/\\. \"$*<>:|?") {
+		return "", fmt.Errorf("invalid tenant %q", tenant)
+	}
+	name := dbName + "_" + tenant
+	if len(name) > maxDatabaseNameLength {
+		return "", fmt.Errorf("database name %s of tenant %s is longer than %d characters", name, tenant, maxDatabaseNameLength)
+	}
+	return name, nil
+}
+
+// ClientRegistry holds named database clients, for example one per database of a
+// network function, in place of the single CommonDBClient
+type ClientRegistry struct {
+	mutex   sync.RWMutex
+	clients map[string]DBInterface
+}
+
+// Clients is the registry of the clients of the process
+var Clients = NewClientRegistry()
+
+// NewClientRegistry creates an empty ClientRegistry
+func NewClientRegistry() *ClientRegistry {
+	return &ClientRegistry{clients: make(map[string]DBInterface)}
+}
+
+// Register registers client under name, replacing a client registered before
+func (r *ClientRegistry) Register(name string, client DBInterface) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.clients[name] = client
+}
+
+// Unregister removes the client registered under name
+func (r *ClientRegistry) Unregister(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.clients, name)
+}
+
+// Get returns the client registered under name, or ErrClientNotRegistered
+func (r *ClientRegistry) Get(name string) (DBInterface, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	client, ok := r.clients[name]
+	if !ok {
+		return nil, fmt.Errorf("client %s: %w", name, ErrClientNotRegistered)
+	}
+	return client, nil
+}
+
+// Names returns the sorted names of the registered clients
+func (r *ClientRegistry) Names() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	names := make([]string, 0, len(r.clients))
+	for name := range r.clients {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// ConnectNamedClient connects a client configured by opts and registers it in Clients
+// under name. Clients of further databases on the same deployment are better derived
+// from the connected client with MongoClient.Database, sharing its connections.
+func ConnectNamedClient(ctx context.Context, name string, opts ConnectionOptions) (*ConnectionManager, error) {
+	manager := NewConnectionManager(opts)
+	client, err := manager.Connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ConnectNamedClient %s err: %w", name, err)
+	}
+	Clients.Register(name, client)
+	return manager, nil
+}
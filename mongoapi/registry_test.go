@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDatabaseScope(t *testing.T) {
+	c := &MongoClient{dbName: "sdcore", transactionPolicy: TransactionBestEffort}
+	var observed []string
+	c.AddInstrumentation(InstrumentationFunc(func(event OperationEvent) {
+		observed = append(observed, event.Collection)
+	}))
+
+	scoped := c.Database("nfstate")
+	if scoped.DatabaseName() != "nfstate" || c.DatabaseName() != "sdcore" {
+		t.Fatalf("unexpected database names %s and %s", scoped.DatabaseName(), c.DatabaseName())
+	}
+	if scoped.transactionPolicy != TransactionBestEffort {
+		t.Error("scoped client should inherit the transaction policy")
+	}
+
+	// instrumentation added to the scoped client does not affect the parent
+	scoped.AddInstrumentation(InstrumentationFunc(func(event OperationEvent) {
+		observed = append(observed, "scoped:"+event.Collection)
+	}))
+	var err error
+	scoped.observe("RestfulAPIGetOne", "a", time.Now(), nil, &err)
+	c.observe("RestfulAPIGetOne", "b", time.Now(), nil, &err)
+	if expected := []string{"a", "scoped:a", "b"}; !slices.Equal(observed, expected) {
+		t.Errorf("observed %v, expected %v", observed, expected)
+	}
+}
+
+func TestForTenant(t *testing.T) {
+	c := &MongoClient{dbName: "sdcore"}
+	tenant, err := c.ForTenant("enterprise1")
+	if err != nil {
+		t.Fatalf("ForTenant() failed: %v", err)
+	}
+	if tenant.DatabaseName() != "sdcore_enterprise1" {
+		t.Errorf("unexpected database %s", tenant.DatabaseName())
+	}
+	for _, invalid := range []string{"", "a.b", "a/b", "a b", "$a", "very-long-enterprise-name-exceeding-the-database-name-limit"} {
+		if _, err := c.ForTenant(invalid); err == nil {
+			t.Errorf("ForTenant(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestClientRegistry(t *testing.T) {
+	registry := NewClientRegistry()
+	if _, err := registry.Get("subscribers"); !errors.Is(err, ErrClientNotRegistered) {
+		t.Errorf("expected ErrClientNotRegistered, got %v", err)
+	}
+
+	subscribers := NewMemoryDB()
+	registry.Register("subscribers", subscribers)
+	registry.Register("nfstate", NewMemoryDB())
+	client, err := registry.Get("subscribers")
+	if err != nil || client != subscribers {
+		t.Fatalf("Get() = %v, %v", client, err)
+	}
+	if names := registry.Names(); !slices.Equal(names, []string{"nfstate", "subscribers"}) {
+		t.Errorf("unexpected names %v", names)
+	}
+
+	registry.Unregister("subscribers")
+	if _, err := registry.Get("subscribers"); !errors.Is(err, ErrClientNotRegistered) {
+		t.Errorf("expected ErrClientNotRegistered after Unregister, got %v", err)
+	}
+}
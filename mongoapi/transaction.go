@@ -0,0 +1,144 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// TransactionPolicy selects how WithTransaction behaves on deployments without
+// transaction support, such as a standalone mongod
+type TransactionPolicy int
+
+const (
+	// TransactionRequired makes WithTransaction fail with ErrTransactionsNotSupported
+	TransactionRequired TransactionPolicy = iota
+	// TransactionBestEffort makes WithTransaction run the function without a transaction
+	TransactionBestEffort
+)
+
+// maxTransactionRetries bounds how often a transaction is retried after an error
+// labelled TransientTransactionError, and how often its commit is retried after an
+// error labelled UnknownTransactionCommitResult
+const maxTransactionRetries = 5
+
+const (
+	transientTransactionError      = "TransientTransactionError"
+	unknownTransactionCommitResult = "UnknownTransactionCommitResult"
+)
+
+// ErrTransactionsNotSupported is returned by WithTransaction when the deployment
+// does not support transactions and the policy is TransactionRequired
+var ErrTransactionsNotSupported = errors.New("deployment does not support transactions")
+
+// SetTransactionPolicy sets the behavior of WithTransaction on deployments without
+// transaction support. The default is TransactionRequired.
+func (c *MongoClient) SetTransactionPolicy(policy TransactionPolicy) {
+	c.transactionPolicy = policy
+}
+
+// WithTransaction runs fn in a transaction and commits it if fn returns nil. The whole
+// transaction is retried if it fails with a TransientTransactionError, and the commit
+// is retried if its outcome is unknown, so fn must be safe to run more than once.
+// Only operations that are passed txCtx take part in the transaction, hence fn should
+// use the WithContext variants of the RestfulAPI methods.
+func (c *MongoClient) WithTransaction(ctx context.Context, fn func(txCtx context.Context) error) (err error) {
+	defer c.observe("WithTransaction", "", time.Now(), nil, &err)
+	supported, err := c.SupportsTransactions()
+	if err != nil {
+		return fmt.Errorf("WithTransaction err: %w", err)
+	}
+	if !supported {
+		if c.transactionPolicy == TransactionBestEffort {
+			return fn(ctx)
+		}
+		return fmt.Errorf("WithTransaction err: %w", ErrTransactionsNotSupported)
+	}
+
+	session, err := c.Client.StartSession()
+	if err != nil {
+		return fmt.Errorf("WithTransaction StartSession err: %w", err)
+	}
+	defer session.EndSession(context.Background())
+
+	if err := retryTransaction(ctx, mongoSession{session: session}, fn); err != nil {
+		return fmt.Errorf("WithTransaction err: %w", err)
+	}
+	return nil
+}
+
+// transactionSession runs the transactions of WithTransaction
+type transactionSession interface {
+	startTransaction() error
+	commitTransaction(ctx context.Context) error
+	abortTransaction(ctx context.Context) error
+	// context returns ctx bound to the session, so that operations using it take part
+	// in the current transaction
+	context(ctx context.Context) context.Context
+}
+
+type mongoSession struct {
+	session *mongo.Session
+}
+
+func (s mongoSession) startTransaction() error {
+	return s.session.StartTransaction()
+}
+
+func (s mongoSession) commitTransaction(ctx context.Context) error {
+	return s.session.CommitTransaction(ctx)
+}
+
+func (s mongoSession) abortTransaction(ctx context.Context) error {
+	return s.session.AbortTransaction(ctx)
+}
+
+func (s mongoSession) context(ctx context.Context) context.Context {
+	return mongo.NewSessionContext(ctx, s.session)
+}
+
+// retryTransaction runs fn in a transaction of session and retries the transaction
+// while it fails with a TransientTransactionError
+func retryTransaction(ctx context.Context, session transactionSession, fn func(txCtx context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		err := runTransaction(ctx, session, fn)
+		if err == nil {
+			return nil
+		}
+		if !hasErrorLabel(err, transientTransactionError) || attempt >= maxTransactionRetries || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+// runTransaction executes fn in a single transaction of session and commits it
+func runTransaction(ctx context.Context, session transactionSession, fn func(txCtx context.Context) error) error {
+	if err := session.startTransaction(); err != nil {
+		return err
+	}
+	if err := fn(session.context(ctx)); err != nil {
+		_ = session.abortTransaction(context.Background())
+		return err
+	}
+	for attempt := 0; ; attempt++ {
+		err := session.commitTransaction(ctx)
+		if err == nil {
+			return nil
+		}
+		if !hasErrorLabel(err, unknownTransactionCommitResult) || attempt >= maxTransactionRetries || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+func hasErrorLabel(err error, label string) bool {
+	var labeled mongo.LabeledError
+	return errors.As(err, &labeled) && labeled.HasErrorLabel(label)
+}
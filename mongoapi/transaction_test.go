@@ -0,0 +1,144 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func TestHasErrorLabel(t *testing.T) {
+	transient := mongo.CommandError{Code: 112, Labels: []string{transientTransactionError}}
+
+	if !hasErrorLabel(transient, transientTransactionError) {
+		t.Error("hasErrorLabel() should find the label of a command error")
+	}
+	if !hasErrorLabel(fmt.Errorf("wrapped: %w", transient), transientTransactionError) {
+		t.Error("hasErrorLabel() should find the label of a wrapped command error")
+	}
+	if hasErrorLabel(transient, unknownTransactionCommitResult) {
+		t.Error("hasErrorLabel() should not find a missing label")
+	}
+	if hasErrorLabel(errors.New("plain"), transientTransactionError) {
+		t.Error("hasErrorLabel() should not find a label on a plain error")
+	}
+}
+
+// fakeSession fails the transactions and commits with the queued errors, and nil once
+// the queue is empty
+type fakeSession struct {
+	runErrs    []error
+	commitErrs []error
+	started    int
+	commits    int
+	aborts     int
+}
+
+func (s *fakeSession) startTransaction() error {
+	s.started++
+	return nil
+}
+
+func (s *fakeSession) commitTransaction(ctx context.Context) error {
+	s.commits++
+	return popError(&s.commitErrs)
+}
+
+func (s *fakeSession) abortTransaction(ctx context.Context) error {
+	s.aborts++
+	return nil
+}
+
+func (s *fakeSession) context(ctx context.Context) context.Context {
+	return ctx
+}
+
+func popError(errs *[]error) error {
+	if len(*errs) == 0 {
+		return nil
+	}
+	err := (*errs)[0]
+	*errs = (*errs)[1:]
+	return err
+}
+
+func repeatError(err error, n int) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
+
+func TestRetryTransaction(t *testing.T) {
+	transient := mongo.CommandError{Code: 112, Labels: []string{transientTransactionError}}
+	unknown := mongo.CommandError{Code: 91, Labels: []string{unknownTransactionCommitResult}}
+	fatal := mongo.CommandError{Code: 2}
+
+	testCases := []struct {
+		name       string
+		runErrs    []error
+		commitErrs []error
+		expected   error
+		started    int
+		commits    int
+		aborts     int
+	}{
+		{name: "success", started: 1, commits: 1},
+		{name: "transient error retries the transaction", runErrs: repeatError(transient, 2), started: 3, commits: 1, aborts: 2},
+		{name: "transient commit error retries the transaction", commitErrs: []error{transient}, started: 2, commits: 2},
+		{name: "unknown commit result retries the commit", commitErrs: repeatError(unknown, 2), started: 1, commits: 3},
+		{
+			name: "transient errors are retried at most maxTransactionRetries times", runErrs: repeatError(transient, 10),
+			expected: transient, started: maxTransactionRetries + 1, aborts: maxTransactionRetries + 1,
+		},
+		{
+			name: "unknown commit results are retried at most maxTransactionRetries times", commitErrs: repeatError(unknown, 10),
+			expected: unknown, started: 1, commits: maxTransactionRetries + 1,
+		},
+		{name: "other errors are not retried", runErrs: []error{fatal}, expected: fatal, started: 1, aborts: 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			session := &fakeSession{runErrs: tc.runErrs, commitErrs: tc.commitErrs}
+			err := retryTransaction(context.Background(), session, func(txCtx context.Context) error {
+				return popError(&session.runErrs)
+			})
+			if fmt.Sprint(err) != fmt.Sprint(tc.expected) {
+				t.Errorf("retryTransaction() = %v, expected %v", err, tc.expected)
+			}
+			if session.started != tc.started || session.commits != tc.commits || session.aborts != tc.aborts {
+				t.Errorf("started %d, committed %d and aborted %d times, expected %d, %d and %d",
+					session.started, session.commits, session.aborts, tc.started, tc.commits, tc.aborts)
+			}
+		})
+	}
+}
+
+func TestWithTransactionPolicy(t *testing.T) {
+	unsupported := false
+	c := &MongoClient{}
+	c.transactionSupport.Store(&unsupported)
+	ran := false
+	fn := func(txCtx context.Context) error {
+		ran = true
+		return nil
+	}
+
+	if err := c.WithTransaction(context.Background(), fn); !errors.Is(err, ErrTransactionsNotSupported) || ran {
+		t.Errorf("WithTransaction() = %v, expected ErrTransactionsNotSupported without running fn", err)
+	}
+	c.SetTransactionPolicy(TransactionBestEffort)
+	if err := c.WithTransaction(context.Background(), fn); err != nil || !ran {
+		t.Errorf("WithTransaction() = %v, expected fn to run without a transaction", err)
+	}
+	if scoped := c.Database("other"); scoped.transactionSupport.Load() == nil {
+		t.Error("Database() should keep the cached transaction support")
+	}
+}
@@ -0,0 +1,219 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/omec-project/util/logger"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type ChangeEventType string
+
+const (
+	ChangeInsert  ChangeEventType = "insert"
+	ChangeUpdate  ChangeEventType = "update"
+	ChangeReplace ChangeEventType = "replace"
+	ChangeDelete  ChangeEventType = "delete"
+)
+
+// ChangeEvent is a change of a document of a watched collection
+type ChangeEvent struct {
+	Type ChangeEventType
+	// DocumentKey holds the _id of the changed document
+	DocumentKey map[string]any
+	// FullDocument holds the document after an insert or replace, and after an update
+	// if WatchOptions.FullDocumentOnUpdate is set and the document still exists
+	FullDocument map[string]any
+	// UpdatedFields and RemovedFields describe the changes of an update
+	UpdatedFields map[string]any
+	RemovedFields []string
+	ClusterTime   bson.Timestamp
+	// ResumeToken can be passed in WatchOptions.ResumeAfter to resume watching
+	// after this event
+	ResumeToken bson.Raw
+	// Err is set if the event could not be decoded or its encrypted fields could not
+	// be decrypted, the other fields but ResumeToken may then be incomplete
+	Err error
+}
+
+// WatchOptions configures a change stream subscription, the zero value selects the defaults
+type WatchOptions struct {
+	// FullDocumentOnUpdate looks up the current document for update events
+	FullDocumentOnUpdate bool
+	// ResumeAfter resumes watching after the event with this resume token
+	ResumeAfter bson.Raw
+	// BufferSize is the capacity of the event channel, 16 by default
+	BufferSize int
+	// RetryInterval is the delay before reopening a failed change stream, 5 seconds by default
+	RetryInterval time.Duration
+}
+
+const (
+	defaultWatchBufferSize    = 16
+	defaultWatchRetryInterval = 5 * time.Second
+)
+
+type changeStreamDoc struct {
+	OperationType     string            `bson:"operationType"`
+	DocumentKey       map[string]any    `bson:"documentKey,omitempty"`
+	FullDocument      map[string]any    `bson:"fullDocument,omitempty"`
+	UpdateDescription updateDescription `bson:"updateDescription,omitempty"`
+	ClusterTime       bson.Timestamp    `bson:"clusterTime,omitempty"`
+}
+
+type updateDescription struct {
+	UpdatedFields map[string]any `bson:"updatedFields,omitempty"`
+	RemovedFields []string       `bson:"removedFields,omitempty"`
+}
+
+// Watch subscribes to the insert, update, replace and delete events of collName that
+// match filter. The filter applies to the change event, for example
+// bson.M{"fullDocument.type": "chunk"} or bson.M{"operationType": "delete"}.
+// Events are delivered in order on the returned channel, which is closed once ctx is
+// done, and events that cannot be decoded or decrypted are delivered with Err set. If
+// the change stream fails after it has been opened, it is reopened after RetryInterval
+// and resumed after the last event the stream returned, so no event is lost as long as
+// it is still present in the oplog.
+func (c *MongoClient) Watch(ctx context.Context, collName string, filter bson.M, opts *WatchOptions) (_ <-chan ChangeEvent, err error) {
+	defer c.observe("Watch", collName, time.Now(), nil, &err)
+	if opts == nil {
+		opts = &WatchOptions{}
+	}
+	bufferSize := opts.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultWatchBufferSize
+	}
+	retryInterval := opts.RetryInterval
+	if retryInterval <= 0 {
+		retryInterval = defaultWatchRetryInterval
+	}
+
+	collection := c.Client.Database(c.dbName).Collection(collName)
+	pipeline := watchPipeline(filter)
+	stream, err := openChangeStream(ctx, collection, pipeline, opts.FullDocumentOnUpdate, opts.ResumeAfter)
+	if err != nil {
+		return nil, fmt.Errorf("Watch err: %w", err)
+	}
+
+	fields := c.fieldCipher(collName)
+	events := make(chan ChangeEvent, bufferSize)
+	go func(stream *mongo.ChangeStream) {
+		defer close(events)
+		resumeToken := opts.ResumeAfter
+		for {
+			resumeToken = iterateWatchStream(ctx, stream, fields, events, resumeToken)
+			_ = stream.Close(context.Background())
+			if ctx.Err() != nil {
+				return
+			}
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(retryInterval):
+				}
+				var err error
+				stream, err = openChangeStream(ctx, collection, pipeline, opts.FullDocumentOnUpdate, resumeToken)
+				if err == nil {
+					break
+				}
+				logger.MongoapiLog.Warnf("failed to reopen change stream on %s: %v", collName, err)
+			}
+		}
+	}(stream)
+	return events, nil
+}
+
+// iterateWatchStream delivers the events of stream with their encrypted fields
+// decrypted until it fails or ctx is done, and returns the resume token to reopen the
+// stream with. The token is recorded after every batch, including the empty batches
+// whose post batch resume token moves past the events filtered out by the pipeline.
+func iterateWatchStream(ctx context.Context, stream *mongo.ChangeStream, fields *fieldCipher,
+	events chan<- ChangeEvent, resumeToken bson.Raw,
+) bson.Raw {
+	for {
+		if stream.TryNext(ctx) {
+			select {
+			case events <- changeEvent(stream.Current, stream.ResumeToken(), fields):
+			case <-ctx.Done():
+				return resumeToken
+			}
+		} else if stream.Err() != nil || ctx.Err() != nil || stream.ID() == 0 {
+			break
+		}
+		if token := stream.ResumeToken(); token != nil {
+			resumeToken = token
+		}
+	}
+	if err := stream.Err(); err != nil && ctx.Err() == nil {
+		logger.MongoapiLog.Warnf("change stream failed, resuming: %v", err)
+	}
+	return resumeToken
+}
+
+// changeEvent decodes raw and decrypts its encrypted fields, a failure is reported in
+// the Err of the event
+func changeEvent(raw bson.Raw, resumeToken bson.Raw, fields *fieldCipher) ChangeEvent {
+	event, err := decodeChangeEvent(raw)
+	if err == nil {
+		err = fields.decryptEvent(&event)
+	}
+	if err != nil {
+		event.Err = fmt.Errorf("Watch err: failed to decode change event: %w", classifyError(err))
+	}
+	event.ResumeToken = resumeToken
+	return event
+}
+
+func openChangeStream(ctx context.Context, collection *mongo.Collection, pipeline mongo.Pipeline,
+	fullDocumentOnUpdate bool, resumeToken bson.Raw,
+) (*mongo.ChangeStream, error) {
+	streamOpts := options.ChangeStream()
+	if fullDocumentOnUpdate {
+		streamOpts.SetFullDocument(options.UpdateLookup)
+	}
+	if resumeToken != nil {
+		// unlike resumeAfter, startAfter also accepts the token of an invalidate event
+		streamOpts.SetStartAfter(resumeToken)
+	}
+	return collection.Watch(ctx, pipeline, streamOpts)
+}
+
+func watchPipeline(filter bson.M) mongo.Pipeline {
+	operationTypes := bson.A{string(ChangeInsert), string(ChangeUpdate), string(ChangeReplace), string(ChangeDelete)}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": operationTypes}}}},
+	}
+	if len(filter) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: filter}})
+	}
+	return pipeline
+}
+
+func decodeChangeEvent(raw bson.Raw) (ChangeEvent, error) {
+	doc := changeStreamDoc{}
+	decoder := bson.NewDecoder(bson.NewDocumentReader(bytes.NewReader(raw)))
+	decoder.DefaultDocumentMap()
+	if err := decoder.Decode(&doc); err != nil {
+		return ChangeEvent{}, err
+	}
+	delete(doc.FullDocument, VersionField)
+	delete(doc.UpdateDescription.UpdatedFields, VersionField)
+	return ChangeEvent{
+		Type:          ChangeEventType(doc.OperationType),
+		DocumentKey:   doc.DocumentKey,
+		FullDocument:  doc.FullDocument,
+		UpdatedFields: doc.UpdateDescription.UpdatedFields,
+		RemovedFields: doc.UpdateDescription.RemovedFields,
+		ClusterTime:   doc.ClusterTime,
+	}, nil
+}
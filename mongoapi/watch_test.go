@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: 2026 Intel Corporation
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mongoapi
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func TestDecodeChangeEvent(t *testing.T) {
+	raw, err := bson.Marshal(bson.M{
+		"_id":           bson.M{"_data": "8263"},
+		"operationType": "update",
+		"clusterTime":   bson.Timestamp{T: 1661402697, I: 1},
+		"documentKey":   bson.M{"_id": "chunkid-8332"},
+		"ns":            bson.M{"db": "sdcore", "coll": "ngapid"},
+		"updateDescription": bson.M{
+			"updatedFields": bson.M{"podId": "amf-1", "owner": bson.M{"ip": "10.0.0.1"}},
+			"removedFields": bson.A{"expireAt"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	event, err := decodeChangeEvent(raw)
+	if err != nil {
+		t.Fatalf("decodeChangeEvent() failed: %v", err)
+	}
+	expected := ChangeEvent{
+		Type:          ChangeUpdate,
+		DocumentKey:   map[string]any{"_id": "chunkid-8332"},
+		UpdatedFields: map[string]any{"podId": "amf-1", "owner": map[string]any{"ip": "10.0.0.1"}},
+		RemovedFields: []string{"expireAt"},
+		ClusterTime:   bson.Timestamp{T: 1661402697, I: 1},
+	}
+	if !reflect.DeepEqual(event, expected) {
+		t.Errorf("decodeChangeEvent() = %+v, expected %+v", event, expected)
+	}
+}
+
+func TestChangeEventDecryptionFailure(t *testing.T) {
+	fields := &fieldCipher{provider: testKeyProvider(t, "k1"), collection: "auth", paths: []string{"k"}}
+	doc, err := fields.encrypt(map[string]any{"_id": "imsi-1", "k": "secret"}, "")
+	if err != nil {
+		t.Fatalf("encrypt() failed: %v", err)
+	}
+	raw, err := bson.Marshal(bson.M{"operationType": "insert", "fullDocument": doc})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	token, err := bson.Marshal(bson.M{"_data": "8264"})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	if event := changeEvent(raw, token, fields); event.Err != nil || event.FullDocument["k"] != "secret" {
+		t.Errorf("changeEvent() = %+v, expected the decrypted document", event)
+	}
+	// the ciphertext is bound to its collection, so another collection cannot decrypt it
+	other := &fieldCipher{provider: fields.provider, collection: "other", paths: []string{"k"}}
+	event := changeEvent(raw, token, other)
+	if !errors.Is(event.Err, ErrDecryption) {
+		t.Errorf("changeEvent() err = %v, expected ErrDecryption", event.Err)
+	}
+	if !bytes.Equal(event.ResumeToken, token) {
+		t.Errorf("changeEvent() of a failed event should keep the resume token, got %v", event.ResumeToken)
+	}
+}
+
+func TestWatchPipeline(t *testing.T) {
+	operationTypes := bson.D{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}}}}}
+
+	pipeline := watchPipeline(nil)
+	if len(pipeline) != 1 || !reflect.DeepEqual(pipeline[0], operationTypes) {
+		t.Errorf("unexpected pipeline without filter: %v", pipeline)
+	}
+
+	filter := bson.M{"fullDocument.type": "chunk"}
+	pipeline = watchPipeline(filter)
+	expected := mongo.Pipeline{operationTypes, {{Key: "$match", Value: filter}}}
+	if len(pipeline) != 2 || !reflect.DeepEqual(pipeline, expected) {
+		t.Errorf("unexpected pipeline with filter: %v", pipeline)
+	}
+}